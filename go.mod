@@ -4,9 +4,10 @@ go 1.17
 
 require (
 	cloud.google.com/go/firestore v1.5.0
-	github.com/bwmarrin/discordgo v0.23.3-0.20210821175000-0fad116c6c2a
+	github.com/bwmarrin/discordgo v0.24.0
 	github.com/joho/godotenv v1.3.0
 	github.com/rs/zerolog v1.24.0
+	golang.org/x/image v0.10.0
 	google.golang.org/api v0.40.0
 	google.golang.org/grpc v1.35.0
 )
@@ -20,15 +21,14 @@ require (
 	github.com/gorilla/websocket v1.4.2 // indirect
 	github.com/jstemmer/go-junit-report v0.9.1 // indirect
 	go.opencensus.io v0.22.5 // indirect
-	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5 // indirect
+	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519 // indirect
 	golang.org/x/lint v0.0.0-20201208152925-83fdc39ff7b5 // indirect
-	golang.org/x/mod v0.4.2 // indirect
-	golang.org/x/net v0.0.0-20210405180319-a5a99cb37ef4 // indirect
+	golang.org/x/mod v0.8.0 // indirect
+	golang.org/x/net v0.6.0 // indirect
 	golang.org/x/oauth2 v0.0.0-20210218202405-ba52d332ba99 // indirect
-	golang.org/x/sys v0.0.0-20210906170528-6f6e22806c34 // indirect
-	golang.org/x/text v0.3.4 // indirect
-	golang.org/x/tools v0.1.5 // indirect
-	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
+	golang.org/x/sys v0.5.0 // indirect
+	golang.org/x/text v0.11.0 // indirect
+	golang.org/x/tools v0.6.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/genproto v0.0.0-20210222152913-aa3ee6e6a81c // indirect
 	google.golang.org/protobuf v1.25.0 // indirect
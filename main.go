@@ -1,21 +1,42 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"image"
+	_ "image/gif" // Registered for decoding when converting images
+	"image/jpeg"
+	"image/png"
+	"io"
 	"io/fs"
 	"math/rand"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"path"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"cloud.google.com/go/firestore"
 	"github.com/bwmarrin/discordgo"
 	"github.com/joho/godotenv"
 	"github.com/rs/zerolog"
+	_ "golang.org/x/image/webp" // Registered for decoding when converting images, like image/gif
 	"google.golang.org/api/option"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -25,19 +46,332 @@ var (
 	log             zerolog.Logger
 	s               *discordgo.Session
 	firestoreClient *firestore.Client
-	ctx             = context.Background()
+	ctx             = context.Background() // For long-lived work; interaction handlers use requestContext instead
 	config          = map[string]string{
 		"botToken":                         "",
 		"guildId":                          "",
 		"googleApplicationCredentialsPath": "",
 		"projectId":                        "",
 	}
+
+	requestTimeout = 5 * time.Second // How long an interaction handler's Firestore calls may take (see requestContext)
+
+	multiGuild bool // Whether commands are registered globally, so that any guild the bot is invited to can use them with its own GuildConfig; guildId then only names the home guild, whose settings still apply everywhere. Galleries are shared by every guild, so only the home guild can manage them (see homeGuildOnly).
+
+	auditConcurrency   = 8               // Maximum number of image checks audit_all runs at once
+	auditTimeout       = 2 * time.Minute // Upper bound on how long audit_all may spend checking images
+	auditMaxImageBytes = 8 * 1024 * 1024 // Images larger than this are reported as oversized
+
+	hashImageContent bool // Whether add_image downloads images to hash their content, so a ban also catches the same image under another link
+
+	verifyImageReachable bool // Whether add_image refuses links that don't answer a HEAD request with an image (see checkImageReachable); leave unset to work offline
+
+	autoDisableBrokenGalleries bool // Whether audit_all disables galleries in which every image is broken, so random and pick stop serving them
+
+	viewFlushInterval = time.Minute // How often buffered view counts are written to Firestore
+
+	attachmentRefreshInterval = 6 * time.Hour // How often stored Discord attachment links are re-signed before they expire; 0 disables this
+
+	galleryCacheRefreshInterval = 15 * time.Minute // How often the cached gallery names are reloaded, to pick up galleries changed outside of the bot; 0 disables this
+
+	progressUpdateInterval = 3 * time.Second // Minimum time between progress edits of a long-running command's response
+
+	importChannelMaxImages   = 500  // Upper bound on the images a single import_channel may add
+	importChannelMaxMessages = 5000 // Upper bound on the messages a single import_channel may scan
+
+	fetchConcurrency = 4   // Upper bound on concurrent Firestore reads when loading every gallery
+	fetchChunkSize   = 100 // Galleries requested per Firestore read when loading every gallery
+
+	commandUpdateAttempts = 3                      // Tries for each Discord API call made by updateCommands
+	commandUpdateBackoff  = 250 * time.Millisecond // Wait before the first retry of a failed call, doubling after each one
+
+	pollReactions = []string{"👍", "👎"} // The reactions added to a poll for people to vote with
+	pollDuration  = 10 * time.Minute   // How long a poll runs, unless the poll subcommand says otherwise
+
+	exportMaxArchiveBytes = 8 * 1024 * 1024 // export_all splits its output so that no archive exceeds Discord's upload limit
+
+	starterGalleries = []string{"memes", "art", "pets"} // The galleries gallery_admin setup creates
+
+	deleteConfirmThreshold = 50 // Deleting a gallery with more images than this takes a second confirmation
+
+	// The buttons on the remove_image and delete confirmations, e.g. to translate or soften them
+	deleteConfirmLabel      = "Yes, delete"
+	deleteConfirmLargeLabel = "Yes, delete all %d images" // The second confirmation for deleting a gallery with more than deleteConfirmThreshold images; %d is replaced with how many
+	deleteConfirmStyle      = discordgo.DangerButton
+	deleteCancelLabel       = "No, cancel"
+	deleteCancelStyle       = discordgo.SecondaryButton
+
+	storageWarnPercent = 80 // gallery_admin sizes flags galleries using more than this percentage of a Firestore document
+
+	grantDuration = 15 * time.Minute // How long a gallery_admin grant can be used for before it lapses
+
+	convertTimeout   = 10 * time.Second // Upper bound on downloading and re-encoding an image for convert_to
+	convertMaxPixels = 40 * 1000 * 1000 // Images with more pixels than this aren't decoded for convert_to, to bound memory use
+
+	recentErrorsSize = 20 // How many error-level log events gallery_ops errors can show
+
+	// The HTTP endpoint that lets other tools add images, off unless webhookAddress is set (e.g. to ":8080")
+	webhookAddress  string
+	webhookApiKey   string      // Required with webhookAddress; callers send it as "Authorization: Bearer <key>"
+	webhookAuthorId = "webhook" // Recorded as the authorId of images added through the endpoint, e.g. the ID of a Discord account for the integration
+
+	repostCheckInterval = time.Minute // How often the schedules collection is checked for reposts that are due
+	repostMinInterval   = time.Hour   // The shortest interval gallery_ops repost accepts
+
+	// Subcommands whose grant is spent on the confirmation button rather than the command itself, so the grantee can finish what they started
+	grantSpentOnConfirm = map[string]bool{
+		"dedupe":  true,
+		"similar": true,
+		"empties": true,
+	}
+
+	similarityThreshold = 80 // The percentage of the smaller gallery's images that must also be in the larger one for gallery_admin similar to suggest merging them
+
+	firestoreSelfTest = "off" // Whether to check at startup that Firestore lets the bot write, read, and delete: "off", "warn" (log a failure), or "fatal" (exit on a failure)
+
+	managerRoleIds     []string // If set, only members holding one of these roles (or administrators) can use managedSubcommands; a guild's own ManagerRoleIDs take precedence
+	managedSubcommands = map[string]bool{"create": true, "delete": true, "remove_image": true, "rename": true, "copy": true, "merge": true}
+
+	// Subcommands that are always available, regardless of the features setting
+	coreSubcommands = map[string]bool{
+		"random":         true,
+		"pick":           true,
+		"add_image":      true,
+		"remove_image":   true,
+		"create":         true,
+		"delete":         true,
+		"help":           true,
+		"toggle_command": true, // Otherwise it could switch itself off for good
+		"pause":          true,
+		"resume":         true, // Otherwise a paused bot could be stuck that way
+	}
+	enabledFeatures map[string]bool // Subcommands (beyond coreSubcommands) to offer, or nil for all of them
+
+	guildEnabledCommands      map[string]bool // Subcommands (beyond coreSubcommands) the guild's settings enable, or nil for all of them
+	guildEnabledCommandsMutex sync.RWMutex
+
+	guildTagRules      []TagRule // The guild's automatic tagging rules (see applyTagRules)
+	guildTagRulesMutex sync.RWMutex
+
+	guildCategories      map[string][]string // The guild's gallery categories (see getRandomImageFromCategory)
+	guildCategoriesMutex sync.RWMutex
+
+	guildConfigs      = make(map[string]cachedGuildConfig) // By guild ID, so that every interaction doesn't have to read its guild's overrides (see lookupGuildConfig)
+	guildConfigsMutex sync.Mutex
+
+	unknownSubcommandMessage = "Invalid subcommand :stop_sign:\nRun `/gallery help` to see what's available." // Shown when Discord sends a subcommand no handler recognizes
+
+	httpClient             = &http.Client{Timeout: 10 * time.Second}
+	reachabilityHTTPClient = &http.Client{Timeout: 5 * time.Second} // Kept short since add_image waits on it (see checkImageReachable)
+
+	// Views are buffered here (by gallery, then by image URL) rather than written on every serve
+	pendingViews      = make(map[string]map[string]int)
+	pendingViewsMutex sync.Mutex
+
+	// Query parameters removed from added image links when stripTrackingParams is set. A trailing "*" matches any suffix.
+	stripTrackingParams bool
+	trackingParams      = []string{"utm_*", "fbclid", "gclid", "igshid", "mc_cid", "mc_eid"}
+	trackingParamsKeep  []string // Never stripped, even if they match trackingParams (e.g. signatures some hosts need to serve the image)
+
+	hostRemaps map[string]string // Image link hosts to swap for another when displaying, e.g. a retired CDN for its replacement
+
+	autoDeleteDelays map[string]time.Duration // How long to leave the response to each listed gallery subcommand up before deleting it
+
+	activityUpdateInterval = 5 * time.Minute // Minimum time between recording activity on the same gallery, to avoid a write for every read
+	activityUpdates        = make(map[string]time.Time)
+	activityUpdatesMutex   sync.Mutex
+
+	galleryCount int64 = -1 // len(galleryNames) (accessed atomically), or -1 if it hasn't been loaded yet
+
+	galleryNames      map[string]bool // Every gallery's name, loaded by refreshGalleryCache and kept current in between by noteGalleryCreated and noteGalleryDeleted
+	galleryNamesMutex sync.RWMutex
+
+	paused int32 // Set (accessed atomically) while gallery_admin pause is in effect, turning away everyone but administrators
+
+	acknowledgedInteractions      = make(map[string]time.Time) // When each interaction was first responded to, so later outputs go out as followups
+	acknowledgedInteractionsMutex sync.Mutex
+
+	removedImages      = make(map[string]removedImageUndo) // By the ID of the message confirming the removal, for image_undo
+	removedImagesMutex sync.Mutex
+
+	adminGrants      = make(map[string]time.Time) // When each gallery_admin grant (by grantKey) stops being usable
+	adminGrantsMutex sync.Mutex
+
+	recentErrors errorRing // The latest error-level log events, for gallery_ops errors
+
+	interactionCommands      = make(map[string]interactionCommand) // What each recent interaction (by ID) was invoking, so errors logged about it can say
+	interactionCommandsMutex sync.Mutex
+)
+
+// Discord rejects an entire message if any part of an embed is longer than these limits
+const (
+	maxEmbedTitleLength       = 256
+	maxEmbedDescriptionLength = 4096
+	maxEmbedFieldNameLength   = 256
+	maxEmbedFieldValueLength  = 1024
+	maxEmbedFooterLength      = 2048
+)
+
+// maxAltTextLength keeps an image's alt text short enough to be shown as an embed field
+const maxAltTextLength = maxEmbedFieldValueLength
+
+// maxCaptionLength keeps an image's caption well within an embed description, leaving room for whatever else the description says
+const maxCaptionLength = 1000
+
+// captionFileMaxBytes bounds how much of a caption_bulk mapping file is downloaded
+const captionFileMaxBytes = 1024 * 1024
+
+// maxGalleryNameLength keeps gallery names usable as command choices, which Discord limits to 100 characters
+const maxGalleryNameLength = 100
+
+// maxAutocompleteChoices is how many suggestions Discord accepts in response to an autocomplete interaction
+const maxAutocompleteChoices = 25
+
+// maxNewGalleryNameLength is the longest name a gallery can be given (see validateGalleryName)
+const maxNewGalleryNameLength = 32
+
+// galleryNamePattern is what new gallery names must look like, so that they read well in footers and choices and are always usable as document IDs
+var galleryNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// Discord refuses a second response to an interaction with this error code, and stops accepting followups once the token expires
+const (
+	discordErrorInteractionAcknowledged = 40060
+	interactionTokenLifetime            = 15 * time.Minute
 )
 
+// maxCustomIDLength is the longest CustomID Discord accepts for a message component
+const maxCustomIDLength = 100
+
+// maxPollMinutes is the longest a poll can be asked to stay open for (a week), which also keeps its timer from overflowing
+const maxPollMinutes = 7 * 24 * 60
+
+// maxMirrorHours is the longest mirror can be asked to run for before stopping (a year), which also keeps its end time from overflowing
+const maxMirrorHours = 365 * 24
+
+// maxButtonLabelLength is the longest label Discord accepts for a button
+const maxButtonLabelLength = 80
+
+// messageFlagsEphemeral marks an interaction response as only visible to the user who invoked it
+const messageFlagsEphemeral = 1 << 6
+
 type Gallery struct {
-	Images []map[string]string `firestore:"images"`
+	Images              []Image   `firestore:"images" json:"images"`
+	ExcludeFromSchedule bool      `firestore:"excludeFromSchedule" json:"excludeFromSchedule"` // Scheduled posting should never pick this gallery
+	FeaturedIndex       *int      `firestore:"featuredIndex" json:"featuredIndex"`             // The image shown by the featured subcommand, if any
+	Locked              bool      `firestore:"locked" json:"locked"`                           // Images can't be added, removed, or rewritten while set
+	AllowedFormats      []string  `firestore:"allowedFormats" json:"allowedFormats"`           // If not empty, only images in these formats (see imageFormats) can be added
+	LastInteractedBy    string    `firestore:"lastInteractedBy" json:"lastInteractedBy"`       // The user behind the most recent command naming this gallery (see noteGalleryActivity)
+	LastInteractedAt    time.Time `firestore:"lastInteractedAt" json:"lastInteractedAt"`
+	Disabled            bool      `firestore:"disabled" json:"disabled"`                 // Set by audit_all when none of the images load (see autoDisableBrokenGalleries); adding an image clears it
+	FallbackImageURL    string    `firestore:"fallbackImageUrl" json:"fallbackImageUrl"` // Shown by random, pick, and carousel while there are no images
+	MirrorChannelID     string    `firestore:"mirrorChannelId" json:"mirrorChannelId"`   // If set, every image added is also posted here (see mirrorImageAdd)
+	MirrorUntil         time.Time `firestore:"mirrorUntil" json:"mirrorUntil"`           // When mirroring stops, or zero to keep going
+	CreatedAt           time.Time `firestore:"createdAt" json:"createdAt"`               // Zero for galleries created before this was recorded
+	CopiedFrom          string    `firestore:"copiedFrom" json:"copiedFrom"`             // The gallery this one was copied from, if it was (see copyGallery)
+	CopiedBy            string    `firestore:"copiedBy" json:"copiedBy"`                 // The user who copied it
+}
+
+// Image is one image in a gallery
+// Its fields keep the keys images were stored under back when they were plain maps, so older galleries load unchanged. Tags are stored joined by commas (see parseTags) and views as a decimal string for the same reason.
+type Image struct {
+	ImageURL            string `firestore:"imageUrl" json:"imageUrl"`
+	Timestamp           string `firestore:"timestamp" json:"timestamp"` // Unix seconds of when the image was added
+	AuthorID            string `firestore:"authorId" json:"authorId"`
+	Alt                 string `firestore:"alt,omitempty" json:"alt,omitempty"`
+	Caption             string `firestore:"caption,omitempty" json:"caption,omitempty"`
+	Tags                string `firestore:"tags,omitempty" json:"tags,omitempty"`
+	Views               string `firestore:"views,omitempty" json:"views,omitempty"`
+	ContentHash         string `firestore:"contentHash,omitempty" json:"contentHash,omitempty"`                 // See hashImageBytes
+	SourceInteractionID string `firestore:"sourceInteractionId,omitempty" json:"sourceInteractionId,omitempty"` // The add_image interaction, which lets find_image trace a reply back to the image
+	SourceMessageID     string `firestore:"sourceMessageId,omitempty" json:"sourceMessageId,omitempty"`         // The message an image was imported from
+}
+
+// GuildSettings holds the per-guild settings administrators can change from Discord, stored in the "settings" collection under the guild's ID
+type GuildSettings struct {
+	EnabledCommands []string            `firestore:"enabledCommands"` // Subcommands (beyond coreSubcommands) to offer, or empty for all of them
+	Paused          bool                `firestore:"paused"`          // Start paused, because gallery_admin pause was asked to persist
+	TagRules        []TagRule           `firestore:"tagRules"`        // Applied to every image added, in order
+	Categories      map[string][]string `firestore:"categories"`      // Category names to the galleries random_category pools
+}
+
+// GuildConfig holds one guild's overrides, stored in the "guildConfigs" collection under the guild's ID
+// Unlike GuildSettings, which the home guild's settings apply to the whole bot, these are looked up for each interaction by the guild it came from.
+type GuildConfig struct {
+	MaxImagesPerGallery int      `firestore:"maxImagesPerGallery"` // add_image refuses images past this many, or 0 for no limit
+	AdminRoleIDs        []string `firestore:"adminRoleIds"`        // Members holding any of these roles count as administrators (see isAdmin)
+	ManagerRoleIDs      []string `firestore:"managerRoleIds"`      // Replaces managerRoleIds for the guild if not empty (see canManageGalleries)
+	AuditLogChannelID   string   `firestore:"auditLogChannelId"`   // If set, galleries created or deleted and images added or removed from the guild are posted here
+}
+
+type cachedGuildConfig struct {
+	config  GuildConfig
+	expires time.Time
+}
+
+// guildConfigCacheTTL is how long a guild's overrides are trusted before they're read again, so changes made outside of guild_config still take effect
+const guildConfigCacheTTL = time.Minute
+
+// TagRule tags images whose link matches Pattern (see matchesTagRule)
+type TagRule struct {
+	Pattern string `firestore:"pattern"`
+	Tag     string `firestore:"tag"`
+}
+
+// RepostSchedule posts one image to a channel over and over, stored in the "schedules" collection
+// The image is remembered by link rather than number so that it survives images before it being removed. A schedule without an interval is a reveal, which posts once and is then deleted.
+type RepostSchedule struct {
+	Gallery         string    `firestore:"gallery"`
+	ImageUrl        string    `firestore:"imageUrl"`
+	ChannelID       string    `firestore:"channelId"`
+	Every           string    `firestore:"every"`           // The interval as it was given, for display
+	IntervalSeconds int64     `firestore:"intervalSeconds"` // 0 for a reveal
+	NextRun         time.Time `firestore:"nextRun"`
+	CreatedBy       string    `firestore:"createdBy"`
+}
+
+// BannedImage blocks an image from being added to any gallery, stored in the "banned" collection under its hash
+type BannedImage struct {
+	Kind     string    `firestore:"kind"`     // "url" for a hash of the normalized link, or "content" for a hash of the image itself
+	Example  string    `firestore:"example"`  // The link the ban was made from, for reference
+	BannedBy string    `firestore:"bannedBy"` // The user who banned it
+	BannedAt time.Time `firestore:"bannedAt"`
+}
+
+// errGalleryLocked aborts a transaction that would have modified a locked gallery
+var errGalleryLocked = errors.New("gallery is locked")
+
+// errGalleryDisabled reports that a gallery's images aren't shown because audit_all disabled it (see autoDisableBrokenGalleries)
+var errGalleryDisabled = errors.New("gallery is disabled")
+
+// AuditEvent records a single mutation of a gallery in its "audit" subcollection so that past contents can be reconstructed
+// Index and Image describe the affected image for add/remove/edit events (for edits, Image is the image after the change), while Images holds the complete replacement contents for replace events.
+type AuditEvent struct {
+	Action    string    `firestore:"action"`
+	ActorID   string    `firestore:"actorId"`
+	Timestamp time.Time `firestore:"timestamp"`
+	Index     int       `firestore:"index"`
+	Image     *Image    `firestore:"image,omitempty"`
+	Images    []Image   `firestore:"images,omitempty"`
 }
 
+const (
+	auditActionCreate      = "create"
+	auditActionDelete      = "delete"
+	auditActionAddImage    = "add_image"
+	auditActionRemoveImage = "remove_image"
+	auditActionReplace     = "replace"
+	auditActionEditImage   = "edit_image"
+)
+
+// errInvalidImageNumber aborts a transaction that was given an image number the gallery doesn't have
+var errInvalidImageNumber = errors.New("invalid image number")
+
+// errNoMatchingImages aborts a transaction that found no images to act on
+var errNoMatchingImages = errors.New("no matching images")
+
+// errImageMoved aborts a transaction on an image number that now refers to a different image than the one the user confirmed
+var errImageMoved = errors.New("image changed since it was confirmed")
+
 // Initialize rand (with current time)
 func init() {
 	rand.Seed(time.Now().UnixNano())
@@ -87,7 +421,7 @@ func init() {
 		log.Debug().Msgf("Created log file '%s'", logPath)
 	}
 
-	multiWriter := zerolog.MultiLevelWriter(consoleWriter, logFile)
+	multiWriter := zerolog.MultiLevelWriter(consoleWriter, logFile, &recentErrors)
 
 	// Replace the current console-only logger with a new one based on a multi-writer
 	log = zerolog.New(multiWriter).With().Timestamp().Logger()
@@ -119,710 +453,11235 @@ func init() {
 	}
 }
 
-func populateGalleryChoices() (options []*discordgo.ApplicationCommandOptionChoice) {
-	galleries, err := firestoreClient.Collection("galleries").DocumentRefs(ctx).GetAll()
-	if err != nil {
-		log.Error().Err(err).Caller().Msg("Failed to get DocumentRefs from Firestore")
+// Initialize optional settings
+// Unlike the values in config, these have sensible defaults and only need to be present in the environment to override them. A value that is present but cannot be parsed is treated as a fatal misconfiguration.
+func init() {
+	lookupOptionalDuration("requestTimeout", &requestTimeout)
+	lookupOptionalInt("auditConcurrency", &auditConcurrency)
+	if auditConcurrency < 1 {
+		log.Fatal().Int("auditConcurrency", auditConcurrency).Msg("Environment value 'auditConcurrency' must be at least 1") // No workers would check any images, so audit_all would never finish
 	}
-	log.Debug().Msgf("Found %d galleries", len(galleries))
-	for _, v := range galleries {
-		// log.Debug().Msgf("Found gallery '%s'", v.ID)
-		options = append(options,
-			&discordgo.ApplicationCommandOptionChoice{
-				Name:  v.ID,
-				Value: v.ID,
-			},
-		)
+	lookupOptionalDuration("auditTimeout", &auditTimeout)
+	lookupOptionalInt("auditMaxImageBytes", &auditMaxImageBytes)
+	lookupOptionalBool("autoDisableBrokenGalleries", &autoDisableBrokenGalleries)
+	lookupOptionalBool("hashImageContent", &hashImageContent)
+	lookupOptionalBool("multiGuild", &multiGuild)
+	lookupOptionalBool("verifyImageReachable", &verifyImageReachable)
+	lookupOptionalDuration("viewFlushInterval", &viewFlushInterval)
+	lookupOptionalDuration("attachmentRefreshInterval", &attachmentRefreshInterval)
+	lookupOptionalDuration("galleryCacheRefreshInterval", &galleryCacheRefreshInterval)
+	lookupOptionalString("unknownSubcommandMessage", &unknownSubcommandMessage)
+	lookupOptionalDuration("progressUpdateInterval", &progressUpdateInterval)
+	lookupOptionalDuration("activityUpdateInterval", &activityUpdateInterval)
+	lookupOptionalInt("importChannelMaxImages", &importChannelMaxImages)
+	lookupOptionalInt("importChannelMaxMessages", &importChannelMaxMessages)
+	lookupOptionalInt("exportMaxArchiveBytes", &exportMaxArchiveBytes)
+	lookupOptionalInt("commandUpdateAttempts", &commandUpdateAttempts)
+	lookupOptionalDuration("commandUpdateBackoff", &commandUpdateBackoff)
+	lookupOptionalInt("fetchConcurrency", &fetchConcurrency)
+	lookupOptionalInt("fetchChunkSize", &fetchChunkSize)
+	lookupOptionalBool("stripTrackingParams", &stripTrackingParams)
+	lookupOptionalList("trackingParams", &trackingParams)
+	lookupOptionalList("trackingParamsKeep", &trackingParamsKeep)
+	lookupOptionalList("starterGalleries", &starterGalleries)
+	lookupOptionalList("pollReactions", &pollReactions)
+	lookupOptionalDuration("pollDuration", &pollDuration)
+	lookupOptionalList("managerRoleIds", &managerRoleIds)
+	lookupOptionalInt("deleteConfirmThreshold", &deleteConfirmThreshold)
+	lookupOptionalString("deleteConfirmLabel", &deleteConfirmLabel)
+	lookupOptionalString("deleteConfirmLargeLabel", &deleteConfirmLargeLabel)
+	lookupOptionalString("deleteCancelLabel", &deleteCancelLabel)
+	for key, label := range map[string]string{"deleteConfirmLabel": deleteConfirmLabel, "deleteConfirmLargeLabel": deleteConfirmLargeLabel, "deleteCancelLabel": deleteCancelLabel} {
+		if len(strings.TrimSpace(label)) == 0 || utf8.RuneCountInString(label) > maxButtonLabelLength {
+			log.Fatal().Str(key, label).Msgf("Environment value '%s' must be 1 to %d characters", key, maxButtonLabelLength)
+		}
+	}
+	lookupOptionalButtonStyle("deleteConfirmStyle", &deleteConfirmStyle)
+	lookupOptionalButtonStyle("deleteCancelStyle", &deleteCancelStyle)
+	lookupOptionalInt("storageWarnPercent", &storageWarnPercent)
+	lookupOptionalDuration("grantDuration", &grantDuration)
+	lookupOptionalDuration("convertTimeout", &convertTimeout)
+	lookupOptionalInt("convertMaxPixels", &convertMaxPixels)
+	lookupOptionalInt("recentErrorsSize", &recentErrorsSize)
+	lookupOptionalString("webhookAddress", &webhookAddress)
+	lookupOptionalString("webhookApiKey", &webhookApiKey)
+	lookupOptionalString("webhookAuthorId", &webhookAuthorId)
+	if len(webhookAddress) > 0 && len(webhookApiKey) == 0 {
+		log.Fatal().Msg("Environment value 'webhookApiKey' must be set when 'webhookAddress' is")
+	}
+	lookupOptionalDuration("repostCheckInterval", &repostCheckInterval)
+	lookupOptionalDuration("repostMinInterval", &repostMinInterval)
+	lookupOptionalInt("similarityThreshold", &similarityThreshold)
+	lookupOptionalString("firestoreSelfTest", &firestoreSelfTest)
+	if firestoreSelfTest != "off" && firestoreSelfTest != "warn" && firestoreSelfTest != "fatal" {
+		log.Fatal().Str("firestoreSelfTest", firestoreSelfTest).Msg("Environment value 'firestoreSelfTest' must be off, warn, or fatal")
 	}
-	return options
-}
-
-func getGalleryDocRef(galleryName string) (docRef *firestore.DocumentRef) {
-	docRef = firestoreClient.Collection("galleries").Doc(galleryName)
-	return docRef
-}
-
-func getRandomImageFromGallery(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
-	var embed discordgo.MessageEmbed
-
-	command := i.ApplicationCommandData().Options[0]
-	galleryName := command.Options[0].StringValue()
 
-	docRef := getGalleryDocRef(galleryName)
-	if docRef != nil {
-		docSnap, err := docRef.Get(ctx)
-		if err != nil {
-			log.Error().Err(err).Caller().Interface("interaction", i).Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
-			embed = discordgo.MessageEmbed{
-				Description: "Unable to get gallery contents :stop_sign:",
-				Color:       0xf04747,
-			}
-			data.Embeds = []*discordgo.MessageEmbed{&embed}
-			return data
-		}
-		var gallery Gallery
-		err = docSnap.DataTo(&gallery)
-		if err != nil {
-			log.Error().Err(err).Caller().Interface("interaction", i).Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
-			embed = discordgo.MessageEmbed{
-				Description: "Unable to get gallery contents :stop_sign:",
-				Color:       0xf04747,
+	var remaps string
+	lookupOptionalString("hostRemaps", &remaps)
+	if len(remaps) > 0 {
+		hostRemaps = make(map[string]string)
+		for _, v := range strings.Split(remaps, ",") {
+			pair := strings.SplitN(v, "=", 2)
+			if len(pair) != 2 || len(strings.TrimSpace(pair[0])) == 0 || len(strings.TrimSpace(pair[1])) == 0 {
+				log.Fatal().Str("remap", v).Msg("Environment value 'hostRemaps' must be a comma-separated list of old.host=new.host pairs")
 			}
-			data.Embeds = []*discordgo.MessageEmbed{&embed}
-			return data
+			hostRemaps[strings.ToLower(strings.TrimSpace(pair[0]))] = strings.TrimSpace(pair[1])
 		}
-		images := gallery.Images
-		// log.Debug().Interface("gallery", gallery).Interface("images", images).Msg("")
-		numberOfImages := len(images)
-		if numberOfImages > 0 {
-			if numberOfImages == 1 {
-				embed = discordgo.MessageEmbed{
-					Image: &discordgo.MessageEmbedImage{
-						URL: images[0]["imageUrl"],
-					},
-					Footer: &discordgo.MessageEmbedFooter{
-						Text: fmt.Sprintf("Image: %d of %d | Gallery: %s", 0, numberOfImages-1, galleryName),
-					},
-				}
-			} else {
-				chosenImageInt := rand.Intn(numberOfImages)
-				embed = discordgo.MessageEmbed{
-					Image: &discordgo.MessageEmbedImage{
-						URL: images[chosenImageInt]["imageUrl"],
-					},
-					Footer: &discordgo.MessageEmbedFooter{
-						Text: fmt.Sprintf("Image: %d of %d | Gallery: %s", chosenImageInt, numberOfImages-1, galleryName),
-					},
-				}
+		log.Info().Interface("hostRemaps", hostRemaps).Msg("Remapping image hosts when displaying")
+	}
+
+	var autoDeletes string
+	lookupOptionalString("autoDeleteResponses", &autoDeletes)
+	if len(autoDeletes) > 0 {
+		autoDeleteDelays = make(map[string]time.Duration)
+		for _, v := range strings.Split(autoDeletes, ",") {
+			pair := strings.SplitN(v, "=", 2)
+			if len(pair) != 2 {
+				log.Fatal().Str("autoDelete", v).Msg("Environment value 'autoDeleteResponses' must be a comma-separated list of subcommand=delay pairs")
 			}
-		} else {
-			embed = discordgo.MessageEmbed{
-				Description: "Gallery is empty :stop_sign:",
-				Color:       0xf04747,
+			delay, err := time.ParseDuration(strings.TrimSpace(pair[1]))
+			if err != nil || delay <= 0 {
+				log.Fatal().Err(err).Str("autoDelete", v).Msg("Environment value 'autoDeleteResponses' has an invalid delay")
 			}
-			log.Debug().Msg("Attempted image retrieval from empty gallery")
+			autoDeleteDelays[strings.TrimSpace(pair[0])] = delay
 		}
-	} else {
-		embed = discordgo.MessageEmbed{
-			Description: "Gallery does not exist :stop_sign:",
-			Color:       0xf04747,
+		log.Info().Interface("autoDeleteResponses", autoDeleteDelays).Msg("Automatically deleting some responses")
+	}
+
+	var features string
+	lookupOptionalString("features", &features)
+	if len(features) > 0 {
+		enabledFeatures = make(map[string]bool)
+		for _, v := range strings.Split(features, ",") {
+			enabledFeatures[strings.TrimSpace(v)] = true
 		}
-		log.Warn().Interface("interaction", i).Msg("Attempted image retrieval from non-existent gallery")
+		log.Info().Str("features", features).Msg("Only enabling the listed features")
 	}
-	data.Embeds = []*discordgo.MessageEmbed{&embed}
-	return data
 }
 
-func getImageFromGallery(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
-	var embed discordgo.MessageEmbed
+// featureEnabled reports whether subcommand should be registered and handled
+// Every subcommand is enabled unless the features setting lists which ones to offer, in which case only those (plus coreSubcommands) are.
+// The guild's settings can narrow this down further with toggle_command.
+func featureEnabled(subcommand string) bool {
+	if coreSubcommands[subcommand] {
+		return true
+	}
+	guildEnabledCommandsMutex.RLock()
+	defer guildEnabledCommandsMutex.RUnlock()
+	return (enabledFeatures == nil || enabledFeatures[subcommand]) && (guildEnabledCommands == nil || guildEnabledCommands[subcommand])
+}
 
-	command := i.ApplicationCommandData().Options[0]
-	galleryName := command.Options[0].StringValue()
-	imageNum := int(command.Options[1].IntValue())
+// getGuildSettingsDocRef returns the settings document of the guild the bot serves
+func getGuildSettingsDocRef() *firestore.DocumentRef {
+	return firestoreClient.Collection("settings").Doc(config["guildId"])
+}
 
-	docRef := getGalleryDocRef(galleryName)
-	if docRef != nil {
-		docSnap, err := docRef.Get(ctx)
-		if err != nil {
-			log.Error().Err(err).Caller().Interface("interaction", i).Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
-			embed = discordgo.MessageEmbed{
-				Description: "Unable to get gallery contents :stop_sign:",
-				Color:       0xf04747,
-			}
-			data.Embeds = []*discordgo.MessageEmbed{&embed}
-			return data
-		}
-		var gallery Gallery
-		err = docSnap.DataTo(&gallery)
-		if err != nil {
-			log.Error().Err(err).Caller().Interface("interaction", i).Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
-			embed = discordgo.MessageEmbed{
-				Description: "Unable to get gallery contents :stop_sign:",
-				Color:       0xf04747,
-			}
-			data.Embeds = []*discordgo.MessageEmbed{&embed}
-			return data
-		}
-		images := gallery.Images
-		numberOfImages := len(images)
-		if numberOfImages > 0 {
-			if imageNum < 0 || imageNum >= numberOfImages {
-				if numberOfImages == 1 {
-					embed = discordgo.MessageEmbed{
-						Description: "Invalid image number :stop_sign:\n(Only image number 0 is valid. Perhaps add more images to the gallery?)",
-						Color:       0xf04747,
-					}
-				} else {
-					embed = discordgo.MessageEmbed{
-						Description: fmt.Sprintf("Invalid image number :stop_sign: (Valid image numbers include 0 through %d inclusive.)", numberOfImages-1),
-						Color:       0xf04747,
-					}
-				}
-			} else {
-				embed = discordgo.MessageEmbed{
-					Image: &discordgo.MessageEmbedImage{
-						URL: images[imageNum]["imageUrl"],
-					},
-					Footer: &discordgo.MessageEmbedFooter{
-						Text: fmt.Sprintf("Image: %d of %d | Gallery: %s", imageNum, numberOfImages-1, galleryName),
-					},
-				}
-			}
-		} else {
-			embed = discordgo.MessageEmbed{
-				Description: "Gallery is empty :stop_sign:",
-				Color:       0xf04747,
-			}
-			log.Debug().Msg("Attempted image retrieval from empty gallery")
-		}
-	} else {
-		embed = discordgo.MessageEmbed{
-			Description: "Gallery does not exist :stop_sign:",
-			Color:       0xf04747,
-		}
-		log.Warn().Interface("interaction", i).Msg("Attempted image retrieval from non-existent gallery")
+// commandGuildID is the guild slash commands are registered in, or "" to register them globally in multiGuild mode
+func commandGuildID() string {
+	if multiGuild {
+		return ""
 	}
-	data.Embeds = []*discordgo.MessageEmbed{&embed}
-	return data
+	return config["guildId"]
 }
 
-func addImageToGallery(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
-	var embed discordgo.MessageEmbed
+// getGuildConfigDocRef returns the GuildConfig document of a guild
+func getGuildConfigDocRef(guildId string) *firestore.DocumentRef {
+	return firestoreClient.Collection("guildConfigs").Doc(guildId)
+}
 
-	command := i.ApplicationCommandData().Options[0]
-	galleryName := command.Options[0].StringValue()
-	imageUrl := command.Options[1].StringValue()
-	timestamp := fmt.Sprint(time.Now().Unix())
-	authorId := i.Member.User.ID
+// lookupGuildConfig returns a guild's overrides, or no overrides if it has none (or they can't be read)
+func lookupGuildConfig(guildId string) GuildConfig {
+	if len(guildId) == 0 {
+		return GuildConfig{} // Direct messages
+	}
+	guildConfigsMutex.Lock()
+	cached, ok := guildConfigs[guildId]
+	guildConfigsMutex.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.config
+	}
 
-	docRef := getGalleryDocRef(galleryName)
-	if docRef != nil {
-		docSnap, err := docRef.Get(ctx)
-		if err != nil {
-			log.Error().Err(err).Caller().Interface("interaction", i).Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
-			embed = discordgo.MessageEmbed{
-				Description: "Unable to get gallery contents :stop_sign:",
-				Color:       0xf04747,
-			}
-			data.Embeds = []*discordgo.MessageEmbed{&embed}
-			return data
-		}
-		var gallery Gallery
-		err = docSnap.DataTo(&gallery)
-		if err != nil {
-			log.Error().Err(err).Caller().Interface("interaction", i).Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
-			embed = discordgo.MessageEmbed{
-				Description: "Unable to get gallery contents :stop_sign:",
-				Color:       0xf04747,
-			}
-			data.Embeds = []*discordgo.MessageEmbed{&embed}
-			return data
-		}
-		// TODO: Validate the given imageUrl (length, format, expected params, etc.)
-		gallery.Images = append(gallery.Images, map[string]string{
-			"imageUrl":  imageUrl,
-			"timestamp": timestamp,
-			"authorId":  authorId,
-		})
-		_, err = docRef.Set(ctx, gallery)
+	ctx, done := requestContext("guildConfig")
+	defer done()
+	var guildConfig GuildConfig
+	docSnap, err := getGuildConfigDocRef(guildId).Get(ctx)
+	if err == nil {
+		err = docSnap.DataTo(&guildConfig)
+	}
+	if err != nil && status.Code(err) != codes.NotFound {
+		log.Error().Err(err).Caller().Str("guild", guildId).Msg("Failed to load guild config, using no overrides")
+		return GuildConfig{} // Not cached, so the next interaction tries again
+	}
+	guildConfigsMutex.Lock()
+	guildConfigs[guildId] = cachedGuildConfig{config: guildConfig, expires: time.Now().Add(guildConfigCacheTTL)}
+	guildConfigsMutex.Unlock()
+	return guildConfig
+}
+
+// postGuildAuditLog posts description to the audit log channel of the guild behind i, if it has one
+// The change has already been made, so failures are only logged.
+func postGuildAuditLog(i *discordgo.Interaction, description string) {
+	channelId := lookupGuildConfig(i.GuildID).AuditLogChannelID
+	if len(channelId) == 0 {
+		return
+	}
+	embed := discordgo.MessageEmbed{
+		Description: fmt.Sprintf("<@%s> %s", interactionUserID(i), description),
+		Color:       0x5865f2,
+		Timestamp:   time.Now().Format(time.RFC3339),
+	}
+	go func() {
+		_, err := s.ChannelMessageSendEmbed(channelId, &embed)
 		if err != nil {
-			log.Error().Err(err).Caller().Interface("interaction", i).Interface("DocRef", docRef).Msg("Failed to write document contents")
-			embed = discordgo.MessageEmbed{
-				Description: "Unable to modify gallery contents :stop_sign:",
-				Color:       0xf04747,
-			}
-			data.Embeds = []*discordgo.MessageEmbed{&embed}
-			return data
-		} else {
-			log.Debug().Str("imageUrl", imageUrl).Str("user", i.Member.User.Username).Str("gallery", galleryName).Msg("Image added to gallery")
-		}
-		embed = discordgo.MessageEmbed{
-			Description: fmt.Sprintf("Image `%d` created!", len(gallery.Images)-1),
-			Fields: []*discordgo.MessageEmbedField{
-				{
-					Name:   "In gallery",
-					Value:  fmt.Sprintf("`%s`", galleryName),
-					Inline: true,
-				},
-				{
-					Name:   "Added by",
-					Value:  fmt.Sprintf("<@%s>", authorId),
-					Inline: true,
-				},
-				{
-					Name:   "Created at",
-					Value:  fmt.Sprintf("<t:%s>", timestamp),
-					Inline: true,
-				},
-			},
+			log.Warn().Err(err).Str("channel", channelId).Str("guild", i.GuildID).Msg("Failed to post to audit log channel (are the bot's permissions in the channel missing?)")
 		}
-	} else {
-		embed = discordgo.MessageEmbed{
-			Description: "Gallery does not exist :stop_sign:",
-			Color:       0xf04747,
+	}()
+}
+
+// applyGuildSettings makes the guild's settings take effect
+func applyGuildSettings(settings GuildSettings) {
+	guildTagRulesMutex.Lock()
+	guildTagRules = settings.TagRules
+	guildTagRulesMutex.Unlock()
+
+	guildCategoriesMutex.Lock()
+	guildCategories = settings.Categories
+	guildCategoriesMutex.Unlock()
+
+	guildEnabledCommandsMutex.Lock()
+	defer guildEnabledCommandsMutex.Unlock()
+	if len(settings.EnabledCommands) == 0 {
+		guildEnabledCommands = nil
+		return
+	}
+	guildEnabledCommands = make(map[string]bool)
+	for _, v := range settings.EnabledCommands {
+		guildEnabledCommands[v] = true
+	}
+}
+
+// loadGuildSettings reads and applies the guild's settings, falling back to the defaults if there are none (or they can't be read)
+func loadGuildSettings() {
+	var settings GuildSettings
+	docSnap, err := getGuildSettingsDocRef().Get(ctx)
+	if err == nil {
+		err = docSnap.DataTo(&settings)
+	}
+	if err != nil && status.Code(err) != codes.NotFound {
+		log.Error().Err(err).Caller().Msg("Failed to load guild settings, using the defaults")
+	}
+	applyGuildSettings(settings)
+	if settings.Paused {
+		atomic.StoreInt32(&paused, 1)
+		log.Warn().Msg("Starting paused, run /gallery_admin resume to handle commands again")
+	}
+}
+
+// checkFirestorePermissions writes, reads back, and deletes a sentinel document, so that credentials or security rules that would block the bot's writes are caught at startup rather than on the first command
+// The sentinel goes in a collection of its own, so that nothing listing the galleries can come across it, even if the bot exits before deleting it.
+func checkFirestorePermissions() (err error) {
+	docRef := firestoreClient.Collection("_healthcheck").Doc(fmt.Sprint(time.Now().UnixNano()))
+	_, err = docRef.Create(ctx, map[string]interface{}{"createdAt": time.Now()})
+	if err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	defer func() {
+		_, deleteErr := docRef.Delete(ctx)
+		if deleteErr != nil && err == nil {
+			err = fmt.Errorf("delete: %w", deleteErr)
 		}
-		log.Warn().Interface("interaction", i).Msg("Attempted image retrieval from non-existent gallery")
+	}()
+	_, err = docRef.Get(ctx)
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
 	}
-	data.Embeds = []*discordgo.MessageEmbed{&embed}
-	return data
+	return nil
 }
 
-func removeImagePrompt(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
-	var embed discordgo.MessageEmbed
-	var messageComponents []discordgo.MessageComponent
+// lookupOptionalButtonStyle reads a button style by name: primary, secondary, success, or danger
+// Link buttons can't be used, since they open a URL rather than sending an interaction.
+func lookupOptionalButtonStyle(key string, dest *discordgo.ButtonStyle) {
+	var name string
+	lookupOptionalString(key, &name)
+	if len(name) == 0 {
+		return
+	}
+	styles := map[string]discordgo.ButtonStyle{
+		"primary":   discordgo.PrimaryButton,
+		"secondary": discordgo.SecondaryButton,
+		"success":   discordgo.SuccessButton,
+		"danger":    discordgo.DangerButton,
+	}
+	style, ok := styles[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		log.Fatal().Str(key, name).Msgf("Environment value '%s' must be primary, secondary, success, or danger", key)
+	}
+	*dest = style
+}
 
-	command := i.ApplicationCommandData().Options[0]
-	galleryName := command.Options[0].StringValue()
-	imageNum := int(command.Options[1].IntValue())
+func lookupOptionalString(key string, dest *string) {
+	val, isPresent := os.LookupEnv(key)
+	if !isPresent || len(val) == 0 {
+		return
+	}
+	*dest = val
+}
 
-	docRef := getGalleryDocRef(galleryName)
-	docSnap, err := docRef.Get(ctx)
-	if status.Code(err) == codes.NotFound {
-		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docRef", docRef).Msg("Attempted to delete image from non-existent gallery")
-		embed = discordgo.MessageEmbed{
-			Description: "Gallery does not exist :stop_sign:",
-			Color:       0xf04747,
-		}
-		data.Embeds = []*discordgo.MessageEmbed{&embed}
-		return data
+func lookupOptionalInt(key string, dest *int) {
+	val, isPresent := os.LookupEnv(key)
+	if !isPresent || len(val) == 0 {
+		return
 	}
-	var gallery Gallery
-	err = docSnap.DataTo(&gallery)
+	parsed, err := strconv.Atoi(val)
 	if err != nil {
-		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
-		embed = discordgo.MessageEmbed{
-			Description: "Unable to get gallery contents :stop_sign:",
-			Color:       0xf04747,
-		}
-		data.Embeds = []*discordgo.MessageEmbed{&embed}
-		return data
+		log.Fatal().Err(err).Msgf("Environment value '%s' is not a valid integer", key)
 	}
-	images := gallery.Images
-	numberOfImages := len(images)
-	if numberOfImages > 0 {
-		if imageNum < 0 || imageNum >= numberOfImages {
-			if numberOfImages == 1 {
-				embed = discordgo.MessageEmbed{
-					Description: "Invalid image number :stop_sign: (Only image number 0 exists.)",
-					Color:       0xf04747,
-				}
-			} else {
-				embed = discordgo.MessageEmbed{
-					Description: fmt.Sprintf("Invalid image number :stop_sign: (Valid image numbers include 0 through %d inclusive.)", numberOfImages-1),
-					Color:       0xf04747,
-				}
-			}
-			data.Embeds = []*discordgo.MessageEmbed{&embed}
-			return data
-		} else {
-			embed = discordgo.MessageEmbed{
-				Description: "Are you sure you want to delete the below image? :thinking:",
-				Color:       0x5865f2,
-				Image: &discordgo.MessageEmbedImage{
-					URL: gallery.Images[imageNum]["imageUrl"],
-				},
-				Fields: []*discordgo.MessageEmbedField{
-					{
-						Name:   "In gallery",
-						Value:  fmt.Sprintf("`%s`", galleryName),
-						Inline: true,
-					},
-					{
-						Name:   "Image number",
-						Value:  fmt.Sprint(imageNum),
-						Inline: true,
-					},
-					{
-						Name:   "Added by",
-						Value:  fmt.Sprintf("<@%s>", gallery.Images[imageNum]["authorId"]),
-						Inline: true,
-					},
-					{
-						Name:   "Created at",
-						Value:  fmt.Sprintf("<t:%s>", gallery.Images[imageNum]["timestamp"]),
-						Inline: true,
-					},
-				},
-			}
-			messageComponents = []discordgo.MessageComponent{
-				discordgo.ActionsRow{
-					Components: []discordgo.MessageComponent{
-						discordgo.Button{
-							Label:    "Yes, delete",
-							Style:    discordgo.DangerButton,
-							CustomID: "image_delete_yes",
-						},
-						discordgo.Button{
-							Label:    "No, cancel",
-							Style:    discordgo.SecondaryButton,
-							CustomID: "image_delete_no",
-						},
-					},
-				},
-			}
-			data.Embeds = []*discordgo.MessageEmbed{&embed}
-			data.Components = messageComponents
-			return data
-		}
-	} else {
-		embed = discordgo.MessageEmbed{
-			Description: "Gallery does not exist :stop_sign:",
-			Color:       0xf04747,
-		}
-		log.Warn().Interface("interaction", i).Msg("Attempted image retrieval from non-existent gallery")
-		data.Embeds = []*discordgo.MessageEmbed{&embed}
-		return data
+	*dest = parsed
+}
+
+func lookupOptionalDuration(key string, dest *time.Duration) {
+	val, isPresent := os.LookupEnv(key)
+	if !isPresent || len(val) == 0 {
+		return
+	}
+	parsed, err := time.ParseDuration(val)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Environment value '%s' is not a valid duration", key)
 	}
+	*dest = parsed
 }
 
-func removeImage(i *discordgo.Interaction, galleryName string, imageNum int) (data discordgo.InteractionResponseData) {
-	var embed discordgo.MessageEmbed
+func lookupOptionalBool(key string, dest *bool) {
+	val, isPresent := os.LookupEnv(key)
+	if !isPresent || len(val) == 0 {
+		return
+	}
+	parsed, err := strconv.ParseBool(val)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Environment value '%s' is not a valid boolean", key)
+	}
+	*dest = parsed
+}
 
-	docRef := getGalleryDocRef(galleryName)
-	docSnap, err := docRef.Get(ctx)
-	if status.Code(err) == codes.NotFound {
-		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docRef", docRef).Msg("Attempted to remove image from non-existent gallery")
-		embed = discordgo.MessageEmbed{
-			Description: "Gallery does not exist :stop_sign:",
-			Color:       0xf04747,
+// lookupOptionalList reads a comma-separated list, ignoring blank entries
+func lookupOptionalList(key string, dest *[]string) {
+	val, isPresent := os.LookupEnv(key)
+	if !isPresent || len(val) == 0 {
+		return
+	}
+	var parsed []string
+	for _, v := range strings.Split(val, ",") {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			parsed = append(parsed, v)
 		}
-		data.Embeds = []*discordgo.MessageEmbed{&embed}
-		return data
 	}
-	var gallery Gallery
-	err = docSnap.DataTo(&gallery)
+	*dest = parsed
+}
+
+// getAllGalleries loads every gallery, in the same order as the gallery choices
+// Rather than one request for the whole collection, galleries are fetched fetchChunkSize at a time across up to fetchConcurrency concurrent requests, which keeps each request within Firestore's limits on large servers.
+func getAllGalleries() ([]*firestore.DocumentSnapshot, error) {
+	ctx, done := requestContext("getAllGalleries")
+	defer done()
+	docRefs, err := firestoreClient.Collection("galleries").DocumentRefs(ctx).GetAll()
 	if err != nil {
-		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
-		embed = discordgo.MessageEmbed{
-			Description: "Unable to get gallery contents :stop_sign:",
-			Color:       0xf04747,
+		return nil, err
+	}
+	docSnaps, err := fetchDocuments(docRefs)
+	if err != nil {
+		return nil, err
+	}
+
+	// A gallery deleted between listing and fetching comes back as a snapshot that doesn't exist
+	existing := docSnaps[:0]
+	for _, docSnap := range docSnaps {
+		if docSnap.Exists() {
+			existing = append(existing, docSnap)
 		}
-		data.Embeds = []*discordgo.MessageEmbed{&embed}
-		return data
 	}
-	images := gallery.Images
-	numberOfImages := len(images)
-	if numberOfImages > 0 {
-		if imageNum < 0 || imageNum >= numberOfImages {
-			if numberOfImages == 1 {
-				embed = discordgo.MessageEmbed{
-					Description: "Invalid image number :stop_sign: (Only image number 0 exists.)",
-					Color:       0xf04747,
-				}
-			} else {
-				embed = discordgo.MessageEmbed{
-					Description: fmt.Sprintf("Invalid image number :stop_sign: (Valid image numbers include 0 through %d inclusive.)", numberOfImages-1),
-					Color:       0xf04747,
-				}
-			}
-			data.Embeds = []*discordgo.MessageEmbed{&embed}
-			return data
-		} else {
-			gallery.Images = append(gallery.Images[:imageNum], gallery.Images[imageNum+1:]...)
-			_, err = docRef.Set(ctx, gallery)
+	return existing, nil
+}
+
+// fetchDocuments reads docRefs in chunks of fetchChunkSize, up to fetchConcurrency chunks at a time
+// The snapshots are in the same order as docRefs, including ones for documents that don't exist.
+func fetchDocuments(docRefs []*firestore.DocumentRef) ([]*firestore.DocumentSnapshot, error) {
+	chunkSize := fetchChunkSize
+	if chunkSize < 1 {
+		chunkSize = 1
+	}
+	docSnaps := make([]*firestore.DocumentSnapshot, len(docRefs))
+	errs := make(chan error, (len(docRefs)+chunkSize-1)/chunkSize)
+	concurrency := fetchConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for start := 0; start < len(docRefs); start += chunkSize {
+		end := start + chunkSize
+		if end > len(docRefs) {
+			end = len(docRefs)
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			ctx, done := requestContext("fetchDocuments")
+			defer done()
+			chunk, err := firestoreClient.GetAll(ctx, docRefs[start:end])
 			if err != nil {
-				log.Error().Err(err).Caller().Interface("interaction", i).Interface("DocRef", docRef).Msg("Failed to write document contents")
-				embed = discordgo.MessageEmbed{
-					Description: "Unable to modify gallery contents :stop_sign:",
-					Color:       0xf04747,
-				}
-				data.Embeds = []*discordgo.MessageEmbed{&embed}
-				return data
-			} else {
-				log.Debug().Str("imageNum", fmt.Sprint(imageNum)).Str("gallery", galleryName).Msg("Image removed from gallery")
-			}
-			embed = discordgo.MessageEmbed{
-				Description: fmt.Sprintf("Image `%d` removed from `%s` :white_check_mark:", imageNum, galleryName),
-				Color:       0x43b581,
+				errs <- err
+				return
 			}
-			data.Embeds = []*discordgo.MessageEmbed{&embed}
-			return data
-		}
-	} else {
-		embed = discordgo.MessageEmbed{
-			Description: "Gallery does not exist :stop_sign:",
-			Color:       0xf04747,
+			copy(docSnaps[start:end], chunk) // Each goroutine fills a disjoint range, so no locking is needed
+		}(start, end)
+	}
+	wg.Wait()
+	close(errs)
+	if err, failed := <-errs; failed {
+		return nil, err
+	}
+	return docSnaps, nil
+}
+
+// refreshGalleryCache reloads every gallery's name from Firestore, reporting whether any were added or removed since the last load
+func refreshGalleryCache() (changed bool, err error) {
+	ctx, done := requestContext("refreshGalleryCache")
+	defer done()
+	galleries, err := firestoreClient.Collection("galleries").DocumentRefs(ctx).GetAll()
+	if err != nil {
+		log.Error().Err(err).Caller().Msg("Failed to get DocumentRefs from Firestore")
+		return false, err
+	}
+	names := make(map[string]bool, len(galleries))
+	for _, v := range galleries {
+		names[v.ID] = true
+	}
+	log.Debug().Msgf("Found %d galleries", len(names))
+
+	galleryNamesMutex.Lock()
+	defer galleryNamesMutex.Unlock()
+	changed = galleryNames == nil || len(names) != len(galleryNames)
+	for name := range names {
+		if !galleryNames[name] {
+			changed = true
 		}
-		log.Warn().Interface("interaction", i).Msg("Attempted image removal from non-existent gallery")
-		data.Embeds = []*discordgo.MessageEmbed{&embed}
-		return data
 	}
+	galleryNames = names
+	atomic.StoreInt64(&galleryCount, int64(len(names)))
+	return changed, nil
 }
 
-func createGallery(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
-	var embed discordgo.MessageEmbed
+// noteGalleryCreated adds a gallery the bot just created to the cached names
+func noteGalleryCreated(galleryName string) {
+	galleryNamesMutex.Lock()
+	defer galleryNamesMutex.Unlock()
+	if galleryNames == nil {
+		return // The next refreshGalleryCache will find it
+	}
+	galleryNames[galleryName] = true
+	atomic.StoreInt64(&galleryCount, int64(len(galleryNames)))
+}
 
-	command := i.ApplicationCommandData().Options[0]
-	galleryName := command.Options[0].StringValue()
+// noteGalleryDeleted removes a gallery the bot just deleted from the cached names
+func noteGalleryDeleted(galleryName string) {
+	galleryNamesMutex.Lock()
+	defer galleryNamesMutex.Unlock()
+	if galleryNames == nil {
+		return
+	}
+	delete(galleryNames, galleryName)
+	atomic.StoreInt64(&galleryCount, int64(len(galleryNames)))
+}
 
-	docRef := getGalleryDocRef(galleryName)
-	_, err := docRef.Get(ctx)
-	if status.Code(err) == codes.NotFound {
-		_, err := docRef.Set(ctx, Gallery{})
-		if err != nil {
-			log.Error().Err(err).Caller().Interface("interaction", i).Interface("docRef", docRef).Msg("Failed to create document")
-			embed = discordgo.MessageEmbed{
-				Description: "Unable to create gallery :stop_sign:",
-				Color:       0xf04747,
+// refreshGalleryCachePeriodically reloads the cached gallery names every galleryCacheRefreshInterval until stop is closed, so that autocomplete suggests galleries changed outside of the bot
+func refreshGalleryCachePeriodically(stop <-chan struct{}) {
+	if galleryCacheRefreshInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(galleryCacheRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			changed, err := refreshGalleryCache()
+			if err == nil && changed {
+				log.Info().Msg("Galleries changed outside of the bot")
 			}
-			data.Embeds = []*discordgo.MessageEmbed{&embed}
-			return data
+		case <-stop:
+			return
 		}
-		embed = discordgo.MessageEmbed{
-			Description: fmt.Sprintf("Gallery `%s` created :white_check_mark:", galleryName),
-			Color:       0x43b581,
+	}
+}
+
+// populateGalleryChoices offers up to maxAutocompleteChoices of the cached gallery names starting with prefix (ignoring case) in order, loading them first if they haven't been yet
+func populateGalleryChoices(prefix string) (options []*discordgo.ApplicationCommandOptionChoice) {
+	galleryNamesMutex.RLock()
+	loaded := galleryNames != nil
+	galleryNamesMutex.RUnlock()
+	if !loaded {
+		refreshGalleryCache()
+	}
+
+	galleryNamesMutex.RLock()
+	names := make([]string, 0, len(galleryNames))
+	for name := range galleryNames {
+		names = append(names, name)
+	}
+	galleryNamesMutex.RUnlock()
+	sort.Strings(names) // Matches the order Firestore lists them in
+	prefix = strings.ToLower(prefix)
+	for _, v := range names {
+		if !strings.HasPrefix(strings.ToLower(v), prefix) {
+			continue
 		}
-		log.Debug().Msgf("Created new gallery '%s'", galleryName)
-		updateCommands()
-	} else if status.Code(err) == codes.OK {
-		embed = discordgo.MessageEmbed{
-			Description: "Gallery already exists :stop_sign:",
+		if len(options) == maxAutocompleteChoices {
+			break
+		}
+		options = append(options,
+			&discordgo.ApplicationCommandOptionChoice{
+				Name:  v,
+				Value: v,
+			},
+		)
+	}
+	return options
+}
+
+// truncateText shortens text to at most limit characters, ending it with an ellipsis if anything was cut
+func truncateText(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit-1]) + "…"
+}
+
+// fitEmbedLimits truncates any embed text that would exceed Discord's limits, since user-supplied names, tags, and links end up in embeds
+func fitEmbedLimits(embeds []*discordgo.MessageEmbed) {
+	for _, embed := range embeds {
+		embed.Title = truncateText(embed.Title, maxEmbedTitleLength)
+		embed.Description = truncateText(embed.Description, maxEmbedDescriptionLength)
+		for _, field := range embed.Fields {
+			field.Name = truncateText(field.Name, maxEmbedFieldNameLength)
+			field.Value = truncateText(field.Value, maxEmbedFieldValueLength)
+		}
+		if embed.Footer != nil {
+			embed.Footer.Text = truncateText(embed.Footer.Text, maxEmbedFooterLength)
+		}
+	}
+}
+
+// invalidGalleryNameEmbed explains why galleryName can't be used for a new gallery, or returns nil if it can
+func invalidGalleryNameEmbed(galleryName string) *discordgo.MessageEmbed {
+	if err := validateGalleryName(galleryName); err != nil {
+		return &discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Gallery names can only use lowercase letters, numbers, `-`, and `_`, and be at most %d characters long :stop_sign: (The name %s.)", maxNewGalleryNameLength, err),
 			Color:       0xf04747,
 		}
-		log.Debug().Msg("Attempted to create a gallery that already exists")
 	}
-	data.Embeds = []*discordgo.MessageEmbed{&embed}
-	return data
+	return nil
 }
 
-func deleteGalleryPrompt(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+// validateGalleryName checks that a new gallery's name matches galleryNamePattern, explaining what's wrong if it doesn't
+// Galleries named before this was enforced are left alone; they only have to stay within maxGalleryNameLength.
+func validateGalleryName(galleryName string) error {
+	switch {
+	case len(galleryName) == 0:
+		return errors.New("is empty")
+	case len([]rune(galleryName)) > maxNewGalleryNameLength:
+		return fmt.Errorf("is %d characters long", len([]rune(galleryName)))
+	case !galleryNamePattern.MatchString(galleryName):
+		return errors.New("has other characters")
+	}
+	return nil
+}
+
+// addAltTextField shows an image's alt text (if it has any) as a field of the embed displaying it, since Discord has no way to attach alt text to an embedded image
+func addAltTextField(embed *discordgo.MessageEmbed, image Image) {
+	if len(image.Alt) == 0 {
+		return
+	}
+	embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+		Name:  "Description",
+		Value: image.Alt,
+	})
+}
+
+// addCaption shows an image's caption (if it has any) as the description of the embed displaying it, quoted below anything the description already says
+func addCaption(embed *discordgo.MessageEmbed, image Image) {
+	caption := image.Caption
+	if len(caption) == 0 {
+		return
+	}
+	if len(embed.Description) == 0 {
+		embed.Description = caption
+		return
+	}
+	embed.Description += "\n> " + strings.ReplaceAll(caption, "\n", "\n> ")
+}
+
+// altTextTooLongEmbed is the refusal shown for alt text longer than maxAltTextLength
+func altTextTooLongEmbed() *discordgo.MessageEmbed {
+	return &discordgo.MessageEmbed{
+		Description: fmt.Sprintf("Descriptions can be at most %d characters long :stop_sign:", maxAltTextLength),
+		Color:       0xf04747,
+	}
+}
+
+// findOption returns the option named name, or nil if it was not supplied (as is possible for optional options)
+func findOption(options []*discordgo.ApplicationCommandInteractionDataOption, name string) *discordgo.ApplicationCommandInteractionDataOption {
+	for _, v := range options {
+		if v.Name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+// parseTags splits a comma-separated list of tags, normalizing each to lowercase and dropping blanks and repeats
+func parseTags(raw string) (tags []string) {
+	seen := make(map[string]bool)
+	for _, v := range strings.Split(raw, ",") {
+		tag := strings.ToLower(strings.TrimSpace(v))
+		if len(tag) == 0 || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// matchesTagRule reports whether imageUrl matches pattern, which is either a host pattern like "*.example.com" (matching example.com and its subdomains) or a substring of the link
+// Both comparisons ignore case.
+func matchesTagRule(imageUrl string, pattern string) bool {
+	pattern = strings.ToLower(pattern)
+	if strings.HasPrefix(pattern, "*.") {
+		parsedUrl, err := url.Parse(imageUrl)
+		if err != nil {
+			return false
+		}
+		host := strings.ToLower(parsedUrl.Hostname())
+		domain := strings.TrimPrefix(pattern, "*.")
+		return host == domain || strings.HasSuffix(host, "."+domain)
+	}
+	return strings.Contains(strings.ToLower(imageUrl), pattern)
+}
+
+// applyTagRules adds the tags of every guild tagging rule matching image's link to image, returning the ones it didn't already carry
+func applyTagRules(image *Image) (applied []string) {
+	guildTagRulesMutex.RLock()
+	defer guildTagRulesMutex.RUnlock()
+	existing := parseTags(image.Tags)
+	has := make(map[string]bool)
+	for _, tag := range existing {
+		has[tag] = true
+	}
+	for _, rule := range guildTagRules {
+		if !has[rule.Tag] && matchesTagRule(image.ImageURL, rule.Pattern) {
+			has[rule.Tag] = true
+			applied = append(applied, rule.Tag)
+		}
+	}
+	if len(applied) > 0 {
+		image.Tags = strings.Join(append(existing, applied...), ",")
+	}
+	return applied
+}
+
+// imagesMatchingTags returns the indices of the images carrying all (matchAll) or any (!matchAll) of tags
+// Every image matches when no tags are given.
+func imagesMatchingTags(images []Image, tags []string, matchAll bool) (indices []int) {
+	for n, image := range images {
+		imageTags := make(map[string]bool)
+		for _, tag := range parseTags(image.Tags) {
+			imageTags[tag] = true
+		}
+		matches := matchAll
+		for _, tag := range tags {
+			if matchAll && !imageTags[tag] {
+				matches = false
+				break
+			}
+			if !matchAll && imageTags[tag] {
+				matches = true
+				break
+			}
+		}
+		if len(tags) == 0 || matches {
+			indices = append(indices, n)
+		}
+	}
+	return indices
+}
+
+// pickTagBucket narrows candidates down to the images carrying one tag, chosen uniformly among the tags present, so that a tag with many images isn't shown more often than one with few
+// Untagged images form a bucket of their own. If none of the candidates are tagged, they're all returned.
+func pickTagBucket(images []Image, candidates []int) []int {
+	buckets := make(map[string][]int)
+	var tags []string
+	for _, n := range candidates {
+		imageTags := parseTags(images[n].Tags)
+		if len(imageTags) == 0 {
+			imageTags = []string{""} // Not a valid tag, so it can't collide with one
+		}
+		for _, tag := range imageTags {
+			if _, seen := buckets[tag]; !seen {
+				tags = append(tags, tag)
+			}
+			buckets[tag] = append(buckets[tag], n)
+		}
+	}
+	if len(tags) < 2 {
+		return candidates
+	}
+	return buckets[tags[rand.Intn(len(tags))]]
+}
+
+// countTags tallies how many of images carry each tag, and how many carry none
+func countTags(images []Image) (counts map[string]int, untagged int) {
+	counts = make(map[string]int)
+	for _, image := range images {
+		imageTags := parseTags(image.Tags)
+		if len(imageTags) == 0 {
+			untagged++
+		}
+		for _, tag := range imageTags {
+			counts[tag]++
+		}
+	}
+	return counts, untagged
+}
+
+// requestContext derives a context that gives up after requestTimeout, so that a slow or unreachable Firestore fails an interaction rather than hanging it until its token expires
+// Handlers shadow the package-level ctx with it. The returned function releases it and warns if the deadline was hit; defer it.
+func requestContext(handler string) (context.Context, func()) {
+	requestCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	return requestCtx, func() {
+		if errors.Is(requestCtx.Err(), context.DeadlineExceeded) {
+			log.Warn().Str("handler", handler).Dur("timeout", requestTimeout).Msg("Firestore request deadline exceeded")
+		}
+		cancel()
+	}
+}
+
+func getGalleryDocRef(galleryName string) (docRef *firestore.DocumentRef) {
+	docRef = firestoreClient.Collection("galleries").Doc(galleryName)
+	return docRef
+}
+
+// loadGallery reads a gallery, returning an embed explaining the problem (instead of the gallery) if it doesn't exist or can't be read
+func loadGallery(i *discordgo.Interaction, galleryName string) (gallery Gallery, problem *discordgo.MessageEmbed) {
+	ctx, done := requestContext("loadGallery")
+	defer done()
+	docSnap, err := getGalleryDocRef(galleryName).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return gallery, &discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+	}
+	if err == nil {
+		err = docSnap.DataTo(&gallery)
+	}
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+		return gallery, &discordgo.MessageEmbed{
+			Description: "Unable to get gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+	}
+	return gallery, nil
+}
+
+func getRandomImageFromGallery(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
 	var embed discordgo.MessageEmbed
-	var messageComponents []discordgo.MessageComponent
+	ctx, done := requestContext("random")
+	defer done()
 
 	command := i.ApplicationCommandData().Options[0]
 	galleryName := command.Options[0].StringValue()
 
 	docRef := getGalleryDocRef(galleryName)
-	_, err := docRef.Get(ctx)
-	if status.Code(err) == codes.NotFound {
-		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docRef", docRef).Msg("Attempted to delete non-existent gallery")
+	if docRef != nil {
+		docSnap, err := docRef.Get(ctx)
+		if err != nil {
+			log.Error().Err(err).Caller().Interface("interaction", i).Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+			embed = discordgo.MessageEmbed{
+				Description: "Unable to get gallery contents :stop_sign:",
+				Color:       0xf04747,
+			}
+			data.Embeds = []*discordgo.MessageEmbed{&embed}
+			return data
+		}
+		var gallery Gallery
+		err = docSnap.DataTo(&gallery)
+		if err != nil {
+			log.Error().Err(err).Caller().Interface("interaction", i).Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+			embed = discordgo.MessageEmbed{
+				Description: "Unable to get gallery contents :stop_sign:",
+				Color:       0xf04747,
+			}
+			data.Embeds = []*discordgo.MessageEmbed{&embed}
+			return data
+		}
+		if gallery.Disabled {
+			data.Embeds = []*discordgo.MessageEmbed{galleryDisabledEmbed(galleryName)}
+			return data
+		}
+		images := gallery.Images
+		// log.Debug().Interface("gallery", gallery).Interface("images", images).Msg("")
+		numberOfImages := len(images)
+		if numberOfImages > 0 {
+			var requestedTags []string
+			matchAll := true
+			if option := findOption(command.Options, "tags"); option != nil {
+				requestedTags = parseTags(option.StringValue())
+			}
+			if option := findOption(command.Options, "match"); option != nil {
+				matchAll = option.StringValue() != "any"
+			}
+			candidates := imagesMatchingTags(images, requestedTags, matchAll)
+			if option := findOption(command.Options, "min_age"); option != nil && len(candidates) > 0 {
+				minAge, err := parseAge(option.StringValue())
+				if err != nil {
+					embed = discordgo.MessageEmbed{
+						Description: fmt.Sprintf("Invalid age :stop_sign: (%s)", err),
+						Color:       0xf04747,
+					}
+					data.Embeds = []*discordgo.MessageEmbed{&embed}
+					return data
+				}
+				cutoff := time.Now().Add(-minAge).Unix()
+				old := candidates[:0]
+				for _, n := range candidates {
+					// Images without a usable timestamp can't be shown to be old enough
+					if unix, err := strconv.ParseInt(images[n].Timestamp, 10, 64); err == nil && unix > 0 && unix <= cutoff {
+						old = append(old, n)
+					}
+				}
+				if len(old) == 0 {
+					embed = discordgo.MessageEmbed{
+						Description: fmt.Sprintf("No images in `%s` are older than %s :stop_sign:", galleryName, option.StringValue()),
+						Color:       0xf04747,
+					}
+					data.Embeds = []*discordgo.MessageEmbed{&embed}
+					return data
+				}
+				candidates = old
+			}
+			if option := findOption(command.Options, "not_mine"); option != nil && option.BoolValue() && len(candidates) > 0 {
+				userId := interactionUserID(i)
+				others := candidates[:0]
+				for _, n := range candidates {
+					if images[n].AuthorID != userId {
+						others = append(others, n)
+					}
+				}
+				if len(others) == 0 {
+					embed = discordgo.MessageEmbed{
+						Description: fmt.Sprintf("Every image in `%s` that fits was added by you :stop_sign:", galleryName),
+						Color:       0xf04747,
+					}
+					data.Embeds = []*discordgo.MessageEmbed{&embed}
+					return data
+				}
+				candidates = others
+			}
+			if len(candidates) == 0 {
+				quantifier := "all"
+				if !matchAll {
+					quantifier = "any"
+				}
+				embed = discordgo.MessageEmbed{
+					Description: fmt.Sprintf("No images in `%s` match %s of the tags `%s` :stop_sign:", galleryName, quantifier, strings.Join(requestedTags, ", ")),
+					Color:       0xf04747,
+				}
+				log.Debug().Strs("tags", requestedTags).Bool("matchAll", matchAll).Msg("Attempted tagged image retrieval with no matches")
+			} else {
+				if option := findOption(command.Options, "balanced"); option != nil && option.BoolValue() {
+					candidates = pickTagBucket(images, candidates)
+				}
+				chosenImageInt := candidates[rand.Intn(len(candidates))]
+				if option := findOption(command.Options, "fair"); option != nil && option.BoolValue() {
+					chosenImageInt = pickLeastViewed(galleryName, images, candidates)
+				}
+				embed = discordgo.MessageEmbed{
+					Image: &discordgo.MessageEmbedImage{
+						URL: displayImageURL(images[chosenImageInt].ImageURL),
+					},
+					Footer: &discordgo.MessageEmbedFooter{
+						Text: fmt.Sprintf("Image: %d of %d | Gallery: %s", chosenImageInt, numberOfImages-1, galleryName),
+					},
+				}
+				addCaption(&embed, images[chosenImageInt])
+				addAltTextField(&embed, images[chosenImageInt])
+				recordView(galleryName, images[chosenImageInt].ImageURL)
+			}
+		} else {
+			embed = emptyGalleryEmbed(galleryName, gallery)
+			log.Debug().Msg("Attempted image retrieval from empty gallery")
+		}
+	} else {
 		embed = discordgo.MessageEmbed{
 			Description: "Gallery does not exist :stop_sign:",
 			Color:       0xf04747,
 		}
-		data.Embeds = []*discordgo.MessageEmbed{&embed}
-		return data
-	}
-	embed = discordgo.MessageEmbed{
-		Description: "Are you sure you want to delete the following gallery? :thinking:",
-		Color:       0x5865f2,
-		Fields: []*discordgo.MessageEmbedField{
-			{
-				Name:  "Gallery",
-				Value: fmt.Sprintf("`%s`", galleryName),
-			},
-		},
-	}
-	messageComponents = []discordgo.MessageComponent{
-		discordgo.ActionsRow{
-			Components: []discordgo.MessageComponent{
-				discordgo.Button{
-					Label:    "Yes, delete",
-					Style:    discordgo.DangerButton,
-					CustomID: "gallery_delete_yes",
-				},
-				discordgo.Button{
-					Label:    "No, cancel",
-					Style:    discordgo.SecondaryButton,
-					CustomID: "gallery_delete_no",
-				},
-			},
-		},
+		log.Warn().Interface("interaction", i).Msg("Attempted image retrieval from non-existent gallery")
 	}
 	data.Embeds = []*discordgo.MessageEmbed{&embed}
-	data.Components = messageComponents
 	return data
 }
 
-func deleteGallery(i *discordgo.Interaction, galleryName string) (data discordgo.InteractionResponseData) {
+// getRandomImageFromCategory pools the images of every gallery in a category and sends one of them, naming its gallery in the footer
+// Galleries that have been deleted or disabled since the category was set up are left out of the pool.
+func getRandomImageFromCategory(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
 	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("random_category")
+	defer done()
 
-	docRef := getGalleryDocRef(galleryName)
-	_, err := docRef.Get(ctx)
-	if status.Code(err) == codes.NotFound {
-		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docRef", docRef).Msg("Attempted to delete non-existent gallery")
+	command := i.ApplicationCommandData().Options[0]
+	category := strings.ToLower(strings.TrimSpace(command.Options[0].StringValue()))
+
+	guildCategoriesMutex.RLock()
+	galleryNames, ok := guildCategories[category]
+	var known []string
+	for name := range guildCategories {
+		known = append(known, name)
+	}
+	guildCategoriesMutex.RUnlock()
+	if !ok {
+		description := fmt.Sprintf("There is no `%s` category :stop_sign:", category)
+		if len(known) > 0 {
+			sort.Strings(known)
+			description += fmt.Sprintf(" (Try `%s`.)", strings.Join(known, "`, `"))
+		}
 		embed = discordgo.MessageEmbed{
-			Description: "Gallery does not exist :stop_sign:",
+			Description: description,
 			Color:       0xf04747,
 		}
 		data.Embeds = []*discordgo.MessageEmbed{&embed}
 		return data
 	}
-	_, err = docRef.Delete(ctx)
+
+	var docRefs []*firestore.DocumentRef
+	for _, name := range galleryNames {
+		if docRef := getGalleryDocRef(name); docRef != nil {
+			docRefs = append(docRefs, docRef)
+		}
+	}
+	docSnaps, err := firestoreClient.GetAll(ctx, docRefs)
 	if err != nil {
-		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docRef", docRef).Msg("Failed to delete document")
+		log.Error().Err(err).Caller().Interface("interaction", i).Str("category", category).Msg("Failed to retrieve document contents")
 		embed = discordgo.MessageEmbed{
-			Description: "Unable to delete gallery :stop_sign:",
+			Description: "Unable to get gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	type pooledImage struct {
+		gallery  string
+		imageNum int
+		of       int
+		image    Image
+	}
+	var pool []pooledImage
+	for _, docSnap := range docSnaps {
+		if !docSnap.Exists() {
+			continue
+		}
+		var gallery Gallery
+		if err := docSnap.DataTo(&gallery); err != nil {
+			log.Warn().Err(err).Str("gallery", docSnap.Ref.ID).Msg("Left unreadable gallery out of category")
+			continue
+		}
+		if gallery.Disabled {
+			continue
+		}
+		for n, image := range gallery.Images {
+			pool = append(pool, pooledImage{gallery: docSnap.Ref.ID, imageNum: n, of: len(gallery.Images), image: image})
+		}
+	}
+	if len(pool) == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("None of the galleries in `%s` have any images :stop_sign:", category),
 			Color:       0xf04747,
 		}
 		data.Embeds = []*discordgo.MessageEmbed{&embed}
 		return data
 	}
+
+	chosen := pool[rand.Intn(len(pool))]
 	embed = discordgo.MessageEmbed{
-		Description: fmt.Sprintf("Gallery `%s` deleted :white_check_mark:", galleryName),
-		Color:       0x43b581,
+		Image: &discordgo.MessageEmbedImage{
+			URL: displayImageURL(chosen.image.ImageURL),
+		},
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Image: %d of %d | Gallery: %s", chosen.imageNum, chosen.of-1, chosen.gallery),
+		},
 	}
+	addCaption(&embed, chosen.image)
+	addAltTextField(&embed, chosen.image)
+	recordView(chosen.gallery, chosen.image.ImageURL)
 	data.Embeds = []*discordgo.MessageEmbed{&embed}
-	log.Debug().Msgf("Deleted gallery '%s'", galleryName)
-	updateCommands()
 	return data
 }
 
-// Adding/removing galleries has side-effects for the pre-populated galleryName choices
-func updateCommands() {
-	choices := populateGalleryChoices()
-	// Any changes to command order need to be reflected here
-	commands[0].Options[0].Options[0].Choices = choices // gallery.random.galleryName.Choices
-	commands[0].Options[1].Options[0].Choices = choices // gallery.pick.galleryName.Choices
-	commands[0].Options[2].Options[0].Choices = choices // gallery.add_image.galleryName.Choices
-	commands[0].Options[3].Options[0].Choices = choices // gallery.remove_image.galleryName.Choices
-	commands[0].Options[4].Options[0].Choices = choices // gallery.delete.galleryName.Choices
-
-	for _, v := range commands {
-		// log.Debug().Interface("cmd", v).Msg("Attempting to create command")
-		_, err := s.ApplicationCommandCreate(s.State.User.ID, config["guildId"], v)
+func getImageFromGallery(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("pick")
+	defer done()
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+	imageNum := int(command.Options[1].IntValue())
+
+	docRef := getGalleryDocRef(galleryName)
+	if docRef != nil {
+		docSnap, err := docRef.Get(ctx)
+		if err != nil {
+			log.Error().Err(err).Caller().Interface("interaction", i).Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+			embed = discordgo.MessageEmbed{
+				Description: "Unable to get gallery contents :stop_sign:",
+				Color:       0xf04747,
+			}
+			data.Embeds = []*discordgo.MessageEmbed{&embed}
+			return data
+		}
+		var gallery Gallery
+		err = docSnap.DataTo(&gallery)
 		if err != nil {
-			log.Error().Err(err).Caller().Msgf("Cannot (re?)create '%s' command", v.Name)
-		} /* else {
-			log.Debug().Msgf("Successfully (re)created '%s' command", cmd.Name)
-		} */
+			log.Error().Err(err).Caller().Interface("interaction", i).Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+			embed = discordgo.MessageEmbed{
+				Description: "Unable to get gallery contents :stop_sign:",
+				Color:       0xf04747,
+			}
+			data.Embeds = []*discordgo.MessageEmbed{&embed}
+			return data
+		}
+		if gallery.Disabled {
+			data.Embeds = []*discordgo.MessageEmbed{galleryDisabledEmbed(galleryName)}
+			return data
+		}
+		images := gallery.Images
+		numberOfImages := len(images)
+		if numberOfImages > 0 {
+			if imageNum < 0 || imageNum >= numberOfImages {
+				if numberOfImages == 1 {
+					embed = discordgo.MessageEmbed{
+						Description: "Invalid image number :stop_sign:\n(Only image number 0 is valid. Perhaps add more images to the gallery?)",
+						Color:       0xf04747,
+					}
+				} else {
+					embed = discordgo.MessageEmbed{
+						Description: fmt.Sprintf("Invalid image number :stop_sign: (Valid image numbers include 0 through %d inclusive.)", numberOfImages-1),
+						Color:       0xf04747,
+					}
+				}
+			} else {
+				embed = discordgo.MessageEmbed{
+					Image: &discordgo.MessageEmbedImage{
+						URL: displayImageURL(images[imageNum].ImageURL),
+					},
+					Footer: &discordgo.MessageEmbedFooter{
+						Text: fmt.Sprintf("Image: %d of %d | Gallery: %s", imageNum, numberOfImages-1, galleryName),
+					},
+				}
+				addCaption(&embed, images[imageNum])
+				addAltTextField(&embed, images[imageNum])
+				recordView(galleryName, images[imageNum].ImageURL)
+			}
+		} else {
+			embed = emptyGalleryEmbed(galleryName, gallery)
+			log.Debug().Msg("Attempted image retrieval from empty gallery")
+		}
+	} else {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+		log.Warn().Interface("interaction", i).Msg("Attempted image retrieval from non-existent gallery")
 	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
 }
 
-var (
-	commands = []*discordgo.ApplicationCommand{
-		{
-			Name:        "gallery",
-			Description: "Server-wide image gallery",
-			Options: []*discordgo.ApplicationCommandOption{
+func addImageToGallery(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+	imageUrl := command.Options[1].StringValue()
+	timestamp := fmt.Sprint(time.Now().Unix())
+	authorId := interactionUserID(i)
+
+	submittedUrl := imageUrl
+	if stripTrackingParams {
+		imageUrl = stripTrackingParameters(imageUrl)
+	}
+	altText := ""
+	if option := findOption(command.Options, "alt"); option != nil {
+		altText = strings.TrimSpace(option.StringValue())
+	}
+	if len([]rune(altText)) > maxAltTextLength {
+		data.Embeds = []*discordgo.MessageEmbed{altTextTooLongEmbed()}
+		return data
+	}
+	caption := ""
+	if option := findOption(command.Options, "caption"); option != nil {
+		caption = strings.TrimSpace(option.StringValue())
+	}
+	if len([]rune(caption)) > maxCaptionLength {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Captions can be at most %d characters long :stop_sign:", maxCaptionLength),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	if err := validateImageURL(imageUrl); err != nil {
+		log.Debug().Err(err).Str("imageUrl", imageUrl).Msg("Attempted to add invalid image link")
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("That isn't a usable image link :stop_sign: (%s)", err),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	if verifyImageReachable {
+		if err := checkImageReachable(imageUrl); err != nil {
+			log.Debug().Err(err).Str("imageUrl", imageUrl).Msg("Attempted to add unreachable image link")
+			embed = discordgo.MessageEmbed{
+				Description: fmt.Sprintf("That image couldn't be fetched :stop_sign: (%s)", err),
+				Color:       0xf04747,
+			}
+			data.Embeds = []*discordgo.MessageEmbed{&embed}
+			return data
+		}
+	}
+
+	image := Image{
+		ImageURL:            imageUrl,
+		Timestamp:           timestamp,
+		AuthorID:            authorId,
+		Alt:                 altText,
+		Caption:             caption,
+		SourceInteractionID: i.ID, // Lets find_image trace the bot's reply back to this image
+	}
+	if option := findOption(command.Options, "tags"); option != nil {
+		if tags := parseTags(option.StringValue()); len(tags) > 0 {
+			image.Tags = strings.Join(tags, ",")
+		}
+	}
+	autoTags := applyTagRules(&image)
+
+	maxImages := lookupGuildConfig(i.GuildID).MaxImagesPerGallery
+	imageNum, err := addImage(galleryName, image, maxImages)
+	var formatErr formatNotAllowedError
+	if status.Code(err) == codes.NotFound {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if errors.Is(err, errGalleryLocked) {
+		data.Embeds = []*discordgo.MessageEmbed{galleryLockedEmbed(galleryName)}
+		return data
+	} else if errors.Is(err, errGalleryFull) {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Gallery `%s` already has the %d images this server allows :stop_sign:", galleryName, maxImages),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if errors.Is(err, errImageBanned) {
+		log.Info().Str("imageUrl", imageUrl).Str("user", authorId).Msg("Attempted to add banned image")
+		embed = discordgo.MessageEmbed{
+			Description: "That image has been banned by the server's administrators :no_entry:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		data.Flags = messageFlagsEphemeral
+		return data
+	} else if errors.As(err, &formatErr) {
+		detected := "Couldn't tell what format that image is."
+		if len(formatErr.Format) > 0 {
+			detected = fmt.Sprintf("That image is a %s.", formatErr.Format)
+		}
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Gallery `%s` only accepts %s images :stop_sign: (%s)", galleryName, strings.Join(formatErr.Allowed, ", "), detected),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Str("gallery", galleryName).Msg("Failed to write document contents")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to modify gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	log.Debug().Str("imageUrl", imageUrl).Str("user", authorId).Str("gallery", galleryName).Msg("Image added to gallery")
+	postGuildAuditLog(i, fmt.Sprintf("added image `%d` to `%s`: %s", imageNum, galleryName, imageUrl))
+
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("Image `%d` created!", imageNum),
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   "In gallery",
+				Value:  fmt.Sprintf("`%s`", galleryName),
+				Inline: true,
+			},
+			{
+				Name:   "Added by",
+				Value:  fmt.Sprintf("<@%s>", authorId),
+				Inline: true,
+			},
+			{
+				Name:   "Created at",
+				Value:  fmt.Sprintf("<t:%s>", timestamp),
+				Inline: true,
+			},
+		},
+	}
+	if len(image.Tags) > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   "Tags",
+			Value:  strings.ReplaceAll(image.Tags, ",", ", "),
+			Inline: true,
+		})
+	}
+	if len(autoTags) > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   "Tagged automatically",
+			Value:  strings.Join(autoTags, ", "),
+			Inline: true,
+		})
+	}
+	if imageUrl != submittedUrl {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Tracking parameters removed",
+			Value: imageUrl,
+		})
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// webhookMaxBodyBytes bounds the requests the webhook endpoint will read
+const webhookMaxBodyBytes = 64 * 1024
+
+// webhookImageRequest is what the webhook endpoint accepts, as JSON
+type webhookImageRequest struct {
+	Gallery  string `json:"gallery"`
+	ImageUrl string `json:"imageUrl"`
+	Tags     string `json:"tags"` // Comma-separated, like the add_image option
+	Alt      string `json:"alt"`
+}
+
+// writeWebhookResponse replies to a webhook request with a JSON status, plus the message for failures
+func writeWebhookResponse(w http.ResponseWriter, code int, message string, extra map[string]interface{}) {
+	body := map[string]interface{}{"status": "ok"}
+	if code >= 400 {
+		body["status"] = "error"
+		body["error"] = message
+	}
+	for k, v := range extra {
+		body[k] = v
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// handleWebhookImage adds an image sent by another tool, with the same checks add_image makes
+func handleWebhookImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeWebhookResponse(w, http.StatusMethodNotAllowed, "only POST is supported", nil)
+		return
+	}
+	key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(key), []byte(webhookApiKey)) != 1 {
+		log.Warn().Str("remote", r.RemoteAddr).Msg("Webhook request with a missing or wrong API key")
+		writeWebhookResponse(w, http.StatusUnauthorized, "missing or invalid API key", nil)
+		return
+	}
+
+	var request webhookImageRequest
+	err := json.NewDecoder(io.LimitReader(r.Body, webhookMaxBodyBytes)).Decode(&request)
+	if err != nil {
+		writeWebhookResponse(w, http.StatusBadRequest, "the body must be a JSON object with gallery and imageUrl", nil)
+		return
+	}
+	request.Gallery = strings.TrimSpace(request.Gallery)
+	request.ImageUrl = strings.TrimSpace(request.ImageUrl)
+	request.Alt = strings.TrimSpace(request.Alt)
+	if len(request.Gallery) == 0 {
+		writeWebhookResponse(w, http.StatusBadRequest, "gallery is required", nil)
+		return
+	}
+	if err = validateImageURL(request.ImageUrl); err != nil {
+		writeWebhookResponse(w, http.StatusBadRequest, fmt.Sprintf("imageUrl is invalid: %s", err), nil)
+		return
+	}
+	if len([]rune(request.Alt)) > maxAltTextLength {
+		writeWebhookResponse(w, http.StatusBadRequest, fmt.Sprintf("alt must be at most %d characters", maxAltTextLength), nil)
+		return
+	}
+
+	imageUrl := request.ImageUrl
+	if stripTrackingParams {
+		imageUrl = stripTrackingParameters(imageUrl)
+	}
+	image := Image{
+		ImageURL:  imageUrl,
+		Timestamp: fmt.Sprint(time.Now().Unix()),
+		AuthorID:  webhookAuthorId,
+		Alt:       request.Alt,
+	}
+	if tags := parseTags(request.Tags); len(tags) > 0 {
+		image.Tags = strings.Join(tags, ",")
+	}
+	applyTagRules(&image)
+
+	// The webhook isn't tied to a guild, so the home guild's limit applies
+	imageNum, err := addImage(request.Gallery, image, lookupGuildConfig(config["guildId"]).MaxImagesPerGallery)
+	var formatErr formatNotAllowedError
+	if status.Code(err) == codes.NotFound {
+		writeWebhookResponse(w, http.StatusNotFound, "gallery does not exist", nil)
+	} else if errors.Is(err, errGalleryLocked) {
+		writeWebhookResponse(w, http.StatusConflict, "gallery is locked", nil)
+	} else if errors.Is(err, errGalleryFull) {
+		writeWebhookResponse(w, http.StatusConflict, "gallery is full", nil)
+	} else if errors.Is(err, errImageBanned) {
+		writeWebhookResponse(w, http.StatusForbidden, "image is banned", nil)
+	} else if errors.As(err, &formatErr) {
+		writeWebhookResponse(w, http.StatusUnprocessableEntity, fmt.Sprintf("gallery only accepts %s images", strings.Join(formatErr.Allowed, ", ")), nil)
+	} else if err != nil {
+		log.Error().Err(err).Caller().Interface("request", request).Msg("Failed to add image from webhook")
+		writeWebhookResponse(w, http.StatusInternalServerError, "unable to modify gallery contents", nil)
+	} else {
+		log.Debug().Str("imageUrl", imageUrl).Str("gallery", request.Gallery).Msg("Image added to gallery from webhook")
+		writeWebhookResponse(w, http.StatusCreated, "", map[string]interface{}{"gallery": request.Gallery, "imageNumber": imageNum})
+	}
+}
+
+// errImageBanned refuses an image that matches a ban (see imageBanned)
+var errImageBanned = errors.New("image is banned")
+
+// errGalleryFull refuses an image past the guild's MaxImagesPerGallery
+var errGalleryFull = errors.New("gallery is full")
+
+// formatNotAllowedError refuses an image in a format the gallery doesn't accept
+type formatNotAllowedError struct {
+	Allowed []string
+	Format  string // Empty if the format couldn't be detected
+}
+
+func (e formatNotAllowedError) Error() string {
+	return fmt.Sprintf("format '%s' is not one of %s", e.Format, strings.Join(e.Allowed, ", "))
+}
+
+// addImage appends image to a gallery once it passes the bans, the gallery's lock, its allowed formats, and (unless it's 0) maxImages, returning the new image's number
+// This is what every way of adding a single image shares. image needs at least imageUrl, timestamp, and authorId; a contentHash is added to it when hashImageContent is set.
+func addImage(galleryName string, image Image, maxImages int) (imageNum int, err error) {
+	err = screenImage(&image)
+	if err != nil {
+		return 0, err
+	}
+	docRef := getGalleryDocRef(galleryName)
+	if docRef == nil {
+		return 0, status.Error(codes.NotFound, "not a valid gallery name") // Firestore won't make a reference for it
+	}
+	// Detection may ask the image's host, which mustn't happen inside the transaction: it would hold up every other write to the gallery, and retries would repeat it
+	format, formatDetected := "", false
+	if galleryRestrictsFormats(docRef) {
+		format, formatDetected = detectImageFormat(image.ImageURL), true
+	}
+
+	// Hashing and detection may download the image, so the deadline only starts once they're done
+	ctx, done := requestContext("addImage")
+	defer done()
+	// Read and write in one transaction, so that two images added at once can't overwrite each other
+	var gallery Gallery
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(docRef)
+		if err != nil {
+			return err
+		}
+		gallery = Gallery{}
+		err = docSnap.DataTo(&gallery)
+		if err != nil {
+			return err
+		}
+		if gallery.Locked {
+			return errGalleryLocked
+		}
+		if maxImages > 0 && len(gallery.Images) >= maxImages {
+			return errGalleryFull
+		}
+		if len(gallery.AllowedFormats) > 0 {
+			if !formatDetected {
+				// The formats were restricted after the check above, so go by the extension alone
+				format = imageExtensionFormat(image.ImageURL)
+			}
+			if !formatAllowed(gallery, format) {
+				return formatNotAllowedError{Allowed: gallery.AllowedFormats, Format: format}
+			}
+		}
+		gallery.Images = append(gallery.Images, image)
+		gallery.Disabled = false // Give the gallery another chance now that it has an image that might work
+		return tx.Set(docRef, gallery)
+	})
+	if err != nil {
+		return 0, err
+	}
+	imageNum = len(gallery.Images) - 1
+	recordAuditEvent(galleryName, AuditEvent{
+		Action:  auditActionAddImage,
+		ActorID: image.AuthorID,
+		Index:   imageNum,
+		Image:   &image,
+	})
+	if len(gallery.MirrorChannelID) > 0 && (gallery.MirrorUntil.IsZero() || time.Now().Before(gallery.MirrorUntil)) {
+		go mirrorImageAdd(gallery.MirrorChannelID, galleryName, image, imageNum)
+	}
+	return imageNum, nil
+}
+
+// screenImage hashes image's content when hashImageContent is set, then returns errImageBanned if it's banned
+// Hashing downloads the image, so this shouldn't run inside a transaction.
+func screenImage(image *Image) error {
+	if hashImageContent {
+		contentHash, err := hashImageBytes(image.ImageURL)
+		if err != nil {
+			// The image may just be slow or unreachable right now, so fall back to the link alone rather than refuse it
+			log.Warn().Err(err).Str("imageUrl", image.ImageURL).Msg("Unable to hash image content")
+		} else {
+			image.ContentHash = contentHash
+		}
+	}
+	banned, err := imageBanned(*image)
+	if err != nil {
+		return err
+	}
+	if banned {
+		return errImageBanned
+	}
+	return nil
+}
+
+// galleryRestrictsFormats reports whether the gallery at docRef only accepts some image formats, going by a read outside of any transaction
+// A gallery that doesn't exist or can't be read is treated as unrestricted, leaving the error to the write that follows.
+func galleryRestrictsFormats(docRef *firestore.DocumentRef) bool {
+	ctx, done := requestContext("addImage")
+	defer done()
+	docSnap, err := docRef.Get(ctx)
+	if err != nil {
+		return false
+	}
+	var gallery Gallery
+	return docSnap.DataTo(&gallery) == nil && len(gallery.AllowedFormats) > 0
+}
+
+// mirrorImageAdd posts an image that was just added to its gallery's mirror channel
+// The add has already succeeded, so failures (usually missing permissions in the channel) are only logged.
+func mirrorImageAdd(channelId string, galleryName string, image Image, imageNum int) {
+	addedBy := "someone unknown"
+	if len(image.AuthorID) > 0 {
+		addedBy = fmt.Sprintf("<@%s>", image.AuthorID)
+	}
+	embed := discordgo.MessageEmbed{
+		Description: fmt.Sprintf("%s added an image to `%s` :inbox_tray:", addedBy, galleryName),
+		Color:       0x5865f2,
+		Image: &discordgo.MessageEmbedImage{
+			URL: displayImageURL(image.ImageURL),
+		},
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Image: %d | Gallery: %s", imageNum, galleryName),
+		},
+	}
+	addAltTextField(&embed, image)
+	fitEmbedLimits([]*discordgo.MessageEmbed{&embed})
+	_, err := s.ChannelMessageSendEmbed(channelId, &embed)
+	if err != nil {
+		log.Warn().Err(err).Str("channel", channelId).Str("gallery", galleryName).Msg("Failed to mirror added image (are the bot's permissions in the channel missing?)")
+	}
+}
+
+func removeImagePrompt(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("remove_image")
+	defer done()
+	var messageComponents []discordgo.MessageComponent
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+	imageNum := int(command.Options[1].IntValue())
+
+	docRef := getGalleryDocRef(galleryName)
+	docSnap, err := docRef.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docRef", docRef).Msg("Attempted to delete image from non-existent gallery")
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	var gallery Gallery
+	err = docSnap.DataTo(&gallery)
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	if gallery.Locked {
+		data.Embeds = []*discordgo.MessageEmbed{galleryLockedEmbed(galleryName)}
+		return data
+	}
+	images := gallery.Images
+	numberOfImages := len(images)
+	if numberOfImages > 0 {
+		if imageNum < 0 || imageNum >= numberOfImages {
+			if numberOfImages == 1 {
+				embed = discordgo.MessageEmbed{
+					Description: "Invalid image number :stop_sign: (Only image number 0 exists.)",
+					Color:       0xf04747,
+				}
+			} else {
+				embed = discordgo.MessageEmbed{
+					Description: fmt.Sprintf("Invalid image number :stop_sign: (Valid image numbers include 0 through %d inclusive.)", numberOfImages-1),
+					Color:       0xf04747,
+				}
+			}
+			data.Embeds = []*discordgo.MessageEmbed{&embed}
+			return data
+		} else {
+			embed = discordgo.MessageEmbed{
+				Description: "Are you sure you want to delete the below image? :thinking:",
+				Color:       0x5865f2,
+				Image: &discordgo.MessageEmbedImage{
+					URL: displayImageURL(gallery.Images[imageNum].ImageURL),
+				},
+				Fields: []*discordgo.MessageEmbedField{
+					{
+						Name:   "In gallery",
+						Value:  fmt.Sprintf("`%s`", galleryName),
+						Inline: true,
+					},
+					{
+						Name:   "Image number",
+						Value:  fmt.Sprint(imageNum),
+						Inline: true,
+					},
+					{
+						Name:   "Added by",
+						Value:  fmt.Sprintf("<@%s>", gallery.Images[imageNum].AuthorID),
+						Inline: true,
+					},
+					{
+						Name:   "Created at",
+						Value:  fmt.Sprintf("<t:%s>", gallery.Images[imageNum].Timestamp),
+						Inline: true,
+					},
+				},
+			}
+			addCaption(&embed, gallery.Images[imageNum])
+			messageComponents = []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.Button{
+							Label:    deleteConfirmLabel,
+							Style:    deleteConfirmStyle,
+							CustomID: componentID("image_delete_yes", galleryName, fmt.Sprint(imageNum)),
+						},
+						discordgo.Button{
+							Label:    deleteCancelLabel,
+							Style:    deleteCancelStyle,
+							CustomID: componentID("image_delete_no", galleryName, fmt.Sprint(imageNum)),
+						},
+					},
+				},
+			}
+			data.Embeds = []*discordgo.MessageEmbed{&embed}
+			data.Components = messageComponents
+			return data
+		}
+	} else {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+		log.Warn().Interface("interaction", i).Msg("Attempted image retrieval from non-existent gallery")
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+}
+
+func removeImage(i *discordgo.Interaction, galleryName string, imageNum int, expectedUrl string) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("image_delete_yes")
+	defer done()
+	var removedImage Image
+	var numberOfImages int
+	var wasFeatured bool
+
+	// Read and write in one transaction, so that a bulk rewrite (e.g. dedupe) that lands after the prompt can't be undone, nor make this remove a different image than the one confirmed
+	docRef := getGalleryDocRef(galleryName)
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(docRef)
+		if err != nil {
+			return err
+		}
+		var gallery Gallery
+		err = docSnap.DataTo(&gallery)
+		if err != nil {
+			return err
+		}
+		if gallery.Locked {
+			return errGalleryLocked
+		}
+		numberOfImages = len(gallery.Images)
+		if imageNum < 0 || imageNum >= numberOfImages {
+			return errInvalidImageNumber
+		}
+		// Compared loosely, since a stored attachment link may have been re-signed (see refreshExpiringAttachments) since the prompt was shown
+		if len(expectedUrl) > 0 && canonicalImageURL(gallery.Images[imageNum].ImageURL) != canonicalImageURL(expectedUrl) {
+			return errImageMoved
+		}
+		removedImage = gallery.Images[imageNum]
+		gallery.Images = append(gallery.Images[:imageNum], gallery.Images[imageNum+1:]...)
+		wasFeatured = gallery.FeaturedIndex != nil && *gallery.FeaturedIndex == imageNum
+		if gallery.FeaturedIndex != nil {
+			if *gallery.FeaturedIndex == imageNum {
+				gallery.FeaturedIndex = nil
+			} else if *gallery.FeaturedIndex > imageNum {
+				*gallery.FeaturedIndex--
+			}
+		}
+		return tx.Set(docRef, gallery)
+	})
+	if errors.Is(err, errImageMoved) {
+		log.Debug().Int("imageNum", imageNum).Str("gallery", galleryName).Msg("Refused to remove image that changed since it was confirmed")
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Gallery `%s` changed since you were asked, so image `%d` is no longer the image shown :stop_sign: (Run `/gallery remove_image` again to pick it by its new number.)", galleryName, imageNum),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if err != nil {
+		data.Embeds = []*discordgo.MessageEmbed{editImageErrorEmbed(i, galleryName, numberOfImages, err)}
+		return data
+	}
+
+	log.Debug().Str("imageNum", fmt.Sprint(imageNum)).Str("gallery", galleryName).Msg("Image removed from gallery")
+	postGuildAuditLog(i, fmt.Sprintf("removed image `%d` from `%s`: %s", imageNum, galleryName, removedImage.ImageURL))
+	recordAuditEvent(galleryName, AuditEvent{
+		Action:  auditActionRemoveImage,
+		ActorID: interactionUserID(i),
+		Index:   imageNum,
+		Image:   &removedImage,
+	})
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("Image `%d` removed from `%s` :white_check_mark:", imageNum, galleryName),
+		Color:       0x43b581,
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	if i.Message != nil {
+		stashRemovedImage(i.Message.ID, removedImageUndo{
+			gallery:  galleryName,
+			index:    imageNum,
+			image:    removedImage,
+			featured: wasFeatured,
+		})
+		embed.Footer = &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Changed your mind? This can be undone for %d minutes.", int(imageUndoWindow.Minutes())),
+		}
+		data.Components = []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{
+						Label:    "Undo",
+						Style:    discordgo.SecondaryButton,
+						CustomID: componentID("image_undo"),
+					},
+				},
+			},
+		}
+	}
+	return data
+}
+
+// removedImageUndo is what image_undo needs to put a removed image back where it was
+type removedImageUndo struct {
+	gallery  string
+	index    int
+	image    Image
+	featured bool // Whether it was the gallery's featured image
+	expires  time.Time
+}
+
+// imageUndoWindow is how long after removing an image its Undo button keeps working
+const imageUndoWindow = 10 * time.Minute
+
+// stashRemovedImage remembers a removed image under the ID of the message confirming the removal until imageUndoWindow passes
+// Expired entries are dropped along the way, so abandoned undos don't pile up.
+func stashRemovedImage(messageId string, undo removedImageUndo) {
+	removedImagesMutex.Lock()
+	defer removedImagesMutex.Unlock()
+	for id, v := range removedImages {
+		if time.Now().After(v.expires) {
+			delete(removedImages, id)
+		}
+	}
+	undo.expires = time.Now().Add(imageUndoWindow)
+	removedImages[messageId] = undo
+}
+
+// undoImageRemoval puts the image removed from the message behind i back at its old number, shifting later images along
+// The stashed image is taken up front so that a second click can't add it twice; it's only put back if the write fails for a reason that may pass.
+func undoImageRemoval(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("image_undo")
+	defer done()
+	data.Components = []discordgo.MessageComponent{}
+
+	removedImagesMutex.Lock()
+	undo, ok := removedImages[i.Message.ID]
+	delete(removedImages, i.Message.ID)
+	removedImagesMutex.Unlock()
+	if !ok || time.Now().After(undo.expires) {
+		embed = discordgo.MessageEmbed{
+			Description: "This removal can no longer be undone :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	imageNum := undo.index
+	docRef := getGalleryDocRef(undo.gallery)
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(docRef)
+		if err != nil {
+			return err
+		}
+		var gallery Gallery
+		err = docSnap.DataTo(&gallery)
+		if err != nil {
+			return err
+		}
+		if gallery.Locked {
+			return errGalleryLocked
+		}
+		imageNum = undo.index
+		if imageNum > len(gallery.Images) {
+			imageNum = len(gallery.Images) // Images were removed after it, so it goes at the end
+		}
+		gallery.Images = append(gallery.Images[:imageNum], append([]Image{undo.image}, gallery.Images[imageNum:]...)...)
+		if gallery.FeaturedIndex != nil && *gallery.FeaturedIndex >= imageNum {
+			*gallery.FeaturedIndex++
+		} else if gallery.FeaturedIndex == nil && undo.featured {
+			gallery.FeaturedIndex = &imageNum
+		}
+		return tx.Set(docRef, gallery)
+	})
+	if status.Code(err) == codes.NotFound {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Gallery `%s` was deleted since, so the image can't be put back :stop_sign:", undo.gallery),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if errors.Is(err, errGalleryLocked) {
+		data.Embeds = []*discordgo.MessageEmbed{galleryLockedEmbed(undo.gallery)}
+		return data
+	} else if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Str("gallery", undo.gallery).Msg("Failed to write document contents")
+		stashRemovedImage(i.Message.ID, undo) // Let them try again
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to modify gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		data.Components = nil // Keep the Undo button
+		return data
+	}
+
+	log.Debug().Int("imageNum", imageNum).Str("gallery", undo.gallery).Msg("Undid image removal")
+	postGuildAuditLog(i, fmt.Sprintf("put image `%d` back in `%s`: %s", imageNum, undo.gallery, undo.image.ImageURL))
+	recordAuditEvent(undo.gallery, AuditEvent{
+		Action:  auditActionAddImage,
+		ActorID: interactionUserID(i),
+		Index:   imageNum,
+		Image:   &undo.image,
+	})
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("Image `%d` is back in `%s` :white_check_mark:", imageNum, undo.gallery),
+		Color:       0x43b581,
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+func createGallery(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("create")
+	defer done()
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+	if invalid := invalidGalleryNameEmbed(galleryName); invalid != nil {
+		data.Embeds = []*discordgo.MessageEmbed{invalid}
+		return data
+	}
+
+	docRef := getGalleryDocRef(galleryName)
+	_, err := docRef.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		_, err := docRef.Set(ctx, Gallery{CreatedAt: time.Now()})
+		if err != nil {
+			log.Error().Err(err).Caller().Interface("interaction", i).Interface("docRef", docRef).Msg("Failed to create document")
+			embed = discordgo.MessageEmbed{
+				Description: "Unable to create gallery :stop_sign:",
+				Color:       0xf04747,
+			}
+			data.Embeds = []*discordgo.MessageEmbed{&embed}
+			return data
+		}
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Gallery `%s` created :white_check_mark:", galleryName),
+			Color:       0x43b581,
+		}
+		log.Debug().Msgf("Created new gallery '%s'", galleryName)
+		noteGalleryCreated(galleryName)
+		postGuildAuditLog(i, fmt.Sprintf("created gallery `%s`", galleryName))
+		recordAuditEvent(galleryName, AuditEvent{
+			Action:  auditActionCreate,
+			ActorID: interactionUserID(i),
+		})
+	} else if status.Code(err) == codes.OK {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery already exists :stop_sign:",
+			Color:       0xf04747,
+		}
+		log.Debug().Msg("Attempted to create a gallery that already exists")
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+func deleteGalleryPrompt(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("delete")
+	defer done()
+	var messageComponents []discordgo.MessageComponent
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+
+	docRef := getGalleryDocRef(galleryName)
+	docSnap, err := docRef.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docRef", docRef).Msg("Attempted to delete non-existent gallery")
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	var gallery Gallery
+	if err == nil {
+		err = docSnap.DataTo(&gallery)
+	}
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	// Large galleries go through deleteLargeGalleryPrompt before the final confirmation
+	confirmId := "gallery_delete_yes"
+	if len(gallery.Images) > deleteConfirmThreshold {
+		confirmId = "gallery_delete_large"
+	}
+	embed = discordgo.MessageEmbed{
+		Description: "Are you sure you want to delete the following gallery? :thinking:",
+		Color:       0x5865f2,
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:  "Gallery",
+				Value: fmt.Sprintf("`%s`", galleryName),
+			},
+		},
+	}
+	messageComponents = []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    deleteConfirmLabel,
+					Style:    deleteConfirmStyle,
+					CustomID: componentID(confirmId, galleryName),
+				},
+				discordgo.Button{
+					Label:    deleteCancelLabel,
+					Style:    deleteCancelStyle,
+					CustomID: componentID("gallery_delete_no", galleryName),
+				},
+			},
+		},
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	data.Components = messageComponents
+	return data
+}
+
+// deleteLargeGalleryPrompt asks again before deleting a gallery with more than deleteConfirmThreshold images, spelling out how many would be lost
+func deleteLargeGalleryPrompt(i *discordgo.Interaction, galleryName string) (data discordgo.InteractionResponseData) {
+	gallery, problem := loadGallery(i, galleryName)
+	if problem != nil {
+		data.Embeds = []*discordgo.MessageEmbed{problem}
+		data.Components = []discordgo.MessageComponent{}
+		return data
+	}
+	embed := discordgo.MessageEmbed{
+		Description: fmt.Sprintf("This gallery has %d images, and they will all be gone. Are you really sure? :warning:", len(gallery.Images)),
+		Color:       0xf04747,
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:  "Gallery",
+				Value: fmt.Sprintf("`%s`", galleryName),
+			},
+		},
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	data.Components = []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    truncateText(strings.ReplaceAll(deleteConfirmLargeLabel, "%d", strconv.Itoa(len(gallery.Images))), maxButtonLabelLength),
+					Style:    deleteConfirmStyle,
+					CustomID: componentID("gallery_delete_yes", galleryName),
+				},
+				discordgo.Button{
+					Label:    deleteCancelLabel,
+					Style:    deleteCancelStyle,
+					CustomID: componentID("gallery_delete_no", galleryName),
+				},
+			},
+		},
+	}
+	return data
+}
+
+func deleteGallery(i *discordgo.Interaction, galleryName string) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("gallery_delete_yes")
+	defer done()
+
+	docRef := getGalleryDocRef(galleryName)
+	_, err := docRef.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docRef", docRef).Msg("Attempted to delete non-existent gallery")
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	err = removeGallery(i, galleryName)
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docRef", docRef).Msg("Failed to delete document")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to delete gallery :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("Gallery `%s` deleted :white_check_mark:", galleryName),
+		Color:       0x43b581,
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// removeGallery deletes a gallery's document and records it in the audit log
+// preconditions are passed to the delete, e.g. to only delete a gallery that hasn't changed since it was read.
+func removeGallery(i *discordgo.Interaction, galleryName string, preconditions ...firestore.Precondition) error {
+	ctx, done := requestContext("removeGallery")
+	defer done()
+	_, err := getGalleryDocRef(galleryName).Delete(ctx, preconditions...)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msgf("Deleted gallery '%s'", galleryName)
+	noteGalleryDeleted(galleryName)
+	postGuildAuditLog(i, fmt.Sprintf("deleted gallery `%s`", galleryName))
+	recordAuditEvent(galleryName, AuditEvent{
+		Action:  auditActionDelete,
+		ActorID: interactionUserID(i),
+	})
+	return nil
+}
+
+// errGalleryExists aborts a transaction that would have overwritten another gallery
+var errGalleryExists = errors.New("gallery already exists")
+
+// renameGallery moves a gallery's document to a new name, in one transaction so that a failure can't leave both or neither
+// The old name's audit history stays behind; the new name's starts with the gallery's contents at the time of the rename.
+func renameGallery(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("rename")
+	defer done()
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+	newGalleryName := command.Options[1].StringValue()
+	if invalid := invalidGalleryNameEmbed(newGalleryName); invalid != nil {
+		data.Embeds = []*discordgo.MessageEmbed{invalid}
+		return data
+	}
+	if newGalleryName == galleryName {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Gallery `%s` already has that name :stop_sign:", galleryName),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	var gallery Gallery
+	var refs galleryReferences
+	docRef := getGalleryDocRef(galleryName)
+	newDocRef := getGalleryDocRef(newGalleryName)
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(docRef)
+		if err != nil {
+			return err
+		}
+		gallery = Gallery{}
+		err = docSnap.DataTo(&gallery)
+		if err != nil {
+			return err
+		}
+		if gallery.Locked {
+			return errGalleryLocked
+		}
+		_, err = tx.Get(newDocRef)
+		if err == nil {
+			return errGalleryExists
+		} else if status.Code(err) != codes.NotFound {
+			return err
+		}
+		refs, err = readGalleryReferences(tx, galleryName)
+		if err != nil {
+			return err
+		}
+		err = tx.Create(newDocRef, gallery)
+		if err != nil {
+			return err
+		}
+		err = refs.moveTo(tx, newGalleryName)
+		if err != nil {
+			return err
+		}
+		return tx.Delete(docRef)
+	})
+	if errors.Is(err, errGalleryExists) {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Gallery `%s` already exists :stop_sign:", newGalleryName),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if status.Code(err) == codes.NotFound {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if errors.Is(err, errGalleryLocked) {
+		data.Embeds = []*discordgo.MessageEmbed{galleryLockedEmbed(galleryName)}
+		return data
+	} else if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Str("gallery", galleryName).Str("newGallery", newGalleryName).Msg("Failed to rename gallery")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to modify gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	recordAuditEvent(galleryName, AuditEvent{
+		Action:  auditActionDelete,
+		ActorID: interactionUserID(i),
+	})
+	recordAuditEvent(newGalleryName, AuditEvent{
+		Action:  auditActionCreate,
+		ActorID: interactionUserID(i),
+	})
+	if len(gallery.Images) > 0 {
+		recordAuditEvent(newGalleryName, AuditEvent{
+			Action:  auditActionReplace,
+			ActorID: interactionUserID(i),
+			Images:  gallery.Images,
+		})
+	}
+	refs.apply()
+	movePendingViews(galleryName, newGalleryName)
+	noteGalleryDeleted(galleryName)
+	noteGalleryCreated(newGalleryName)
+	postGuildAuditLog(i, fmt.Sprintf("renamed gallery `%s` to `%s`", galleryName, newGalleryName))
+
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("Gallery `%s` is now called `%s` :white_check_mark:", galleryName, newGalleryName),
+		Color:       0x43b581,
+	}
+	log.Debug().Str("gallery", galleryName).Str("newGallery", newGalleryName).Msg("Renamed gallery")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// galleryReferences are what refer to a gallery by name: its repost and reveal schedules, and the categories that include it
+// A gallery that's renamed or merged away takes them along (see moveTo), since a schedule whose gallery is gone is silently dropped.
+type galleryReferences struct {
+	galleryName string
+	schedules   []*firestore.DocumentRef
+	settings    GuildSettings
+	categorized bool // Whether any category includes the gallery
+}
+
+// readGalleryReferences reads the references to galleryName as part of tx
+func readGalleryReferences(tx *firestore.Transaction, galleryName string) (refs galleryReferences, err error) {
+	refs.galleryName = galleryName
+	docSnaps, err := tx.Documents(firestoreClient.Collection("schedules").Where("gallery", "==", galleryName)).GetAll()
+	if err != nil {
+		return refs, err
+	}
+	for _, docSnap := range docSnaps {
+		refs.schedules = append(refs.schedules, docSnap.Ref)
+	}
+	docSnap, err := tx.Get(getGuildSettingsDocRef())
+	if status.Code(err) == codes.NotFound {
+		return refs, nil
+	} else if err != nil {
+		return refs, err
+	}
+	err = docSnap.DataTo(&refs.settings)
+	if err != nil {
+		return refs, err
+	}
+	for _, galleryNames := range refs.settings.Categories {
+		for _, v := range galleryNames {
+			if v == galleryName {
+				refs.categorized = true
+			}
+		}
+	}
+	return refs, nil
+}
+
+// moveTo points the references at newGalleryName instead, as part of tx
+// A category that already includes newGalleryName just loses the old name.
+func (refs *galleryReferences) moveTo(tx *firestore.Transaction, newGalleryName string) error {
+	for _, docRef := range refs.schedules {
+		err := tx.Update(docRef, []firestore.Update{{Path: "gallery", Value: newGalleryName}})
+		if err != nil {
+			return err
+		}
+	}
+	if !refs.categorized {
+		return nil
+	}
+	for category, galleryNames := range refs.settings.Categories {
+		var moved []string
+		included := make(map[string]bool)
+		for _, v := range galleryNames {
+			if v == refs.galleryName {
+				v = newGalleryName
+			}
+			if !included[v] {
+				included[v] = true
+				moved = append(moved, v)
+			}
+		}
+		refs.settings.Categories[category] = moved
+	}
+	return tx.Set(getGuildSettingsDocRef(), refs.settings)
+}
+
+// apply makes the moved categories take effect, once the transaction that moved them has committed
+func (refs galleryReferences) apply() {
+	if refs.categorized {
+		applyGuildSettings(refs.settings)
+	}
+}
+
+// movePendingViews hands the unflushed view counts of galleryName to newGalleryName, whose images they now belong to
+func movePendingViews(galleryName string, newGalleryName string) {
+	pendingViewsMutex.Lock()
+	defer pendingViewsMutex.Unlock()
+	counts, ok := pendingViews[galleryName]
+	if !ok {
+		return
+	}
+	delete(pendingViews, galleryName)
+	if pendingViews[newGalleryName] == nil {
+		pendingViews[newGalleryName] = make(map[string]int)
+	}
+	for imageUrl, count := range counts {
+		pendingViews[newGalleryName][imageUrl] += count
+	}
+}
+
+// copyGallery creates a new gallery holding the same images as an existing one, noting where it came from and who copied it
+// Only the images are copied; the new gallery starts with default settings.
+func copyGallery(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("copy")
+	defer done()
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+	newGalleryName := command.Options[1].StringValue()
+	if invalid := invalidGalleryNameEmbed(newGalleryName); invalid != nil {
+		data.Embeds = []*discordgo.MessageEmbed{invalid}
+		return data
+	}
+
+	gallery, problem := loadGallery(i, galleryName)
+	if problem != nil {
+		data.Embeds = []*discordgo.MessageEmbed{problem}
+		return data
+	}
+	newDocRef := getGalleryDocRef(newGalleryName)
+	_, err := newDocRef.Create(ctx, Gallery{
+		Images:     gallery.Images,
+		CreatedAt:  time.Now(),
+		CopiedFrom: galleryName,
+		CopiedBy:   interactionUserID(i),
+	})
+	if status.Code(err) == codes.AlreadyExists {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Gallery `%s` already exists :stop_sign:", newGalleryName),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docRef", newDocRef).Msg("Failed to create document")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to create gallery :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	recordAuditEvent(newGalleryName, AuditEvent{
+		Action:  auditActionCreate,
+		ActorID: interactionUserID(i),
+	})
+	if len(gallery.Images) > 0 {
+		recordAuditEvent(newGalleryName, AuditEvent{
+			Action:  auditActionReplace,
+			ActorID: interactionUserID(i),
+			Images:  gallery.Images,
+		})
+	}
+	noteGalleryCreated(newGalleryName)
+	postGuildAuditLog(i, fmt.Sprintf("copied gallery `%s` to `%s`", galleryName, newGalleryName))
+
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("Copied the %d images in `%s` into the new gallery `%s` :white_check_mark:", len(gallery.Images), galleryName, newGalleryName),
+		Color:       0x43b581,
+	}
+	log.Debug().Str("gallery", galleryName).Str("newGallery", newGalleryName).Int("images", len(gallery.Images)).Msg("Copied gallery")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// emptyGalleryDisposable reports whether gallery is empty and can be cleaned up as such
+// A locked gallery, or one with a fallback image, was left empty on purpose.
+func emptyGalleryDisposable(gallery Gallery) bool {
+	return len(gallery.Images) == 0 && !gallery.Locked && len(gallery.FallbackImageURL) == 0
+}
+
+// findEmptyGalleries lists the galleries without any images, offering to delete them all
+// Galleries that are locked or have a fallback image are left out (see emptyGalleryDisposable).
+func findEmptyGalleries(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+
+	docSnaps, err := getAllGalleries()
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Msg("Failed to retrieve galleries")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	var empty []string
+	for _, docSnap := range docSnaps {
+		var gallery Gallery
+		err = docSnap.DataTo(&gallery)
+		if err != nil {
+			log.Error().Err(err).Caller().Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+			continue
+		}
+		if emptyGalleryDisposable(gallery) {
+			empty = append(empty, docSnap.Ref.ID)
+		}
+	}
+	if len(empty) == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: "Every gallery has at least one image, or is kept empty on purpose (locked or with a fallback image) :white_check_mark:",
+			Color:       0x43b581,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	sort.Strings(empty)
+
+	// The button carries when the list was made, so it only deletes galleries that haven't been touched since
+	listedAt := fmt.Sprint(time.Now().UnixNano())
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("%d galleries have no images :wastebasket:\n`%s`\n\nDelete all of them? Any that get an image in the meantime will be kept.", len(empty), strings.Join(empty, "`, `")),
+		Color:       0xfaa61a,
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	data.Components = []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    fmt.Sprintf("Yes, delete %d galleries", len(empty)),
+					Style:    deleteConfirmStyle,
+					CustomID: componentID("gallery_delete_empties", listedAt),
+				},
+				discordgo.Button{
+					Label:    deleteCancelLabel,
+					Style:    deleteCancelStyle,
+					CustomID: componentID("gallery_keep_empties"),
+				},
+			},
+		},
+	}
+	return data
+}
+
+// deleteEmptyGalleries deletes the galleries that are empty and haven't changed since listedAt
+func deleteEmptyGalleries(i *discordgo.Interaction, listedAt time.Time) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+
+	docSnaps, err := getAllGalleries()
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Msg("Failed to retrieve galleries")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	var deleted, failed []string
+	for _, docSnap := range docSnaps {
+		var gallery Gallery
+		err = docSnap.DataTo(&gallery)
+		if err != nil || !emptyGalleryDisposable(gallery) || docSnap.UpdateTime.After(listedAt) {
+			continue
+		}
+		// The precondition keeps a gallery that gains an image between reading and deleting it
+		err = removeGallery(i, docSnap.Ref.ID, firestore.LastUpdateTime(docSnap.UpdateTime))
+		if err != nil {
+			log.Error().Err(err).Caller().Str("gallery", docSnap.Ref.ID).Msg("Failed to delete empty gallery")
+			failed = append(failed, docSnap.Ref.ID)
+			continue
+		}
+		deleted = append(deleted, docSnap.Ref.ID)
+	}
+
+	description := fmt.Sprintf("Deleted %d empty galleries :white_check_mark:", len(deleted))
+	color := 0x43b581
+	if len(failed) > 0 {
+		description += fmt.Sprintf("\n:warning: Couldn't delete `%s`", strings.Join(failed, "`, `"))
+		color = 0xfaa61a
+	}
+	embed = discordgo.MessageEmbed{
+		Description: description,
+		Color:       color,
+	}
+	log.Debug().Strs("deleted", deleted).Strs("failed", failed).Msg("Deleted empty galleries")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// isAdmin reports whether the member behind i holds the Administrator permission or one of their guild's AdminRoleIDs
+func isAdmin(i *discordgo.Interaction) bool {
+	if i.Member == nil {
+		return false
+	}
+	if i.Member.Permissions&discordgo.PermissionAdministrator != 0 {
+		return true
+	}
+	adminRoles := lookupGuildConfig(i.GuildID).AdminRoleIDs
+	for _, role := range i.Member.Roles {
+		for _, adminRole := range adminRoles {
+			if role == adminRole {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// canManageGalleries reports whether the member behind i may use the managedSubcommands
+// Their guild's ManagerRoleIDs take precedence over managerRoleIds. With neither set, everyone may, and administrators always may.
+func canManageGalleries(i *discordgo.Interaction) bool {
+	managerRoles := lookupGuildConfig(i.GuildID).ManagerRoleIDs
+	if len(managerRoles) == 0 {
+		managerRoles = managerRoleIds
+	}
+	if len(managerRoles) == 0 || isAdmin(i) {
+		return true
+	}
+	if i.Member == nil {
+		return false
+	}
+	for _, role := range i.Member.Roles {
+		for _, managerRole := range managerRoles {
+			if role == managerRole {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// managerOnlyResponse turns away a member without a manager role (see canManageGalleries), visible only to them
+func managerOnlyResponse(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	log.Warn().Interface("interaction", i).Msg("Member without a manager role attempted to use a managed command")
+	embed := discordgo.MessageEmbed{
+		Description: "Only gallery managers can use this command :stop_sign:",
+		Color:       0xf04747,
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	data.Flags = messageFlagsEphemeral
+	return data
+}
+
+// adminOnlyResponse turns away a non-administrator, visible only to them
+func adminOnlyResponse(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	log.Warn().Interface("interaction", i).Msg("Non-administrator attempted to use an admin command")
+	embed := discordgo.MessageEmbed{
+		Description: "Only server administrators can use this command :stop_sign:",
+		Color:       0xf04747,
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	data.Flags = messageFlagsEphemeral
+	return data
+}
+
+// showConfiguration lists the settings in effect, so operators can check what was loaded without access to the host
+// Only the required values known to be harmless are shown; any other required value is assumed to be a secret.
+func showConfiguration(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var description strings.Builder
+	line := func(key string, value interface{}) {
+		fmt.Fprintf(&description, "`%s`: %v\n", key, value)
+	}
+	list := func(values []string) string {
+		if len(values) == 0 {
+			return "(none)"
+		}
+		return strings.Join(values, ", ")
+	}
+	set := func(values map[string]bool) string {
+		if values == nil {
+			return "(all)"
+		}
+		var names []string
+		for k := range values {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		return list(names)
+	}
+
+	description.WriteString("**Required**\n")
+	var keys []string
+	for k := range config {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if k == "guildId" || k == "projectId" {
+			line(k, config[k])
+		} else {
+			line(k, "[redacted]")
+		}
+	}
+
+	description.WriteString("\n**Features**\n")
+	line("features", set(enabledFeatures))
+	guildEnabledCommandsMutex.RLock()
+	line("toggle_command", set(guildEnabledCommands))
+	guildEnabledCommandsMutex.RUnlock()
+	line("paused", atomic.LoadInt32(&paused) == 1)
+	line("stripTrackingParams", stripTrackingParams)
+	line("autoDisableBrokenGalleries", autoDisableBrokenGalleries)
+	line("hashImageContent", hashImageContent)
+	line("multiGuild", multiGuild)
+	line("verifyImageReachable", verifyImageReachable)
+	line("firestoreSelfTest", firestoreSelfTest)
+
+	description.WriteString("\n**Timing**\n")
+	line("requestTimeout", requestTimeout)
+	line("auditTimeout", auditTimeout)
+	line("viewFlushInterval", viewFlushInterval)
+	line("attachmentRefreshInterval", attachmentRefreshInterval)
+	line("galleryCacheRefreshInterval", galleryCacheRefreshInterval)
+	line("progressUpdateInterval", progressUpdateInterval)
+	line("activityUpdateInterval", activityUpdateInterval)
+	line("commandUpdateBackoff", commandUpdateBackoff)
+	line("pollDuration", pollDuration)
+	line("grantDuration", grantDuration)
+	line("convertTimeout", convertTimeout)
+	line("repostCheckInterval", repostCheckInterval)
+	line("repostMinInterval", repostMinInterval)
+	var autoDeletes []string
+	for k, v := range autoDeleteDelays {
+		autoDeletes = append(autoDeletes, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(autoDeletes)
+	line("autoDeleteResponses", list(autoDeletes))
+
+	description.WriteString("\n**Limits**\n")
+	line("auditConcurrency", auditConcurrency)
+	line("auditMaxImageBytes", auditMaxImageBytes)
+	line("importChannelMaxImages", importChannelMaxImages)
+	line("importChannelMaxMessages", importChannelMaxMessages)
+	line("exportMaxArchiveBytes", exportMaxArchiveBytes)
+	line("commandUpdateAttempts", commandUpdateAttempts)
+	line("fetchConcurrency", fetchConcurrency)
+	line("fetchChunkSize", fetchChunkSize)
+	line("deleteConfirmThreshold", deleteConfirmThreshold)
+	line("storageWarnPercent", storageWarnPercent)
+	line("similarityThreshold", similarityThreshold)
+	line("convertMaxPixels", convertMaxPixels)
+	line("recentErrorsSize", recentErrorsSize)
+	line("webhookAddress", webhookAddress)
+	line("webhookApiKey", "[redacted]")
+	line("webhookAuthorId", webhookAuthorId)
+
+	description.WriteString("\n**Other**\n")
+	line("trackingParams", list(trackingParams))
+	line("trackingParamsKeep", list(trackingParamsKeep))
+	var remaps []string
+	for k, v := range hostRemaps {
+		remaps = append(remaps, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(remaps)
+	line("hostRemaps", list(remaps))
+	line("starterGalleries", list(starterGalleries))
+	line("pollReactions", list(pollReactions))
+	line("managerRoleIds", list(managerRoleIds))
+	line("deleteConfirmLabel", strconv.Quote(deleteConfirmLabel))
+	line("deleteConfirmLargeLabel", strconv.Quote(deleteConfirmLargeLabel))
+	line("deleteCancelLabel", strconv.Quote(deleteCancelLabel))
+	line("unknownSubcommandMessage", strconv.Quote(unknownSubcommandMessage))
+
+	embed := discordgo.MessageEmbed{
+		Title:       "Effective configuration :gear:",
+		Description: description.String(),
+		Color:       0x5865f2,
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	data.Flags = messageFlagsEphemeral
+	return data
+}
+
+// adminCommands are the top-level commands whose every subcommand is restricted to administrators (and grants)
+var adminCommands = map[string]bool{
+	"gallery_admin": true,
+	"gallery_ops":   true,
+}
+
+// ungrantableSubcommands can't be granted, because they change who holds privileges and would turn a one-off grant into a lasting one
+var ungrantableSubcommands = map[string]bool{
+	"grant":        true,
+	"guild_config": true, // Sets admin and manager roles
+}
+
+// grantKey identifies a grant for userId to run one gallery_admin subcommand
+func grantKey(userId string, subcommand string) string {
+	return userId + ":" + subcommand
+}
+
+// useGrant reports whether the user behind i holds an unexpired grant for subcommand, spending it if spend is set
+func useGrant(i *discordgo.Interaction, subcommand string, spend bool) bool {
+	key := grantKey(interactionUserID(i), subcommand)
+	adminGrantsMutex.Lock()
+	defer adminGrantsMutex.Unlock()
+	expiry, ok := adminGrants[key]
+	if !ok {
+		return false
+	}
+	if time.Now().After(expiry) || spend {
+		delete(adminGrants, key)
+	}
+	if time.Now().After(expiry) {
+		return false
+	}
+	if spend {
+		log.Info().Str("user", interactionUserID(i)).Str("subcommand", subcommand).Msg("Admin grant used")
+	}
+	return true
+}
+
+// adminAllowed reports whether the member behind i may run an admin subcommand, either as an administrator or by spending a grant
+func adminAllowed(i *discordgo.Interaction, subcommand string) bool {
+	if isAdmin(i) {
+		return true
+	}
+	return !ungrantableSubcommands[subcommand] && useGrant(i, subcommand, !grantSpentOnConfirm[subcommand])
+}
+
+// grantAdminSubcommand lets a member run one gallery_admin subcommand once, within grantDuration
+// Grants are only held in memory, so a restart revokes them all.
+func grantAdminSubcommand(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+
+	command := i.ApplicationCommandData().Options[0]
+	userId := command.Options[0].UserValue(nil).ID
+	subcommand := strings.ToLower(strings.TrimSpace(command.Options[1].StringValue()))
+
+	parent := ""
+	for _, v := range commands {
+		if !adminCommands[v.Name] {
+			continue
+		}
+		for _, option := range v.Options {
+			if option.Name == subcommand {
+				parent = v.Name
+			}
+		}
+	}
+	if len(parent) == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("There is no `%s` subcommand that can be granted :stop_sign:", subcommand),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if ungrantableSubcommands[subcommand] {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("`/%s %s` changes who holds privileges, so it can't be granted :stop_sign:", parent, subcommand),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	expiry := time.Now().Add(grantDuration)
+	adminGrantsMutex.Lock()
+	adminGrants[grantKey(userId, subcommand)] = expiry
+	for key, v := range adminGrants {
+		if time.Now().After(v) {
+			delete(adminGrants, key)
+		}
+	}
+	adminGrantsMutex.Unlock()
+
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("<@%s> may run `/%s %s` once, until <t:%d:t> :key:", userId, parent, subcommand, expiry.Unix()),
+		Color:       0x43b581,
+	}
+	log.Info().Str("grantedBy", interactionUserID(i)).Str("user", userId).Str("subcommand", subcommand).Time("expiry", expiry).Msg("Admin grant issued")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// normalizeImageURL reduces an image URL to a canonical form so that trivially different spellings of the same link compare equal
+func normalizeImageURL(imageUrl string) string {
+	imageUrl = strings.TrimSpace(imageUrl)
+	parsed, err := url.Parse(imageUrl)
+	if err != nil {
+		return imageUrl
+	}
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	parsed.Host = strings.ToLower(parsed.Host)
+	parsed.Path = strings.TrimSuffix(parsed.Path, "/")
+	parsed.Fragment = ""
+	return parsed.String()
+}
+
+// mirrorHosts maps hosts that serve the same files as another host to that host, for canonicalImageURL
+var mirrorHosts = map[string]string{
+	"media.discordapp.net": "cdn.discordapp.com",
+}
+
+// canonicalImageURL goes further than normalizeImageURL for loose deduplication, so that variants of a link that fetch the same image compare equal
+// The scheme, a leading "www.", the port, tracking parameters, and doubled slashes are dropped, mirrors and remapped hosts are replaced by the host they stand for, and Discord attachment links lose their whole query (it only signs and resizes).
+func canonicalImageURL(imageUrl string) string {
+	normalized := normalizeImageURL(imageUrl)
+	parsed, err := url.Parse(normalized)
+	if err != nil || len(parsed.Host) == 0 {
+		return normalized
+	}
+	host := strings.TrimPrefix(parsed.Hostname(), "www.")
+	if replacement, ok := hostRemaps[host]; ok {
+		host = replacement
+	}
+	if mirror, ok := mirrorHosts[host]; ok {
+		host = mirror
+	}
+	query := parsed.Query()
+	for name := range query {
+		if host == "cdn.discordapp.com" || matchesParamPattern(name, trackingParams) && !matchesParamPattern(name, trackingParamsKeep) {
+			query.Del(name)
+		}
+	}
+	canonical := host + path.Clean("/"+parsed.Path)
+	if len(query) > 0 {
+		canonical += "?" + query.Encode()
+	}
+	return canonical
+}
+
+// displayImageURL applies hostRemaps to a stored image link so that images from a retired host can still be shown
+// The stored link is left untouched; only what's displayed changes.
+func displayImageURL(imageUrl string) string {
+	if hostRemaps == nil {
+		return imageUrl
+	}
+	parsed, err := url.Parse(imageUrl)
+	if err != nil {
+		return imageUrl
+	}
+	replacement, ok := hostRemaps[strings.ToLower(parsed.Host)]
+	if !ok {
+		return imageUrl
+	}
+	parsed.Host = replacement
+	return parsed.String()
+}
+
+// matchesParamPattern reports whether the query parameter name matches any of patterns, where a trailing "*" matches any suffix
+func matchesParamPattern(name string, patterns []string) bool {
+	name = strings.ToLower(name)
+	for _, v := range patterns {
+		v = strings.ToLower(v)
+		if strings.HasSuffix(v, "*") {
+			if strings.HasPrefix(name, strings.TrimSuffix(v, "*")) {
+				return true
+			}
+		} else if name == v {
+			return true
+		}
+	}
+	return false
+}
+
+// stripTrackingParameters removes the trackingParams (other than trackingParamsKeep) from imageUrl's query string
+// Links that cannot be parsed, or that have nothing to strip, are returned unchanged.
+func stripTrackingParameters(imageUrl string) string {
+	parsed, err := url.Parse(imageUrl)
+	if err != nil || len(parsed.RawQuery) == 0 {
+		return imageUrl
+	}
+	query := parsed.Query()
+	stripped := false
+	for name := range query {
+		if matchesParamPattern(name, trackingParams) && !matchesParamPattern(name, trackingParamsKeep) {
+			query.Del(name)
+			stripped = true
+		}
+	}
+	if !stripped {
+		return imageUrl
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+type imageCheckResult int
+
+const (
+	imageOk imageCheckResult = iota
+	imageBroken
+	imageOversized
+	imageUnchecked // The check could not be completed before its context ended
+)
+
+// checkImage issues a HEAD request for imageUrl to determine whether it still resolves and whether it exceeds auditMaxImageBytes
+func checkImage(checkCtx context.Context, imageUrl string) imageCheckResult {
+	req, err := http.NewRequestWithContext(checkCtx, http.MethodHead, imageUrl, nil)
+	if err != nil {
+		return imageBroken
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		if checkCtx.Err() != nil {
+			return imageUnchecked
+		}
+		return imageBroken
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return imageBroken
+	}
+	if resp.ContentLength > int64(auditMaxImageBytes) {
+		return imageOversized
+	}
+	return imageOk
+}
+
+type galleryAudit struct {
+	Name       string
+	Images     int
+	Broken     int
+	Duplicates int
+	Oversized  int
+	Unchecked  int
+}
+
+// auditAllGalleries checks every image in every gallery for broken links, duplicates, and oversized files
+// Image checks are spread across auditConcurrency workers and abandoned once auditTimeout elapses. Reports covering more galleries than fit in a single embed are attached as a text file instead.
+func auditAllGalleries(session discordSession, i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+
+	docSnaps, err := getAllGalleries()
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Msg("Failed to retrieve galleries for audit")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	auditCtx, cancel := context.WithTimeout(ctx, auditTimeout)
+	defer cancel()
+
+	type auditJob struct {
+		audit    *galleryAudit
+		imageUrl string
+	}
+	var pendingJobs []auditJob
+	audits := make([]galleryAudit, len(docSnaps))
+	totalImages := 0
+	for n, docSnap := range docSnaps {
+		audits[n].Name = docSnap.Ref.ID
+		var gallery Gallery
+		err = docSnap.DataTo(&gallery)
+		if err != nil {
+			log.Error().Err(err).Caller().Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+			continue
+		}
+		audits[n].Images = len(gallery.Images)
+		totalImages += len(gallery.Images)
+		seen := make(map[string]bool)
+		for _, image := range gallery.Images {
+			normalized := normalizeImageURL(image.ImageURL)
+			if seen[normalized] {
+				audits[n].Duplicates++
+				continue // No need to check the same link twice
+			}
+			seen[normalized] = true
+			pendingJobs = append(pendingJobs, auditJob{audit: &audits[n], imageUrl: image.ImageURL})
+		}
+	}
+
+	progress := newProgressReporter(session, i, "Auditing galleries :mag:")
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	checked := 0
+	jobs := make(chan auditJob)
+	for w := 0; w < auditConcurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				result := checkImage(auditCtx, job.imageUrl)
+				mu.Lock()
+				switch result {
+				case imageBroken:
+					job.audit.Broken++
+				case imageOversized:
+					job.audit.Oversized++
+				case imageUnchecked:
+					job.audit.Unchecked++
+				}
+				checked++
+				progressStatus := fmt.Sprintf("Checked %d/%d images...", checked, len(pendingJobs))
+				mu.Unlock()
+				progress.Update(progressStatus)
+			}
+		}()
+	}
+	for _, job := range pendingJobs {
+		jobs <- job
+	}
+	close(jobs)
+	wg.Wait()
+
+	var disabled []string
+	if autoDisableBrokenGalleries {
+		for _, audit := range audits {
+			// Only galleries that were checked in full, where every distinct link is broken
+			if audit.Images == 0 || audit.Unchecked > 0 || audit.Broken < audit.Images-audit.Duplicates {
+				continue
+			}
+			err = disableGallery(audit.Name, audit.Images)
+			if err != nil {
+				log.Error().Err(err).Caller().Str("gallery", audit.Name).Msg("Failed to disable broken gallery")
+				continue
+			}
+			disabled = append(disabled, audit.Name)
+		}
+	}
+
+	var broken, duplicates, oversized, unchecked int
+	var report strings.Builder
+	for _, audit := range audits {
+		broken += audit.Broken
+		duplicates += audit.Duplicates
+		oversized += audit.Oversized
+		unchecked += audit.Unchecked
+		fmt.Fprintf(&report, "%s: %d images, %d broken, %d duplicates, %d oversized", audit.Name, audit.Images, audit.Broken, audit.Duplicates, audit.Oversized)
+		if audit.Unchecked > 0 {
+			fmt.Fprintf(&report, ", %d unchecked", audit.Unchecked)
+		}
+		report.WriteString("\n")
+	}
+
+	description := fmt.Sprintf("Audited %d galleries containing %d images :mag:\nBroken: %d | Duplicates: %d | Oversized: %d", len(audits), totalImages, broken, duplicates, oversized)
+	if unchecked > 0 {
+		description += fmt.Sprintf("\n:warning: Timed out after %s with %d images left unchecked", auditTimeout, unchecked)
+	}
+	if len(disabled) > 0 {
+		description += fmt.Sprintf("\n:no_entry: Disabled %d galleries with no working images: `%s`", len(disabled), strings.Join(disabled, "`, `"))
+	}
+	embed = discordgo.MessageEmbed{
+		Description: description,
+		Color:       0x5865f2,
+	}
+	if len(audits) > 25 { // Discord allows at most 25 fields per embed
+		embed.Description += "\nPer-gallery results are attached."
+		data.Files = []*discordgo.File{
+			{
+				Name:        "gallery-audit.txt",
+				ContentType: "text/plain",
+				Reader:      strings.NewReader(report.String()),
+			},
+		}
+	} else {
+		for _, audit := range audits {
+			embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+				Name:   audit.Name,
+				Value:  fmt.Sprintf("Images: %d\nBroken: %d\nDuplicates: %d\nOversized: %d", audit.Images, audit.Broken, audit.Duplicates, audit.Oversized),
+				Inline: true,
+			})
+		}
+	}
+	log.Debug().Int("galleries", len(audits)).Int("broken", broken).Int("duplicates", duplicates).Int("oversized", oversized).Int("unchecked", unchecked).Msg("Completed audit of all galleries")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// disableGallery sets a gallery's Disabled flag, unless its image count has changed from numberOfImages since it was audited
+func disableGallery(galleryName string, numberOfImages int) error {
+	ctx, done := requestContext("disableGallery")
+	defer done()
+	docRef := getGalleryDocRef(galleryName)
+	return firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(docRef)
+		if err != nil {
+			return err
+		}
+		var gallery Gallery
+		err = docSnap.DataTo(&gallery)
+		if err != nil {
+			return err
+		}
+		if len(gallery.Images) != numberOfImages {
+			return nil // Images were added or removed during the audit, so its verdict may no longer hold
+		}
+		return tx.Update(docRef, []firestore.Update{{Path: "disabled", Value: true}})
+	})
+}
+
+// emptyGalleryEmbed is what random, pick, and carousel show for a gallery with no images: its fallback image if it has one, and the usual refusal otherwise
+func emptyGalleryEmbed(galleryName string, gallery Gallery) discordgo.MessageEmbed {
+	if len(gallery.FallbackImageURL) == 0 {
+		return discordgo.MessageEmbed{
+			Description: "Gallery is empty :stop_sign:",
+			Color:       0xf04747,
+		}
+	}
+	return discordgo.MessageEmbed{
+		Description: fmt.Sprintf("Gallery `%s` doesn't have any images yet, so here's a placeholder :frame_photo:", galleryName),
+		Color:       0x5865f2,
+		Image: &discordgo.MessageEmbedImage{
+			URL: displayImageURL(gallery.FallbackImageURL),
+		},
+	}
+}
+
+// setFallbackImage sets or clears the image a gallery shows while it's empty
+func setFallbackImage(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("fallback")
+	defer done()
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+	imageUrl := ""
+	if option := findOption(command.Options, "image_url"); option != nil {
+		imageUrl = strings.TrimSpace(option.StringValue())
+		if stripTrackingParams {
+			imageUrl = stripTrackingParameters(imageUrl)
+		}
+		if err := validateImageURL(imageUrl); err != nil {
+			embed = discordgo.MessageEmbed{
+				Description: fmt.Sprintf("That isn't a usable image link :stop_sign: (%s)", err),
+				Color:       0xf04747,
+			}
+			data.Embeds = []*discordgo.MessageEmbed{&embed}
+			return data
+		}
+	}
+
+	docRef := getGalleryDocRef(galleryName)
+	_, err := docRef.Update(ctx, []firestore.Update{{Path: "fallbackImageUrl", Value: imageUrl}})
+	if status.Code(err) == codes.NotFound {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docRef", docRef).Msg("Failed to write document contents")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to modify gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	if len(imageUrl) == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Gallery `%s` no longer has a placeholder for when it's empty :white_check_mark:", galleryName),
+			Color:       0x43b581,
+		}
+	} else {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Gallery `%s` will show this while it's empty :white_check_mark:", galleryName),
+			Color:       0x43b581,
+			Image: &discordgo.MessageEmbedImage{
+				URL: displayImageURL(imageUrl),
+			},
+		}
+	}
+	log.Debug().Str("fallbackImageUrl", imageUrl).Str("gallery", galleryName).Msg("Changed fallback image of gallery")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// galleryDisabledEmbed is the refusal shown when anything would show an image from a gallery that audit_all disabled
+func galleryDisabledEmbed(galleryName string) *discordgo.MessageEmbed {
+	return &discordgo.MessageEmbed{
+		Description: fmt.Sprintf("Gallery `%s` is disabled because none of its images load :stop_sign:\nAdd a working image to re-enable it.", galleryName),
+		Color:       0xf04747,
+	}
+}
+
+// progressReporter keeps the user informed while a deferred command works, by editing its pending response
+// Updates are throttled to one per progressUpdateInterval so that busy loops can report freely without hitting rate limits. It is safe for concurrent use.
+type progressReporter struct {
+	session     discordSession
+	i           *discordgo.Interaction
+	title       string
+	lastUpdate  time.Time
+	updateMutex sync.Mutex
+}
+
+func newProgressReporter(session discordSession, i *discordgo.Interaction, title string) *progressReporter {
+	return &progressReporter{
+		session: session,
+		i:       i,
+		title:   title,
+	}
+}
+
+// Update shows status beneath the reporter's title, unless an update was already shown within progressUpdateInterval
+func (p *progressReporter) Update(status string) {
+	p.updateMutex.Lock()
+	if time.Since(p.lastUpdate) < progressUpdateInterval {
+		p.updateMutex.Unlock()
+		return
+	}
+	p.lastUpdate = time.Now()
+	p.updateMutex.Unlock()
+
+	embed := discordgo.MessageEmbed{
+		Description: fmt.Sprintf("%s\n%s", p.title, status),
+		Color:       0x5865f2,
+	}
+	_, err := p.session.InteractionResponseEdit(applicationID(p.session), p.i, &discordgo.WebhookEdit{
+		Embeds: []*discordgo.MessageEmbed{&embed},
+	})
+	if err != nil {
+		log.Warn().Err(err).Interface("interaction", p.i).Msg("Failed to report progress")
+	}
+}
+
+// markAcknowledged records that i has been responded to, reporting whether it already had been
+// Records older than interactionTokenLifetime are dropped along the way, since nothing more can be sent for those interactions anyway.
+func markAcknowledged(i *discordgo.Interaction) bool {
+	acknowledgedInteractionsMutex.Lock()
+	defer acknowledgedInteractionsMutex.Unlock()
+	for id, at := range acknowledgedInteractions {
+		if time.Since(at) > interactionTokenLifetime {
+			delete(acknowledgedInteractions, id)
+		}
+	}
+	_, acknowledged := acknowledgedInteractions[i.ID]
+	if !acknowledged {
+		acknowledgedInteractions[i.ID] = time.Now()
+	}
+	return acknowledged
+}
+
+// errorEntry is one error-level log event kept by errorRing
+type errorEntry struct {
+	Time    time.Time
+	Message string
+	Error   string
+	Command string // The command behind the interaction the event was about, if it named one
+	Guild   string
+}
+
+// errorRing is a zerolog.LevelWriter that keeps the last recentErrorsSize error-level (and worse) events, dropping everything else
+// It reads the events back out of the JSON the logger writes, so every existing log.Error() feeds it without changes.
+type errorRing struct {
+	mu      sync.Mutex
+	entries []errorEntry // Oldest first
+}
+
+func (r *errorRing) Write(p []byte) (int, error) {
+	return len(p), nil // Without a level, there's no telling whether it's an error
+}
+
+func (r *errorRing) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	if level < zerolog.ErrorLevel || level == zerolog.NoLevel {
+		return len(p), nil
+	}
+	var event struct {
+		Time        float64 `json:"time"`
+		Message     string  `json:"message"`
+		Error       string  `json:"error"`
+		Interaction *struct {
+			ID      string `json:"id"`
+			GuildID string `json:"guild_id"`
+		} `json:"interaction"`
+	}
+	if err := json.Unmarshal(p, &event); err != nil {
+		return len(p), nil // Never let the buffer get in the way of logging
+	}
+	entry := errorEntry{
+		Time:    time.Unix(int64(event.Time), 0),
+		Message: event.Message,
+		Error:   event.Error,
+	}
+	if event.Interaction != nil {
+		entry.Guild = event.Interaction.GuildID
+		interactionCommandsMutex.Lock()
+		entry.Command = interactionCommands[event.Interaction.ID].Name
+		interactionCommandsMutex.Unlock()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, entry)
+	if size := recentErrorsSize; size >= 0 && len(r.entries) > size {
+		r.entries = append([]errorEntry(nil), r.entries[len(r.entries)-size:]...)
+	}
+	return len(p), nil
+}
+
+// Entries returns a copy of the kept events, newest first
+func (r *errorRing) Entries() []errorEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entries := make([]errorEntry, len(r.entries))
+	for n, v := range r.entries {
+		entries[len(entries)-1-n] = v
+	}
+	return entries
+}
+
+// interactionCommand records what an interaction was invoking
+type interactionCommand struct {
+	Name string
+	At   time.Time
+}
+
+// noteInteractionCommand remembers what i is invoking (like "gallery random", or a component's handler), for errorRing to attach to errors logged about it
+// Records older than interactionTokenLifetime are dropped along the way, since errors about those interactions are unlikely.
+func noteInteractionCommand(i *discordgo.Interaction) {
+	var name string
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		name = i.ApplicationCommandData().Name
+		if options := i.ApplicationCommandData().Options; len(options) > 0 && options[0].Type == discordgo.ApplicationCommandOptionSubCommand {
+			name += " " + options[0].Name
+		}
+	case discordgo.InteractionMessageComponent:
+		name = strings.SplitN(i.MessageComponentData().CustomID, ":", 2)[0] + " (button)"
+	default:
+		return
+	}
+	interactionCommandsMutex.Lock()
+	defer interactionCommandsMutex.Unlock()
+	for id, v := range interactionCommands {
+		if time.Since(v.At) > interactionTokenLifetime {
+			delete(interactionCommands, id)
+		}
+	}
+	interactionCommands[i.ID] = interactionCommand{Name: name, At: time.Now()}
+}
+
+// checkResponseFlow confirms that deferred responses can be edited, by being run the way the long-running commands are
+// It also reports how long the interaction took to arrive, so operators can tell a slow connection to Discord from a slow command.
+func checkResponseFlow(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	embed := discordgo.MessageEmbed{
+		Description: "Deferred responses are working :white_check_mark:",
+		Color:       0x43b581,
+	}
+	created, err := discordgo.SnowflakeTimestamp(i.ID)
+	if err == nil && !created.After(time.Now()) {
+		embed.Fields = []*discordgo.MessageEmbedField{
+			{
+				Name:   "Arrived after",
+				Value:  time.Since(created).Round(time.Millisecond).String(),
+				Inline: true,
+			},
+		}
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// showRecentErrors lists the latest error-level log events, so operators can see what went wrong without access to the logs
+func showRecentErrors(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	data.Flags = messageFlagsEphemeral
+
+	entries := recentErrors.Entries()
+	if len(entries) == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: "No errors since the bot started :white_check_mark:",
+			Color:       0x43b581,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	var description strings.Builder
+	fmt.Fprintf(&description, "The %d most recent errors, newest first :rotating_light:\n", len(entries))
+	for _, v := range entries {
+		fmt.Fprintf(&description, "\n<t:%d:R> %s", v.Time.Unix(), v.Message)
+		if len(v.Error) > 0 {
+			fmt.Fprintf(&description, ": `%s`", strings.ReplaceAll(v.Error, "`", "'"))
+		}
+		var details []string
+		if len(v.Command) > 0 {
+			details = append(details, "/"+v.Command)
+		}
+		if len(v.Guild) > 0 && v.Guild != config["guildId"] {
+			details = append(details, "guild "+v.Guild)
+		}
+		if len(details) > 0 {
+			fmt.Fprintf(&description, " (%s)", strings.Join(details, ", "))
+		}
+	}
+	embed = discordgo.MessageEmbed{
+		Description: description.String(),
+		Color:       0xf04747,
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// discordSession is the part of *discordgo.Session that the interaction handlers use, so that they can be driven without a live Discord connection
+type discordSession interface {
+	InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse) error
+	InteractionResponse(appID string, interaction *discordgo.Interaction) (*discordgo.Message, error)
+	InteractionResponseEdit(appID string, interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit) (*discordgo.Message, error)
+	InteractionResponseDelete(appID string, interaction *discordgo.Interaction) error
+	FollowupMessageCreate(appID string, interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams) (*discordgo.Message, error)
+	ChannelMessageEditComplex(m *discordgo.MessageEdit) (*discordgo.Message, error)
+	MessageReactionAdd(channelID, messageID, emojiID string) error
+	MessageReactions(channelID, messageID, emojiID string, limit int, beforeID, afterID string) ([]*discordgo.User, error)
+	MessageReactionsRemoveAll(channelID, messageID string) error
+}
+
+// applicationID returns the ID of the bot that session is connected as, which doubles as its application ID, or "" before it is ready
+// Stand-ins for a session have no connection to take the ID from, and Discord ignores it when the interaction token is valid.
+func applicationID(session discordSession) string {
+	connected, ok := session.(*discordgo.Session)
+	if !ok || connected.State == nil || connected.State.User == nil {
+		return ""
+	}
+	return connected.State.User.ID
+}
+
+// respond sends data as the response to i, or as a followup message if i has already been responded to, so that handlers producing more than one output can't fail on the second
+// Deferring an interaction that has already been responded to does nothing.
+func respond(r discordSession, i *discordgo.Interaction, responseType discordgo.InteractionResponseType, data *discordgo.InteractionResponseData) error {
+	if data != nil {
+		fitEmbedLimits(data.Embeds)
+	}
+	if !markAcknowledged(i) {
+		err := r.InteractionRespond(i, &discordgo.InteractionResponse{
+			Type: responseType,
+			Data: data,
+		})
+		var restErr *discordgo.RESTError
+		if !errors.As(err, &restErr) || restErr.Message == nil || restErr.Message.Code != discordErrorInteractionAcknowledged {
+			if err != nil {
+				acknowledgedInteractionsMutex.Lock()
+				delete(acknowledgedInteractions, i.ID) // Nothing was sent, so a retry should respond normally
+				acknowledgedInteractionsMutex.Unlock()
+			}
+			return err
+		}
+		log.Warn().Interface("interaction", i).Msg("Interaction was already acknowledged elsewhere, sending a followup instead")
+	}
+	if data == nil || responseType == discordgo.InteractionResponseDeferredChannelMessageWithSource || responseType == discordgo.InteractionResponseDeferredMessageUpdate {
+		return nil
+	}
+
+	_, err := r.FollowupMessageCreate(applicationID(r), i, true, &discordgo.WebhookParams{
+		Content:         data.Content,
+		Components:      data.Components,
+		Embeds:          data.Embeds,
+		AllowedMentions: data.AllowedMentions,
+		Files:           data.Files,
+		Flags:           data.Flags,
+	})
+	return err
+}
+
+// respondDeferred acknowledges i immediately and edits in the response produced by work once it returns, for operations that may outlast Discord's response deadline
+// Discord fixes a response's visibility when it's deferred, so an ephemeral result is sent as a private followup in place of the deferred response.
+func respondDeferred(r discordSession, i *discordgo.Interaction, work func(i *discordgo.Interaction) discordgo.InteractionResponseData) {
+	err := respond(r, i, discordgo.InteractionResponseDeferredChannelMessageWithSource, nil)
+	if err != nil {
+		log.Error().Err(err).Interface("interaction", i).Msg("Failure in deferring response to interaction")
+		return
+	}
+
+	data := work(i)
+	fitEmbedLimits(data.Embeds)
+	if data.Flags&messageFlagsEphemeral != 0 {
+		// The deferral already made the response visible to everyone, so a private result replaces it with a followup only the user can see
+		err = r.InteractionResponseDelete(applicationID(r), i)
+		if err == nil {
+			_, err = r.FollowupMessageCreate(applicationID(r), i, true, &discordgo.WebhookParams{
+				Content:    data.Content,
+				Embeds:     data.Embeds,
+				Components: data.Components,
+				Files:      data.Files,
+				Flags:      data.Flags,
+			})
+			if err != nil {
+				log.Error().Err(err).Interface("interaction", i).Msg("Failure in sending private followup to deferred interaction")
+			}
+			return
+		}
+		log.Warn().Err(err).Interface("interaction", i).Msg("Failed to delete deferred response, so editing the private result into it instead")
+	}
+	_, err = r.InteractionResponseEdit(applicationID(r), i, &discordgo.WebhookEdit{
+		Content:    data.Content,
+		Embeds:     data.Embeds,
+		Components: data.Components,
+		Files:      data.Files,
+	})
+	if err != nil {
+		log.Error().Err(err).Interface("interaction", i).Msg("Failure in editing deferred response to interaction")
+	}
+}
+
+func setScheduleExclusion(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("schedule_exclude")
+	defer done()
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+	excluded := command.Options[1].BoolValue()
+
+	docRef := getGalleryDocRef(galleryName)
+	_, err := docRef.Update(ctx, []firestore.Update{{Path: "excludeFromSchedule", Value: excluded}})
+	if status.Code(err) == codes.NotFound {
+		log.Warn().Interface("interaction", i).Msg("Attempted to change schedule exclusion of non-existent gallery")
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docRef", docRef).Msg("Failed to write document contents")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to modify gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	if excluded {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Gallery `%s` will be skipped by scheduled posts :white_check_mark:", galleryName),
+			Color:       0x43b581,
+		}
+	} else {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Gallery `%s` is back in the scheduled post rotation :white_check_mark:", galleryName),
+			Color:       0x43b581,
+		}
+	}
+	log.Debug().Bool("excluded", excluded).Str("gallery", galleryName).Msg("Changed schedule exclusion of gallery")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// setAllowedFormats restricts the chosen gallery to the listed image formats, or lifts the restriction if none are listed
+func setAllowedFormats(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("formats")
+	defer done()
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+	var formats []string
+	if option := findOption(command.Options, "formats"); option != nil {
+		for _, v := range parseTags(option.StringValue()) {
+			format, ok := imageFormats[v]
+			if !ok {
+				embed = discordgo.MessageEmbed{
+					Description: fmt.Sprintf("Unknown image format `%s` :stop_sign: (Choose from png, jpg, gif, and webp.)", v),
+					Color:       0xf04747,
+				}
+				data.Embeds = []*discordgo.MessageEmbed{&embed}
+				return data
+			}
+			if len(formats) > 0 && formatAllowed(Gallery{AllowedFormats: formats}, format) {
+				continue // Already listed, e.g. as both jpg and jpeg
+			}
+			formats = append(formats, format)
+		}
+	}
+
+	docRef := getGalleryDocRef(galleryName)
+	_, err := docRef.Update(ctx, []firestore.Update{{Path: "allowedFormats", Value: formats}})
+	if status.Code(err) == codes.NotFound {
+		log.Warn().Interface("interaction", i).Msg("Attempted to change allowed formats of non-existent gallery")
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docRef", docRef).Msg("Failed to write document contents")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to modify gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	if len(formats) > 0 {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Gallery `%s` now only accepts %s images :white_check_mark:", galleryName, strings.Join(formats, ", ")),
+			Color:       0x43b581,
+		}
+	} else {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Gallery `%s` now accepts images of any format :white_check_mark:", galleryName),
+			Color:       0x43b581,
+		}
+	}
+	log.Debug().Strs("formats", formats).Str("gallery", galleryName).Msg("Changed allowed formats of gallery")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// setupStarterGalleries creates whichever of starterGalleries don't exist yet, all in a single batch
+func setupStarterGalleries(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("setup")
+	defer done()
+
+	var docRefs []*firestore.DocumentRef
+	for _, v := range starterGalleries {
+		if invalidGalleryNameEmbed(v) != nil {
+			log.Warn().Str("gallery", v).Msg("Skipping starter gallery with an invalid name")
+			continue
+		}
+		docRefs = append(docRefs, getGalleryDocRef(v))
+	}
+	docSnaps, err := firestoreClient.GetAll(ctx, docRefs)
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Msg("Failed to check for existing starter galleries")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	var created, skipped []string
+	batch := firestoreClient.Batch()
+	for _, docSnap := range docSnaps {
+		if docSnap.Exists() {
+			skipped = append(skipped, fmt.Sprintf("`%s`", docSnap.Ref.ID))
+			continue
+		}
+		batch.Create(docSnap.Ref, Gallery{CreatedAt: time.Now()})
+		created = append(created, docSnap.Ref.ID)
+	}
+	if len(created) > 0 {
+		_, err = batch.Commit(ctx)
+		if err != nil {
+			log.Error().Err(err).Caller().Interface("interaction", i).Strs("galleries", created).Msg("Failed to create starter galleries")
+			embed = discordgo.MessageEmbed{
+				Description: "Unable to create gallery :stop_sign:",
+				Color:       0xf04747,
+			}
+			data.Embeds = []*discordgo.MessageEmbed{&embed}
+			return data
+		}
+		for _, v := range created {
+			noteGalleryCreated(v)
+			recordAuditEvent(v, AuditEvent{
+				Action:  auditActionCreate,
+				ActorID: interactionUserID(i),
+			})
+		}
+	}
+
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("Created %d starter galleries :white_check_mark:", len(created)),
+		Color:       0x43b581,
+	}
+	if len(created) > 0 {
+		quoted := make([]string, len(created))
+		for n, v := range created {
+			quoted[n] = fmt.Sprintf("`%s`", v)
+		}
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Created",
+			Value: strings.Join(quoted, ", "),
+		})
+	}
+	if len(skipped) > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Already existed",
+			Value: strings.Join(skipped, ", "),
+		})
+	}
+	log.Debug().Strs("created", created).Msg("Set up starter galleries")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// toggleCommand enables or disables a subcommand for the guild, then re-registers the commands to match
+func toggleCommand(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("toggle_command")
+	defer done()
+
+	command := i.ApplicationCommandData().Options[0]
+	subcommand := strings.ToLower(strings.TrimSpace(command.Options[0].StringValue()))
+	enabled := command.Options[1].BoolValue()
+
+	var optional []string
+	for _, v := range commands {
+		for _, option := range v.Options {
+			if option.Type == discordgo.ApplicationCommandOptionSubCommand && !coreSubcommands[option.Name] {
+				optional = append(optional, option.Name)
+			}
+		}
+	}
+	known := false
+	for _, v := range optional {
+		known = known || v == subcommand
+	}
+	if !known {
+		description := fmt.Sprintf("There is no `%s` subcommand that can be toggled :stop_sign:", subcommand)
+		if coreSubcommands[subcommand] {
+			description = fmt.Sprintf("The `%s` subcommand is always available :stop_sign:", subcommand)
+		}
+		embed = discordgo.MessageEmbed{
+			Description: description,
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	var settings GuildSettings
+	docRef := getGuildSettingsDocRef()
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		settings = GuildSettings{}
+		docSnap, err := tx.Get(docRef)
+		if err == nil {
+			err = docSnap.DataTo(&settings)
+		}
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		current := make(map[string]bool)
+		for _, v := range settings.EnabledCommands {
+			current[v] = true
+		}
+		settings.EnabledCommands = nil
+		for _, v := range optional {
+			// No list means everything is enabled
+			if v == subcommand && enabled || v != subcommand && (len(current) == 0 || current[v]) {
+				settings.EnabledCommands = append(settings.EnabledCommands, v)
+			}
+		}
+		if len(settings.EnabledCommands) == len(optional) {
+			settings.EnabledCommands = nil // Everything is enabled, so newly added subcommands should be too
+		}
+		return tx.Set(docRef, settings)
+	})
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Msg("Failed to write guild settings")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to change the server's settings :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	applyGuildSettings(settings)
+
+	if enabled {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("The `%s` subcommand is now enabled on this server :white_check_mark:", subcommand),
+			Color:       0x43b581,
+		}
+		if !featureEnabled(subcommand) {
+			embed.Description += "\n:warning: It is still left out by the bot's features setting."
+		}
+	} else {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("The `%s` subcommand is now disabled on this server :white_check_mark:", subcommand),
+			Color:       0x43b581,
+		}
+	}
+	log.Debug().Str("subcommand", subcommand).Bool("enabled", enabled).Msg("Toggled subcommand")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	warnIfCommandsStale(&data, updateCommands())
+	return data
+}
+
+// showGuildSettings renders the guild's settings document, checking every gallery, role, and subcommand it names still exists and suggesting a fix for any that doesn't
+func showGuildSettings(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("settings")
+	defer done()
+	data.Flags = messageFlagsEphemeral
+
+	var settings GuildSettings
+	docSnap, err := getGuildSettingsDocRef().Get(ctx)
+	if err == nil {
+		err = docSnap.DataTo(&settings)
+	}
+	if err != nil && status.Code(err) != codes.NotFound {
+		log.Error().Err(err).Caller().Interface("interaction", i).Msg("Failed to load guild settings")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get the server's settings :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	var description strings.Builder
+	broken := 0
+	flag := func(problem string, fix string) {
+		broken++
+		fmt.Fprintf(&description, ":warning: %s\n:wrench: %s\n", problem, fix)
+	}
+
+	description.WriteString("**Enabled subcommands**\n")
+	defined := make(map[string]bool)
+	for _, command := range commands {
+		for _, option := range command.Options {
+			if option.Type == discordgo.ApplicationCommandOptionSubCommand {
+				defined[option.Name] = true
+			}
+		}
+	}
+	if len(settings.EnabledCommands) == 0 {
+		description.WriteString("(all)\n")
+	} else {
+		description.WriteString(strings.Join(settings.EnabledCommands, ", ") + "\n")
+		var stale []string
+		valid := ""
+		for _, v := range settings.EnabledCommands {
+			if !defined[v] {
+				stale = append(stale, v)
+			} else if len(valid) == 0 {
+				valid = v
+			}
+		}
+		if len(stale) > 0 {
+			// Any toggle rewrites the list from the defined subcommands, dropping the stale ones
+			fix := "Toggle any subcommand with `/gallery_admin toggle_command` to drop them"
+			if len(valid) > 0 {
+				fix = fmt.Sprintf("`/gallery_admin toggle_command subcommand:%s enabled:True` drops them", valid)
+			}
+			flag(fmt.Sprintf("`%s` are no longer subcommands", strings.Join(stale, "`, `")), fix)
+		}
+	}
+
+	fmt.Fprintf(&description, "\n**Paused on startup**\n%v\n", settings.Paused)
+
+	description.WriteString("\n**Tagging rules**\n")
+	if len(settings.TagRules) == 0 {
+		description.WriteString("(none)\n")
+	}
+	for _, rule := range settings.TagRules {
+		fmt.Fprintf(&description, "`%s` :arrow_right: `%s`\n", rule.Pattern, rule.Tag)
+	}
+
+	description.WriteString("\n**Categories**\n")
+	if len(settings.Categories) == 0 {
+		description.WriteString("(none)\n")
+	}
+	var categories []string
+	for category := range settings.Categories {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		galleryNames := settings.Categories[category]
+		fmt.Fprintf(&description, "`%s`: %s\n", category, strings.Join(galleryNames, ", "))
+		var docRefs []*firestore.DocumentRef
+		var remaining []string
+		for _, name := range galleryNames {
+			if docRef := getGalleryDocRef(name); docRef != nil {
+				docRefs = append(docRefs, docRef)
+			}
+		}
+		docSnaps, err := firestoreClient.GetAll(ctx, docRefs)
+		if err != nil {
+			log.Error().Err(err).Caller().Str("category", category).Msg("Failed to retrieve document contents")
+			fmt.Fprintf(&description, ":grey_question: Couldn't check whether the galleries in `%s` exist\n", category)
+			continue
+		}
+		for _, docSnap := range docSnaps {
+			if docSnap.Exists() {
+				remaining = append(remaining, docSnap.Ref.ID)
+			}
+		}
+		if len(remaining) == len(galleryNames) {
+			continue
+		}
+		if len(remaining) == 0 {
+			flag(fmt.Sprintf("None of the galleries in `%s` exist any more", category), fmt.Sprintf("`/gallery_ops category name:%s`", category))
+		} else {
+			flag(fmt.Sprintf("Some galleries in `%s` no longer exist", category), fmt.Sprintf("`/gallery_ops category name:%s galleries:%s`", category, strings.Join(remaining, ",")))
+		}
+	}
+
+	embed = discordgo.MessageEmbed{
+		Description: description.String(),
+		Color:       0x43b581,
+	}
+	if broken > 0 {
+		embed.Color = 0xfaa61a
+		embed.Footer = &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("%d broken setting(s) found", broken),
+		}
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// setGuildConfig changes the overrides of the guild it's run in (see GuildConfig), or just shows them when given no options
+// Picking the admin role or audit channel that's already set removes it again.
+func setGuildConfig(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("guild_config")
+	defer done()
+
+	command := i.ApplicationCommandData().Options[0]
+	maxImages := -1
+	if option := findOption(command.Options, "max_images"); option != nil {
+		maxImages = int(option.IntValue())
+		if maxImages < 0 {
+			embed = discordgo.MessageEmbed{
+				Description: "The image limit can't be negative :stop_sign: (Use 0 for no limit.)",
+				Color:       0xf04747,
+			}
+			data.Embeds = []*discordgo.MessageEmbed{&embed}
+			return data
+		}
+	}
+	roleId := ""
+	if option := findOption(command.Options, "admin_role"); option != nil {
+		roleId = option.RoleValue(nil, i.GuildID).ID
+	}
+	managerRoleId := ""
+	if option := findOption(command.Options, "manager_role"); option != nil {
+		managerRoleId = option.RoleValue(nil, i.GuildID).ID
+	}
+	channelId := ""
+	if option := findOption(command.Options, "audit_channel"); option != nil {
+		channelId = option.ChannelValue(nil).ID
+		if channelId != lookupGuildConfig(i.GuildID).AuditLogChannelID {
+			if problem := checkPostPermissions(i, channelId); problem != nil {
+				data.Embeds = []*discordgo.MessageEmbed{problem}
+				return data
+			}
+		}
+	}
+
+	var guildConfig GuildConfig
+	docRef := getGuildConfigDocRef(i.GuildID)
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		guildConfig = GuildConfig{}
+		docSnap, err := tx.Get(docRef)
+		if err == nil {
+			err = docSnap.DataTo(&guildConfig)
+		}
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if maxImages < 0 && len(roleId) == 0 && len(managerRoleId) == 0 && len(channelId) == 0 {
+			return nil // Only showing them
+		}
+		if maxImages >= 0 {
+			guildConfig.MaxImagesPerGallery = maxImages
+		}
+		toggle := func(roles []string, roleId string) (toggled []string) {
+			for _, v := range roles {
+				if v != roleId {
+					toggled = append(toggled, v)
+				}
+			}
+			if len(toggled) == len(roles) {
+				toggled = append(toggled, roleId)
+			}
+			return toggled
+		}
+		if len(roleId) > 0 {
+			guildConfig.AdminRoleIDs = toggle(guildConfig.AdminRoleIDs, roleId)
+		}
+		if len(managerRoleId) > 0 {
+			guildConfig.ManagerRoleIDs = toggle(guildConfig.ManagerRoleIDs, managerRoleId)
+		}
+		if len(channelId) > 0 {
+			if guildConfig.AuditLogChannelID == channelId {
+				guildConfig.AuditLogChannelID = ""
+			} else {
+				guildConfig.AuditLogChannelID = channelId
+			}
+		}
+		return tx.Set(docRef, guildConfig)
+	})
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Msg("Failed to write guild config")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to change the server's configuration :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	guildConfigsMutex.Lock()
+	guildConfigs[i.GuildID] = cachedGuildConfig{config: guildConfig, expires: time.Now().Add(guildConfigCacheTTL)}
+	guildConfigsMutex.Unlock()
+
+	limit := "none"
+	if guildConfig.MaxImagesPerGallery > 0 {
+		limit = fmt.Sprint(guildConfig.MaxImagesPerGallery)
+	}
+	mentionRoles := func(roleIds []string) string {
+		var mentions []string
+		for _, v := range roleIds {
+			mentions = append(mentions, fmt.Sprintf("<@&%s>", v))
+		}
+		return strings.Join(mentions, ", ")
+	}
+	roles := "(none beyond the Administrator permission)"
+	if len(guildConfig.AdminRoleIDs) > 0 {
+		roles = mentionRoles(guildConfig.AdminRoleIDs)
+	}
+	managerRoles := "(anyone)"
+	if len(guildConfig.ManagerRoleIDs) > 0 {
+		managerRoles = mentionRoles(guildConfig.ManagerRoleIDs)
+	} else if len(managerRoleIds) > 0 {
+		managerRoles = mentionRoles(managerRoleIds) + " (the bot's default)"
+	}
+	auditChannel := "(none)"
+	if len(guildConfig.AuditLogChannelID) > 0 {
+		auditChannel = fmt.Sprintf("<#%s>", guildConfig.AuditLogChannelID)
+	}
+	embed = discordgo.MessageEmbed{
+		Description: "This server's configuration :gear:",
+		Color:       0x5865f2,
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   "Image limit per gallery",
+				Value:  limit,
+				Inline: true,
+			},
+			{
+				Name:   "Audit log channel",
+				Value:  auditChannel,
+				Inline: true,
+			},
+			{
+				Name:  "Admin roles",
+				Value: roles,
+			},
+			{
+				Name:  "Manager roles (for create, delete, remove_image, and rename)",
+				Value: managerRoles,
+			},
+		},
+	}
+	log.Debug().Str("guild", i.GuildID).Interface("guildConfig", guildConfig).Msg("Showed or changed guild config")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// setCategory defines a category as a list of galleries, or removes it when no galleries are given
+// Every gallery named must exist when the category is set up; ones deleted later are skipped when drawing from it.
+func setCategory(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("category")
+	defer done()
+
+	command := i.ApplicationCommandData().Options[0]
+	category := strings.ToLower(strings.TrimSpace(command.Options[0].StringValue()))
+	var galleryNames []string
+	if option := findOption(command.Options, "galleries"); option != nil {
+		seen := make(map[string]bool)
+		for _, v := range strings.Split(option.StringValue(), ",") {
+			name := strings.TrimSpace(v)
+			if len(name) > 0 && !seen[name] {
+				seen[name] = true
+				galleryNames = append(galleryNames, name)
+			}
+		}
+	}
+	if len(category) == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: "The category name can't be empty :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	var missing []string
+	for _, name := range galleryNames {
+		docRef := getGalleryDocRef(name)
+		if docRef == nil {
+			missing = append(missing, name)
+			continue
+		}
+		if _, err := docRef.Get(ctx); status.Code(err) == codes.NotFound {
+			missing = append(missing, name)
+		} else if err != nil {
+			log.Error().Err(err).Caller().Interface("interaction", i).Str("gallery", name).Msg("Failed to retrieve document contents")
+			embed = discordgo.MessageEmbed{
+				Description: "Unable to get gallery contents :stop_sign:",
+				Color:       0xf04747,
+			}
+			data.Embeds = []*discordgo.MessageEmbed{&embed}
+			return data
+		}
+	}
+	if len(missing) > 0 {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("These galleries don't exist :stop_sign: `%s`", strings.Join(missing, "`, `")),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	var settings GuildSettings
+	existed := false
+	docRef := getGuildSettingsDocRef()
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		settings = GuildSettings{}
+		docSnap, err := tx.Get(docRef)
+		if err == nil {
+			err = docSnap.DataTo(&settings)
+		}
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		_, existed = settings.Categories[category]
+		if settings.Categories == nil {
+			settings.Categories = make(map[string][]string)
+		}
+		if len(galleryNames) == 0 {
+			delete(settings.Categories, category)
+		} else {
+			settings.Categories[category] = galleryNames
+		}
+		return tx.Set(docRef, settings)
+	})
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Msg("Failed to write guild settings")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to change the server's settings :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	applyGuildSettings(settings)
+
+	switch {
+	case len(galleryNames) > 0:
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Category `%s` now pools `%s` :white_check_mark:", category, strings.Join(galleryNames, "`, `")),
+			Color:       0x43b581,
+		}
+	case existed:
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Removed category `%s` :white_check_mark:", category),
+			Color:       0x43b581,
+		}
+	default:
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("There is no `%s` category :stop_sign:", category),
+			Color:       0xf04747,
+		}
+	}
+	log.Debug().Str("category", category).Strs("galleries", galleryNames).Msg("Changed category")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// setTagRule adds, replaces, or (when no tag is given) removes the guild's tagging rule for a pattern
+func setTagRule(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("tag_rule")
+	defer done()
+	const maxTagRules = 50
+
+	command := i.ApplicationCommandData().Options[0]
+	pattern := strings.ToLower(strings.TrimSpace(command.Options[0].StringValue()))
+	tag := ""
+	if option := findOption(command.Options, "tag"); option != nil {
+		if tags := parseTags(option.StringValue()); len(tags) == 1 {
+			tag = tags[0]
+		} else {
+			embed = discordgo.MessageEmbed{
+				Description: "A rule adds exactly one tag :stop_sign: (Add another rule for the same pattern to add more.)",
+				Color:       0xf04747,
+			}
+			data.Embeds = []*discordgo.MessageEmbed{&embed}
+			return data
+		}
+	}
+	if len(pattern) == 0 || pattern == "*." {
+		embed = discordgo.MessageEmbed{
+			Description: "The pattern can't be empty :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	var settings GuildSettings
+	removed := 0
+	docRef := getGuildSettingsDocRef()
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		settings = GuildSettings{}
+		removed = 0
+		docSnap, err := tx.Get(docRef)
+		if err == nil {
+			err = docSnap.DataTo(&settings)
+		}
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		var rules []TagRule
+		for _, rule := range settings.TagRules {
+			if rule.Pattern == pattern && (len(tag) == 0 || rule.Tag == tag) {
+				removed++
+				continue
+			}
+			rules = append(rules, rule)
+		}
+		if len(tag) > 0 {
+			if len(rules) >= maxTagRules {
+				return errTooManyTagRules
+			}
+			rules = append(rules, TagRule{Pattern: pattern, Tag: tag})
+		}
+		settings.TagRules = rules
+		return tx.Set(docRef, settings)
+	})
+	if errors.Is(err, errTooManyTagRules) {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("There can be at most %d tagging rules :stop_sign: Remove one first.", maxTagRules),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Msg("Failed to write guild settings")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to change the server's settings :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	applyGuildSettings(settings)
+
+	switch {
+	case len(tag) > 0:
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Images matching `%s` will be tagged `%s` when they're added :white_check_mark:", pattern, tag),
+			Color:       0x43b581,
+		}
+	case removed > 0:
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Removed the tagging rules for `%s` :white_check_mark:", pattern),
+			Color:       0x43b581,
+		}
+	default:
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("There are no tagging rules for `%s` :stop_sign:", pattern),
+			Color:       0xf04747,
+		}
+	}
+	log.Debug().Str("pattern", pattern).Str("tag", tag).Int("removed", removed).Msg("Changed tagging rules")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// listTagRules shows the guild's tagging rules in the order they're applied
+func listTagRules(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+
+	guildTagRulesMutex.RLock()
+	rules := guildTagRules
+	guildTagRulesMutex.RUnlock()
+	if len(rules) == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: "There are no tagging rules :label:",
+			Color:       0x5865f2,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	var description strings.Builder
+	description.WriteString("Images added with a matching link get these tags :label:\n")
+	for _, rule := range rules {
+		fmt.Fprintf(&description, "\n`%s` :arrow_right: `%s`", rule.Pattern, rule.Tag)
+	}
+	embed = discordgo.MessageEmbed{
+		Description: description.String(),
+		Color:       0x5865f2,
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// errTooManyTagRules aborts a transaction that would add a tagging rule past the limit
+var errTooManyTagRules = errors.New("too many tagging rules")
+
+// setGalleryMirror starts or stops posting a gallery's new images to a channel, optionally only for a number of hours
+func setGalleryMirror(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("mirror")
+	defer done()
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+	channelId := ""
+	if option := findOption(command.Options, "channel"); option != nil {
+		channelId = option.ChannelValue(nil).ID
+	}
+	var until time.Time
+	if option := findOption(command.Options, "hours"); option != nil && option.IntValue() > maxMirrorHours {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Mirroring can be set to stop after at most %d hours :stop_sign: (Leave hours out to mirror until it's turned off.)", maxMirrorHours),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if option != nil && option.IntValue() > 0 {
+		until = time.Now().Add(time.Duration(option.IntValue()) * time.Hour)
+	}
+
+	if len(channelId) > 0 {
+		if problem := checkPostPermissions(i, channelId); problem != nil {
+			data.Embeds = []*discordgo.MessageEmbed{problem}
+			return data
+		}
+	}
+
+	docRef := getGalleryDocRef(galleryName)
+	_, err := docRef.Update(ctx, []firestore.Update{
+		{Path: "mirrorChannelId", Value: channelId},
+		{Path: "mirrorUntil", Value: until},
+	})
+	if status.Code(err) == codes.NotFound {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docRef", docRef).Msg("Failed to write document contents")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to modify gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	switch {
+	case len(channelId) == 0:
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Images added to `%s` are no longer mirrored :white_check_mark:", galleryName),
+			Color:       0x43b581,
+		}
+	case until.IsZero():
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Images added to `%s` will be posted in <#%s> :white_check_mark:", galleryName, channelId),
+			Color:       0x43b581,
+		}
+	default:
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Images added to `%s` will be posted in <#%s> until <t:%d:f> :white_check_mark:", galleryName, channelId, until.Unix()),
+			Color:       0x43b581,
+		}
+	}
+	log.Debug().Str("gallery", galleryName).Str("channel", channelId).Time("until", until).Msg("Changed gallery mirror")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// checkPostPermissions returns an embed explaining the problem if the bot can't post image embeds in channelId
+func checkPostPermissions(i *discordgo.Interaction, channelId string) *discordgo.MessageEmbed {
+	const neededPermissions = discordgo.PermissionViewChannel | discordgo.PermissionSendMessages | discordgo.PermissionEmbedLinks
+	permissions, err := s.UserChannelPermissions(s.State.User.ID, channelId)
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Str("channel", channelId).Msg("Failed to determine channel permissions")
+		return &discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Unable to check whether I can post in <#%s> :stop_sign:", channelId),
+			Color:       0xf04747,
+		}
+	}
+	if permissions&neededPermissions != neededPermissions {
+		log.Debug().Str("channel", channelId).Int64("permissions", permissions).Msg("Attempted to post in channel without send permissions")
+		return &discordgo.MessageEmbed{
+			Description: fmt.Sprintf("I need the View Channel, Send Messages, and Embed Links permissions in <#%s> to post there :stop_sign:", channelId),
+			Color:       0xf04747,
+		}
+	}
+	return nil
+}
+
+// spotlightImage sends a random image from a gallery to another channel, e.g. to feature it in an announcements channel
+func spotlightImage(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	data.Flags = messageFlagsEphemeral
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+	channelId := command.Options[1].ChannelValue(nil).ID
+
+	if problem := checkPostPermissions(i, channelId); problem != nil {
+		data.Embeds = []*discordgo.MessageEmbed{problem}
+		return data
+	}
+
+	gallery, problem := loadGallery(i, galleryName)
+	if problem != nil {
+		data.Embeds = []*discordgo.MessageEmbed{problem}
+		return data
+	}
+	if gallery.Disabled {
+		data.Embeds = []*discordgo.MessageEmbed{galleryDisabledEmbed(galleryName)}
+		return data
+	}
+	numberOfImages := len(gallery.Images)
+	if numberOfImages == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery is empty :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	imageNum := rand.Intn(numberOfImages)
+	spotlight := discordgo.MessageEmbed{
+		Title: fmt.Sprintf("Spotlight from %s :sparkles:", galleryName),
+		Color: 0x5865f2,
+		Image: &discordgo.MessageEmbedImage{
+			URL: displayImageURL(gallery.Images[imageNum].ImageURL),
+		},
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Image: %d of %d | Gallery: %s", imageNum, numberOfImages-1, galleryName),
+		},
+	}
+	addAltTextField(&spotlight, gallery.Images[imageNum])
+	fitEmbedLimits([]*discordgo.MessageEmbed{&spotlight})
+	message, err := s.ChannelMessageSendEmbed(channelId, &spotlight)
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Str("channel", channelId).Msg("Failed to send spotlight")
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Unable to post in <#%s> :stop_sign:", channelId),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	recordView(galleryName, gallery.Images[imageNum].ImageURL)
+
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("Posted image `%d` from `%s` in <#%s> :white_check_mark:", imageNum, galleryName, channelId),
+		URL:         fmt.Sprintf("https://discord.com/channels/%s/%s/%s", i.GuildID, channelId, message.ID),
+		Color:       0x43b581,
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// diffGalleries compares the images (by normalized link) of two galleries, to help decide whether to merge or dedupe them
+// Only a sample of the differing image numbers fits in the embed, so when there are more, the complete lists are attached as a file.
+func diffGalleries(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	const maxSampled = 10
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryNames := []string{command.Options[0].StringValue(), command.Options[1].StringValue()}
+	if galleryNames[0] == galleryNames[1] {
+		embed = discordgo.MessageEmbed{
+			Description: "Pick two different galleries to compare :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	var galleries [2]Gallery
+	var urls [2]map[string]bool
+	for n, galleryName := range galleryNames {
+		gallery, problem := loadGallery(i, galleryName)
+		if problem != nil {
+			problem.Description = fmt.Sprintf("`%s`: %s", galleryName, problem.Description)
+			data.Embeds = []*discordgo.MessageEmbed{problem}
+			return data
+		}
+		galleries[n] = gallery
+		urls[n] = make(map[string]bool)
+		for _, image := range gallery.Images {
+			urls[n][normalizeImageURL(image.ImageURL)] = true
+		}
+	}
+
+	// Image numbers in each gallery whose link the other gallery lacks, in gallery order
+	var only [2][]int
+	shared := make(map[string]bool)
+	for n, gallery := range galleries {
+		for imageNum, image := range gallery.Images {
+			normalized := normalizeImageURL(image.ImageURL)
+			if urls[1-n][normalized] {
+				shared[normalized] = true
+			} else {
+				only[n] = append(only[n], imageNum)
+			}
+		}
+	}
+
+	embed = discordgo.MessageEmbed{
+		Title: fmt.Sprintf("%s vs %s", galleryNames[0], galleryNames[1]),
+		Color: 0x5865f2,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("%d images in both", len(shared)),
+		},
+	}
+	truncated := false
+	for n, galleryName := range galleryNames {
+		value := "None"
+		if len(only[n]) > 0 {
+			sample := only[n]
+			if len(sample) > maxSampled {
+				sample = sample[:maxSampled]
+				truncated = true
+			}
+			numbers := make([]string, len(sample))
+			for k, imageNum := range sample {
+				numbers[k] = strconv.Itoa(imageNum)
+			}
+			value = fmt.Sprintf("%d images: `%s`", len(only[n]), strings.Join(numbers, "`, `"))
+			if len(sample) < len(only[n]) {
+				value += ", …"
+			}
+		}
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  fmt.Sprintf("Only in %s", galleryName),
+			Value: value,
+		})
+	}
+
+	if truncated {
+		var list strings.Builder
+		for n, galleryName := range galleryNames {
+			fmt.Fprintf(&list, "Only in %s (%d):\n", galleryName, len(only[n]))
+			for _, imageNum := range only[n] {
+				fmt.Fprintf(&list, "%d\t%s\n", imageNum, galleries[n].Images[imageNum].ImageURL)
+			}
+			list.WriteString("\n")
+		}
+		data.Files = []*discordgo.File{
+			{
+				Name:        "diff.txt",
+				ContentType: "text/plain",
+				Reader:      strings.NewReader(list.String()),
+			},
+		}
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// setPaused pauses or resumes handling commands from anyone but administrators, optionally remembering a pause across restarts
+// Resuming always clears a remembered pause.
+func setPaused(i *discordgo.Interaction, pause bool) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("pause")
+	defer done()
+
+	command := i.ApplicationCommandData().Options[0]
+	persist := !pause
+	if option := findOption(command.Options, "persist"); option != nil && pause {
+		persist = option.BoolValue()
+	}
+
+	if persist {
+		_, err := getGuildSettingsDocRef().Set(ctx, map[string]interface{}{"paused": pause}, firestore.MergeAll)
+		if err != nil {
+			log.Error().Err(err).Caller().Interface("interaction", i).Msg("Failed to write guild settings")
+			embed = discordgo.MessageEmbed{
+				Description: "Unable to change the server's settings :stop_sign:",
+				Color:       0xf04747,
+			}
+			data.Embeds = []*discordgo.MessageEmbed{&embed}
+			return data
+		}
+	}
+
+	if pause {
+		atomic.StoreInt32(&paused, 1)
+		embed = discordgo.MessageEmbed{
+			Description: "The bot is paused for maintenance :pause_button: Only administrators can use it until someone runs `/gallery_admin resume`.",
+			Color:       0x43b581,
+		}
+		if persist {
+			embed.Description += " It will stay paused across restarts."
+		}
+	} else {
+		atomic.StoreInt32(&paused, 0)
+		embed = discordgo.MessageEmbed{
+			Description: "The bot is handling commands again :arrow_forward:",
+			Color:       0x43b581,
+		}
+	}
+	log.Info().Bool("paused", pause).Bool("persist", persist).Str("user", interactionUserID(i)).Msg("Changed pause state")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// respondPaused turns away a non-administrator while the bot is paused
+func respondPaused(s discordSession, i *discordgo.Interaction) {
+	embed := discordgo.MessageEmbed{
+		Description: "The bot is paused for maintenance :pause_button:",
+		Color:       0xf04747,
+	}
+	err := respond(s, i, discordgo.InteractionResponseChannelMessageWithSource, &discordgo.InteractionResponseData{
+		Embeds: []*discordgo.MessageEmbed{&embed},
+		Flags:  messageFlagsEphemeral,
+	})
+	if err != nil {
+		log.Error().Err(err).Interface("interaction", i).Msg("Failure in responding to interaction")
+	}
+}
+
+// homeGuildComponents are the buttons that act like a homeGuildOnly command, because they confirm or follow up on one
+var homeGuildComponents = map[string]bool{
+	"gallery_delete_yes":     true,
+	"gallery_delete_large":   true,
+	"gallery_delete_no":      true,
+	"gallery_dedupe_yes":     true,
+	"gallery_dedupe_no":      true,
+	"gallery_merge":          true,
+	"gallery_merge_yes":      true,
+	"gallery_merge_no":       true,
+	"gallery_delete_empties": true,
+	"gallery_keep_empties":   true,
+	"featured_swap":          true,
+	"featured_keep":          true,
+	"image_delete_yes":       true,
+	"image_delete_no":        true,
+	"image_undo":             true,
+}
+
+// homeGuildOnly reports whether i may only be used in the home guild when in multiGuild mode
+// Galleries, bans, and the guild settings (pause, toggle_command, tag rules, ...) are shared by every guild the bot is in, so members of other guilds can browse and add images but not manage or reconfigure anything; the one exception is guild_config, which only changes their own guild's GuildConfig.
+func homeGuildOnly(i *discordgo.Interaction) bool {
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		data := i.ApplicationCommandData()
+		if len(data.Options) == 0 {
+			return false
+		}
+		switch data.Name {
+		case "gallery_admin":
+			return true
+		case "gallery_ops":
+			return data.Options[0].Name != "guild_config"
+		case "gallery":
+			return managedSubcommands[data.Options[0].Name]
+		}
+	case discordgo.InteractionMessageComponent:
+		return homeGuildComponents[strings.SplitN(i.MessageComponentData().CustomID, ":", 2)[0]]
+	}
+	return false
+}
+
+// respondHomeGuildOnly turns away a homeGuildOnly interaction from another guild
+func respondHomeGuildOnly(s discordSession, i *discordgo.Interaction) {
+	embed := discordgo.MessageEmbed{
+		Description: "Galleries are shared with other servers, so this can only be done in the bot's home server :stop_sign:",
+		Color:       0xf04747,
+	}
+	err := respond(s, i, discordgo.InteractionResponseChannelMessageWithSource, &discordgo.InteractionResponseData{
+		Embeds: []*discordgo.MessageEmbed{&embed},
+		Flags:  messageFlagsEphemeral,
+	})
+	if err != nil {
+		log.Error().Err(err).Interface("interaction", i).Msg("Failure in responding to interaction")
+	}
+}
+
+// respondGuildOnly turns away an interaction from outside of a guild, which only happens with multiGuild's global commands
+func respondGuildOnly(s discordSession, i *discordgo.Interaction) {
+	embed := discordgo.MessageEmbed{
+		Description: "Galleries can only be used in a server :stop_sign:",
+		Color:       0xf04747,
+	}
+	err := respond(s, i, discordgo.InteractionResponseChannelMessageWithSource, &discordgo.InteractionResponseData{
+		Embeds: []*discordgo.MessageEmbed{&embed},
+		Flags:  messageFlagsEphemeral,
+	})
+	if err != nil {
+		log.Error().Err(err).Interface("interaction", i).Msg("Failure in responding to interaction")
+	}
+}
+
+// hashImageURL identifies an image by its normalized link
+func hashImageURL(imageUrl string) string {
+	sum := sha256.Sum256([]byte(normalizeImageURL(imageUrl)))
+	return hex.EncodeToString(sum[:])
+}
+
+// hashImageBytes downloads an image (up to auditMaxImageBytes) and identifies it by its content
+func hashImageBytes(imageUrl string) (string, error) {
+	response, err := httpClient.Get(imageUrl)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", response.Status)
+	}
+	hash := sha256.New()
+	n, err := io.Copy(hash, io.LimitReader(response.Body, int64(auditMaxImageBytes)+1))
+	if err != nil {
+		return "", err
+	}
+	if n > int64(auditMaxImageBytes) {
+		return "", fmt.Errorf("image is larger than %d bytes", auditMaxImageBytes)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// imageHashes returns the hashes a ban on image could be recorded under
+func imageHashes(image Image) (hashes []string) {
+	hashes = append(hashes, hashImageURL(image.ImageURL))
+	if len(image.ContentHash) > 0 {
+		hashes = append(hashes, image.ContentHash)
+	}
+	return hashes
+}
+
+// imageBanned reports whether any of image's hashes has been banned
+func imageBanned(image Image) (bool, error) {
+	ctx, done := requestContext("imageBanned")
+	defer done()
+	var docRefs []*firestore.DocumentRef
+	for _, hash := range imageHashes(image) {
+		docRefs = append(docRefs, firestoreClient.Collection("banned").Doc(hash))
+	}
+	docSnaps, err := firestoreClient.GetAll(ctx, docRefs)
+	if err != nil {
+		return false, err
+	}
+	for _, docSnap := range docSnaps {
+		if docSnap.Exists() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// banImage bans an image by its hashes, then removes every copy of it from every gallery
+func banImage(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+	imageNum := int(command.Options[1].IntValue())
+
+	gallery, problem := loadGallery(i, galleryName)
+	if problem != nil {
+		data.Embeds = []*discordgo.MessageEmbed{problem}
+		return data
+	}
+	if imageNum < 0 || imageNum >= len(gallery.Images) {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Invalid image number :stop_sign: (Valid range is 0-%d)", len(gallery.Images)-1),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	image := gallery.Images[imageNum]
+	if hashImageContent && len(image.ContentHash) == 0 {
+		contentHash, err := hashImageBytes(image.ImageURL)
+		if err != nil {
+			log.Warn().Err(err).Str("imageUrl", image.ImageURL).Msg("Unable to hash image content, banning by link only")
+		} else {
+			image.ContentHash = contentHash
+		}
+	}
+
+	// Hashing may download the image, so the deadline only starts once it's done
+	ctx, done := requestContext("ban")
+	defer done()
+	hashes := imageHashes(image)
+	batch := firestoreClient.Batch()
+	for n, hash := range hashes {
+		kind := "url"
+		if n > 0 {
+			kind = "content"
+		}
+		batch.Set(firestoreClient.Collection("banned").Doc(hash), BannedImage{
+			Kind:     kind,
+			Example:  image.ImageURL,
+			BannedBy: interactionUserID(i),
+			BannedAt: time.Now(),
+		})
+	}
+	_, err := batch.Commit(ctx)
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Strs("hashes", hashes).Msg("Failed to write banned image")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to ban the image :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	log.Info().Str("imageUrl", image.ImageURL).Strs("hashes", hashes).Str("user", interactionUserID(i)).Msg("Image banned")
+
+	removed, failed := sweepBannedImages(i, hashes)
+	description := fmt.Sprintf("Banned image `%d` from `%s` and removed %d copies of it :no_entry:\nHash: `%s`", imageNum, galleryName, removed, hashes[0])
+	color := 0x43b581
+	if len(failed) > 0 {
+		description += fmt.Sprintf("\n:warning: Couldn't remove it from `%s` (locked or unreadable); it will be refused there from now on, but existing copies remain.", strings.Join(failed, "`, `"))
+		color = 0xfaa61a
+	}
+	embed = discordgo.MessageEmbed{
+		Description: description,
+		Color:       color,
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// sweepBannedImages removes images matching any of hashes from every gallery, returning how many were removed and which galleries couldn't be swept
+func sweepBannedImages(i *discordgo.Interaction, hashes []string) (removed int, failed []string) {
+	banned := make(map[string]bool)
+	for _, hash := range hashes {
+		banned[hash] = true
+	}
+	matches := func(image Image) bool {
+		for _, hash := range imageHashes(image) {
+			if banned[hash] {
+				return true
+			}
+		}
+		return false
+	}
+
+	docSnaps, err := getAllGalleries()
+	if err != nil {
+		log.Error().Err(err).Caller().Msg("Failed to retrieve galleries to sweep banned images")
+		return 0, []string{"every gallery"}
+	}
+	for _, docSnap := range docSnaps {
+		var gallery Gallery
+		err = docSnap.DataTo(&gallery)
+		if err != nil {
+			failed = append(failed, docSnap.Ref.ID)
+			continue
+		}
+		found := false
+		for _, image := range gallery.Images {
+			found = found || matches(image)
+		}
+		if !found {
+			continue
+		}
+
+		var kept []Image
+		galleryRemoved := 0
+		docRef := docSnap.Ref
+		txCtx, done := requestContext("ban")
+		err = firestoreClient.RunTransaction(txCtx, func(ctx context.Context, tx *firestore.Transaction) error {
+			docSnap, err := tx.Get(docRef)
+			if err != nil {
+				return err
+			}
+			var gallery Gallery
+			err = docSnap.DataTo(&gallery)
+			if err != nil {
+				return err
+			}
+			if gallery.Locked {
+				return errGalleryLocked
+			}
+			kept = nil
+			galleryRemoved = 0
+			for _, image := range gallery.Images {
+				if matches(image) {
+					galleryRemoved++
+				} else {
+					kept = append(kept, image)
+				}
+			}
+			gallery.FeaturedIndex = featuredIndexAfterRewrite(gallery.Images, kept, gallery.FeaturedIndex)
+			gallery.Images = kept
+			return tx.Set(docRef, gallery)
+		})
+		done()
+		if err != nil {
+			log.Error().Err(err).Caller().Str("gallery", docRef.ID).Msg("Failed to remove banned images")
+			failed = append(failed, docRef.ID)
+			continue
+		}
+		if galleryRemoved > 0 {
+			recordAuditEvent(docRef.ID, AuditEvent{
+				Action:  auditActionReplace,
+				ActorID: interactionUserID(i),
+				Images:  kept,
+			})
+		}
+		removed += galleryRemoved
+	}
+	return removed, failed
+}
+
+// unbanImage lifts a ban by the hash banImage reported, or listBannedImages lists
+func unbanImage(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("unban")
+	defer done()
+
+	command := i.ApplicationCommandData().Options[0]
+	hash := strings.ToLower(strings.TrimSpace(command.Options[0].StringValue()))
+	if _, err := hex.DecodeString(hash); err != nil || len(hash) != sha256.Size*2 {
+		embed = discordgo.MessageEmbed{
+			Description: "That isn't an image hash :stop_sign: (Run `/gallery_ops bans` to see them)",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	docRef := firestoreClient.Collection("banned").Doc(hash)
+	_, err := docRef.Get(ctx)
+	if err == nil {
+		_, err = docRef.Delete(ctx)
+	}
+	if status.Code(err) == codes.NotFound {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("`%s` isn't banned :stop_sign:", hash),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Str("hash", hash).Msg("Failed to delete banned image")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to lift the ban :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("Lifted the ban on `%s` :white_check_mark:\nImages removed when it was banned aren't restored.", hash),
+		Color:       0x43b581,
+	}
+	log.Info().Str("hash", hash).Str("user", interactionUserID(i)).Msg("Image unbanned")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// listBannedImages shows every banned hash, most recent first
+func listBannedImages(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("bans")
+	defer done()
+
+	docSnaps, err := firestoreClient.Collection("banned").OrderBy("bannedAt", firestore.Desc).Documents(ctx).GetAll()
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Msg("Failed to retrieve banned images")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get the banned images :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	if len(docSnaps) == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: "No images are banned :white_check_mark:",
+			Color:       0x5865f2,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	var description strings.Builder
+	fmt.Fprintf(&description, "%d hashes are banned :no_entry:\n", len(docSnaps))
+	for _, docSnap := range docSnaps {
+		var ban BannedImage
+		err = docSnap.DataTo(&ban)
+		if err != nil {
+			log.Error().Err(err).Caller().Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+			continue
+		}
+		fmt.Fprintf(&description, "\n`%s` (%s, from <%s>) by <@%s> <t:%d:R>", docSnap.Ref.ID, ban.Kind, ban.Example, ban.BannedBy, ban.BannedAt.Unix())
+	}
+	embed = discordgo.MessageEmbed{
+		Description: description.String(),
+		Color:       0x5865f2,
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	data.Flags = messageFlagsEphemeral
+	return data
+}
+
+// scheduleRepost sets up an image to be posted in a channel at a fixed interval, starting now
+// Without an image_number, a random image is picked once and then reposted every time.
+func scheduleRepost(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("repost")
+	defer done()
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+	channelId := command.Options[1].ChannelValue(nil).ID
+	every := strings.TrimSpace(command.Options[2].StringValue())
+
+	interval, err := parseAge(every)
+	if err != nil || interval < repostMinInterval {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("The interval must be at least %s, like 1d or 2w :stop_sign:", repostMinInterval),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	if problem := checkPostPermissions(i, channelId); problem != nil {
+		data.Embeds = []*discordgo.MessageEmbed{problem}
+		return data
+	}
+
+	gallery, problem := loadGallery(i, galleryName)
+	if problem != nil {
+		data.Embeds = []*discordgo.MessageEmbed{problem}
+		return data
+	}
+	numberOfImages := len(gallery.Images)
+	if numberOfImages == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery is empty :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	imageNum := rand.Intn(numberOfImages)
+	if option := findOption(command.Options, "image_number"); option != nil {
+		imageNum = int(option.IntValue())
+		if imageNum < 0 || imageNum >= numberOfImages {
+			embed = discordgo.MessageEmbed{
+				Description: fmt.Sprintf("Invalid image number :stop_sign: (Valid range is 0-%d)", numberOfImages-1),
+				Color:       0xf04747,
+			}
+			data.Embeds = []*discordgo.MessageEmbed{&embed}
+			return data
+		}
+	}
+
+	schedule := RepostSchedule{
+		Gallery:         galleryName,
+		ImageUrl:        gallery.Images[imageNum].ImageURL,
+		ChannelID:       channelId,
+		Every:           every,
+		IntervalSeconds: int64(interval / time.Second),
+		NextRun:         time.Now(),
+		CreatedBy:       interactionUserID(i),
+	}
+	docRef, _, err := firestoreClient.Collection("schedules").Add(ctx, schedule)
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("schedule", schedule).Msg("Failed to write repost schedule")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to save the schedule :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("Image `%d` from `%s` will be posted in <#%s> every %s, starting shortly :repeat:\nCancel it with `/gallery_ops cancel_repost %s`.", imageNum, galleryName, channelId, every, docRef.ID),
+		Color:       0x43b581,
+	}
+	log.Info().Str("schedule", docRef.ID).Interface("repost", schedule).Msg("Repost scheduled")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// listReposts shows one page of the repost schedules, soonest first, with buttons to page through them
+func listReposts(i *discordgo.Interaction, page int) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("reposts")
+	defer done()
+	const pageSize = 10 // Each line links an image, and attachment links are long, so this keeps a page well within an embed description
+	data.Components = []discordgo.MessageComponent{}
+
+	docSnaps, err := firestoreClient.Collection("schedules").OrderBy("nextRun", firestore.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Msg("Failed to retrieve repost schedules")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get the schedules :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	if len(docSnaps) == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: "Nothing is scheduled to be reposted :calendar:",
+			Color:       0x5865f2,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	var lines []string
+	for _, docSnap := range docSnaps {
+		var schedule RepostSchedule
+		err = docSnap.DataTo(&schedule)
+		if err != nil {
+			log.Error().Err(err).Caller().Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+			continue
+		}
+		if schedule.IntervalSeconds == 0 {
+			continue // A reveal, listed by reveals
+		}
+		lines = append(lines, fmt.Sprintf("`%s`: [an image](%s) from `%s` in <#%s> every %s, next <t:%d:R>", docSnap.Ref.ID, displayImageURL(schedule.ImageUrl), schedule.Gallery, schedule.ChannelID, schedule.Every, schedule.NextRun.Unix()))
+	}
+	if len(lines) == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: "Nothing is scheduled to be reposted :calendar:",
+			Color:       0x5865f2,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	pages := (len(lines) + pageSize - 1) / pageSize
+	if page >= pages {
+		page = pages - 1 // Schedules may have been cancelled since the previous page was shown
+	}
+	if page < 0 {
+		page = 0
+	}
+	last := (page + 1) * pageSize
+	if last > len(lines) {
+		last = len(lines)
+	}
+
+	var description strings.Builder
+	fmt.Fprintf(&description, "%d reposts are scheduled :calendar:\n\n", len(lines))
+	description.WriteString(strings.Join(lines[page*pageSize:last], "\n"))
+	embed = discordgo.MessageEmbed{
+		Description: description.String(),
+		Color:       0x5865f2,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Page: %d of %d", page+1, pages),
+		},
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	if pages > 1 {
+		data.Components = []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{
+						Label:    "Previous",
+						Style:    discordgo.SecondaryButton,
+						CustomID: componentID("reposts_previous", fmt.Sprint(page)),
+						Disabled: page == 0,
+					},
+					discordgo.Button{
+						Label:    "Next",
+						Style:    discordgo.SecondaryButton,
+						CustomID: componentID("reposts_next", fmt.Sprint(page)),
+						Disabled: page == pages-1,
+					},
+				},
+			},
+		}
+	}
+	return data
+}
+
+// respondRepostsMove turns a repost list message to the page move picks
+func respondRepostsMove(s discordSession, i *discordgo.Interaction, move int) {
+	state, ok := componentState(i, 1)
+	if !ok {
+		respondOutdatedComponent(s, i)
+		return
+	}
+	page, _ := strconv.Atoi(state[0])
+	data := listReposts(i, page+move)
+	err := respond(s, i, discordgo.InteractionResponseUpdateMessage, &data)
+	if err != nil {
+		log.Error().Err(err).Interface("interaction", i).Msg("Failure in responding to interaction")
+	}
+}
+
+// scheduleReveal sets up an image to be posted in a channel once, at a time in the future
+// The image is checked now and again when the time comes, in case it's been removed in between.
+func scheduleReveal(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("reveal")
+	defer done()
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+	imageNum := int(command.Options[1].IntValue())
+	channelId := command.Options[2].ChannelValue(nil).ID
+	rawTime := strings.TrimSpace(command.Options[3].StringValue())
+
+	at, err := parseTimestamp(rawTime)
+	if err != nil {
+		delay, delayErr := parseAge(rawTime)
+		if delayErr != nil {
+			embed = discordgo.MessageEmbed{
+				Description: fmt.Sprintf("Invalid time :stop_sign: (%s, or a delay like 2h or 3d)", err),
+				Color:       0xf04747,
+			}
+			data.Embeds = []*discordgo.MessageEmbed{&embed}
+			return data
+		}
+		at = time.Now().Add(delay)
+	}
+	if !at.After(time.Now()) {
+		embed = discordgo.MessageEmbed{
+			Description: "The reveal has to be in the future :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	if problem := checkPostPermissions(i, channelId); problem != nil {
+		data.Embeds = []*discordgo.MessageEmbed{problem}
+		return data
+	}
+
+	gallery, problem := loadGallery(i, galleryName)
+	if problem != nil {
+		data.Embeds = []*discordgo.MessageEmbed{problem}
+		return data
+	}
+	numberOfImages := len(gallery.Images)
+	if imageNum < 0 || imageNum >= numberOfImages {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Invalid image number :stop_sign: (Valid range is 0-%d)", numberOfImages-1),
+			Color:       0xf04747,
+		}
+		if numberOfImages == 0 {
+			embed.Description = "Gallery is empty :stop_sign:"
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	schedule := RepostSchedule{
+		Gallery:   galleryName,
+		ImageUrl:  gallery.Images[imageNum].ImageURL,
+		ChannelID: channelId,
+		NextRun:   at,
+		CreatedBy: interactionUserID(i),
+	}
+	docRef, _, err := firestoreClient.Collection("schedules").Add(ctx, schedule)
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("schedule", schedule).Msg("Failed to write reveal")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to save the schedule :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("Image `%d` from `%s` will be revealed in <#%s> <t:%d:R> (<t:%d:f>) :hourglass:\nCancel it with `/gallery_ops cancel_repost %s`.", imageNum, galleryName, channelId, at.Unix(), at.Unix(), docRef.ID),
+		Color:       0x43b581,
+	}
+	data.Flags = messageFlagsEphemeral // Keep the surprise
+	log.Info().Str("schedule", docRef.ID).Interface("reveal", schedule).Msg("Reveal scheduled")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// listReveals shows every pending reveal, soonest first
+func listReveals(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("reveals")
+	defer done()
+	data.Flags = messageFlagsEphemeral
+
+	docSnaps, err := firestoreClient.Collection("schedules").OrderBy("nextRun", firestore.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Msg("Failed to retrieve reveals")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get the schedules :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	var lines []string
+	for _, docSnap := range docSnaps {
+		var schedule RepostSchedule
+		err = docSnap.DataTo(&schedule)
+		if err != nil {
+			log.Error().Err(err).Caller().Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+			continue
+		}
+		if schedule.IntervalSeconds != 0 {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("`%s`: [an image](%s) from `%s` in <#%s> <t:%d:R>", docSnap.Ref.ID, displayImageURL(schedule.ImageUrl), schedule.Gallery, schedule.ChannelID, schedule.NextRun.Unix()))
+	}
+	if len(lines) == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: "No reveals are pending :hourglass:",
+			Color:       0x5865f2,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	var description strings.Builder
+	fmt.Fprintf(&description, "%d reveals are pending :hourglass:\n\n", len(lines))
+	description.WriteString(strings.Join(lines, "\n"))
+	embed = discordgo.MessageEmbed{
+		Description: description.String(),
+		Color:       0x5865f2,
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// cancelRepost deletes a repost schedule or reveal by the ID shown when it was made and in reposts or reveals
+func cancelRepost(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("cancel_repost")
+	defer done()
+
+	command := i.ApplicationCommandData().Options[0]
+	scheduleId := strings.TrimSpace(command.Options[0].StringValue())
+	if len(scheduleId) == 0 || strings.Contains(scheduleId, "/") {
+		embed = discordgo.MessageEmbed{
+			Description: "There is no such schedule :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	docRef := firestoreClient.Collection("schedules").Doc(scheduleId)
+	_, err := docRef.Get(ctx)
+	if err == nil {
+		_, err = docRef.Delete(ctx)
+	}
+	if status.Code(err) == codes.NotFound {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("There is no schedule `%s` :stop_sign: (Run `/gallery_ops reposts` or `/gallery_ops reveals` to see them)", scheduleId),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Str("schedule", scheduleId).Msg("Failed to delete repost schedule")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to cancel the schedule :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("Cancelled schedule `%s` :white_check_mark:", scheduleId),
+		Color:       0x43b581,
+	}
+	log.Info().Str("schedule", scheduleId).Msg("Repost schedule cancelled")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// postScheduledRepost posts a schedule's image, returning false if the schedule can never run again (its gallery or image is gone)
+func postScheduledRepost(schedule RepostSchedule) (keep bool, err error) {
+	docSnap, err := getGalleryDocRef(schedule.Gallery).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return false, nil
+	} else if err != nil {
+		return true, err
+	}
+	var gallery Gallery
+	err = docSnap.DataTo(&gallery)
+	if err != nil {
+		return true, err
+	}
+	if gallery.Disabled {
+		return true, errGalleryDisabled // Skipped until the gallery gets a working image, which a reveal waits for
+	}
+	for n, image := range gallery.Images {
+		// Loosely, in case the image's attachment link was re-signed after the schedule's was last updated
+		if canonicalImageURL(image.ImageURL) != canonicalImageURL(schedule.ImageUrl) {
+			continue
+		}
+		embed := discordgo.MessageEmbed{
+			Color: 0x5865f2,
+			Image: &discordgo.MessageEmbedImage{
+				URL: displayImageURL(image.ImageURL),
+			},
+			Footer: &discordgo.MessageEmbedFooter{
+				Text: fmt.Sprintf("Image: %d of %d | Gallery: %s", n, len(gallery.Images)-1, schedule.Gallery),
+			},
+		}
+		if schedule.IntervalSeconds == 0 {
+			embed.Description = "The wait is over :tada:"
+		}
+		addAltTextField(&embed, image)
+		fitEmbedLimits([]*discordgo.MessageEmbed{&embed})
+		_, err = s.ChannelMessageSendEmbed(schedule.ChannelID, &embed)
+		if err != nil {
+			return true, err
+		}
+		recordView(schedule.Gallery, image.ImageURL)
+		return true, nil
+	}
+	return false, nil
+}
+
+// runDueReposts posts every schedule whose time has come, then moves it to its next run after now, or deletes it if it was a reveal
+// Runs missed while the bot was down are not caught up on; the image is posted once and the schedule carries on from the present. A reveal that fails to post (e.g. Discord is briefly unreachable) is left in place to be retried on the next check.
+func runDueReposts() {
+	now := time.Now()
+	docSnaps, err := firestoreClient.Collection("schedules").Where("nextRun", "<=", now).Documents(ctx).GetAll()
+	if err != nil {
+		log.Error().Err(err).Caller().Msg("Failed to retrieve due repost schedules")
+		return
+	}
+	for _, docSnap := range docSnaps {
+		var schedule RepostSchedule
+		err = docSnap.DataTo(&schedule)
+		if err != nil || schedule.IntervalSeconds < 0 {
+			log.Error().Err(err).Caller().Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+			continue
+		}
+		keep, err := postScheduledRepost(schedule)
+		if err != nil {
+			log.Error().Err(err).Caller().Str("schedule", docSnap.Ref.ID).Msg("Failed to post scheduled repost")
+		}
+		if schedule.IntervalSeconds == 0 {
+			if err != nil && keep {
+				continue
+			}
+			if !keep {
+				log.Warn().Str("schedule", docSnap.Ref.ID).Interface("reveal", schedule).Msg("Dropping reveal whose image no longer exists")
+			}
+			_, err = docSnap.Ref.Delete(ctx)
+			if err != nil {
+				log.Error().Err(err).Caller().Str("schedule", docSnap.Ref.ID).Msg("Failed to delete reveal")
+			}
+			continue
+		}
+		if !keep {
+			log.Warn().Str("schedule", docSnap.Ref.ID).Interface("repost", schedule).Msg("Cancelling repost schedule whose image no longer exists")
+			_, err = docSnap.Ref.Delete(ctx)
+			if err != nil {
+				log.Error().Err(err).Caller().Str("schedule", docSnap.Ref.ID).Msg("Failed to delete repost schedule")
+			}
+			continue
+		}
+		interval := time.Duration(schedule.IntervalSeconds) * time.Second
+		next := schedule.NextRun.Add(interval)
+		if next.Before(now) {
+			skipped := now.Sub(next)/interval + 1
+			next = next.Add(skipped * interval)
+		}
+		_, err = docSnap.Ref.Update(ctx, []firestore.Update{{Path: "nextRun", Value: next}})
+		if err != nil {
+			log.Error().Err(err).Caller().Str("schedule", docSnap.Ref.ID).Msg("Failed to update repost schedule")
+		}
+	}
+}
+
+// runRepostsPeriodically calls runDueReposts right away and then every repostCheckInterval until stop is closed
+func runRepostsPeriodically(stop <-chan struct{}) {
+	runDueReposts()
+	ticker := time.NewTicker(repostCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			runDueReposts()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// convertImage downloads an image and re-encodes it as format ("png" or "jpeg"), giving up after convertTimeout
+// Only formats the standard library can decode (PNG, JPEG, and GIF) can be converted, and images over convertMaxPixels are refused rather than decoded.
+func convertImage(imageUrl string, format string) ([]byte, error) {
+	convertCtx, cancel := context.WithTimeout(ctx, convertTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(convertCtx, http.MethodGet, imageUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", response.Status)
+	}
+	original, err := io.ReadAll(io.LimitReader(response.Body, int64(auditMaxImageBytes)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(original) > auditMaxImageBytes {
+		return nil, fmt.Errorf("image is larger than %d bytes", auditMaxImageBytes)
+	}
+
+	bounds, _, err := image.DecodeConfig(bytes.NewReader(original))
+	if err != nil {
+		return nil, err
+	}
+	if bounds.Width*bounds.Height > convertMaxPixels {
+		return nil, fmt.Errorf("image is %dx%d, more than %d pixels", bounds.Width, bounds.Height, convertMaxPixels)
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(original))
+	if err != nil {
+		return nil, err
+	}
+	var converted bytes.Buffer
+	switch format {
+	case "png":
+		err = png.Encode(&converted, decoded)
+	case "jpeg":
+		err = jpeg.Encode(&converted, decoded, &jpeg.Options{Quality: 90})
+	default:
+		err = fmt.Errorf("can't convert to '%s'", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if converted.Len() > exportMaxArchiveBytes {
+		return nil, fmt.Errorf("converted image is larger than %d bytes", exportMaxArchiveBytes)
+	}
+	return converted.Bytes(), nil
+}
+
+// convertEmbedImage replaces the image in a response's embed with an attached copy converted to format
+// If the conversion fails, the original link is left in place with a note, so the image is still shown wherever it can be.
+func convertEmbedImage(data *discordgo.InteractionResponseData, format string) {
+	if len(data.Embeds) == 0 || data.Embeds[0].Image == nil {
+		return // Nothing was picked, e.g. the gallery is empty
+	}
+	embed := data.Embeds[0]
+	converted, err := convertImage(embed.Image.URL, format)
+	if err != nil {
+		log.Warn().Err(err).Str("imageUrl", embed.Image.URL).Str("format", format).Msg("Unable to convert image")
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Not converted",
+			Value: fmt.Sprintf("Couldn't convert this image to %s, so here's the original.", strings.ToUpper(format)),
+		})
+		return
+	}
+	name := "image." + format
+	data.Files = append(data.Files, &discordgo.File{
+		Name:        name,
+		ContentType: "image/" + format,
+		Reader:      bytes.NewReader(converted),
+	})
+	embed.Image.URL = "attachment://" + name
+}
+
+// getImageLink shows the link to an image in copyable form, along with how to bring it up again in the bot
+func getImageLink(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	data.Flags = messageFlagsEphemeral
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+	imageNum := int(command.Options[1].IntValue())
+
+	gallery, problem := loadGallery(i, galleryName)
+	if problem != nil {
+		data.Embeds = []*discordgo.MessageEmbed{problem}
+		return data
+	}
+	if gallery.Disabled {
+		data.Embeds = []*discordgo.MessageEmbed{galleryDisabledEmbed(galleryName)}
+		return data
+	}
+	numberOfImages := len(gallery.Images)
+	if numberOfImages == 0 || imageNum < 0 || imageNum >= numberOfImages {
+		data.Embeds = []*discordgo.MessageEmbed{editImageErrorEmbed(i, galleryName, numberOfImages, errInvalidImageNumber)}
+		return data
+	}
+
+	imageUrl := displayImageURL(gallery.Images[imageNum].ImageURL)
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("```\n%s\n```\nShow it again with `/gallery pick gallery_name:%s image_number:%d`", imageUrl, galleryName, imageNum),
+		Color:       0x5865f2,
+		Thumbnail: &discordgo.MessageEmbedThumbnail{
+			URL: imageUrl,
+		},
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Image: %d of %d | Gallery: %s", imageNum, numberOfImages-1, galleryName),
+		},
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// findOrphanedGalleries lists the documents that the gallery commands can't reach, so that they can be migrated or cleaned up
+// These are galleries that can't be read as a Gallery, galleries whose names can't be offered as choices, deleted galleries that left their audit history behind (which restore can bring back), and settings saved for guilds other than the configured one.
+func findOrphanedGalleries(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("orphans")
+	defer done()
+
+	docRefs, err := firestoreClient.Collection("galleries").DocumentRefs(ctx).GetAll()
+	var docSnaps []*firestore.DocumentSnapshot
+	if err == nil {
+		docSnaps, err = fetchDocuments(docRefs)
+	}
+	var settingsRefs []*firestore.DocumentRef
+	if err == nil {
+		settingsRefs, err = firestoreClient.Collection("settings").DocumentRefs(ctx).GetAll()
+	}
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Msg("Failed to retrieve galleries")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	var unreadable, badNames, deleted, otherGuilds []string
+	for _, docSnap := range docSnaps {
+		if !docSnap.Exists() {
+			deleted = append(deleted, docSnap.Ref.ID)
+			continue
+		}
+		if len([]rune(docSnap.Ref.ID)) > maxGalleryNameLength {
+			badNames = append(badNames, docSnap.Ref.ID)
+		}
+		var gallery Gallery
+		err = docSnap.DataTo(&gallery)
+		if err != nil {
+			log.Warn().Err(err).Str("gallery", docSnap.Ref.ID).Msg("Found unreadable gallery")
+			unreadable = append(unreadable, docSnap.Ref.ID)
+		}
+	}
+	for _, docRef := range settingsRefs {
+		if docRef.ID != config["guildId"] {
+			otherGuilds = append(otherGuilds, docRef.ID)
+		}
+	}
+
+	found := len(unreadable) + len(badNames) + len(deleted) + len(otherGuilds)
+	if found == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("All %d gallery documents are reachable :white_check_mark:", len(docSnaps)),
+			Color:       0x43b581,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("Found %d unreachable documents among %d gallery documents :mag:", found, len(docSnaps)),
+		Color:       0x5865f2,
+	}
+	for _, v := range []struct {
+		name string
+		ids  []string
+	}{
+		{"Unreadable galleries", unreadable},
+		{fmt.Sprintf("Names longer than %d characters", maxGalleryNameLength), badNames},
+		{"Deleted galleries with audit history (see restore)", deleted},
+		{"Settings for other guilds", otherGuilds},
+	} {
+		if len(v.ids) == 0 {
+			continue
+		}
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  fmt.Sprintf("%s: %d", v.name, len(v.ids)),
+			Value: fmt.Sprintf("`%s`", strings.Join(v.ids, "`, `")),
+		})
+	}
+	log.Debug().Int("unreadable", len(unreadable)).Int("badNames", len(badNames)).Int("deleted", len(deleted)).Int("otherGuilds", len(otherGuilds)).Msg("Found orphaned documents")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// splitGalleryByTag moves every image carrying a tag out of a gallery and into a new one, named after the tag unless a name is given
+func splitGalleryByTag(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("split_by_tag")
+	defer done()
+	var moved, kept []Image
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+	tags := parseTags(command.Options[1].StringValue())
+	if len(tags) != 1 {
+		embed = discordgo.MessageEmbed{
+			Description: "Give exactly one tag to split by :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	newGalleryName := tags[0]
+	if option := findOption(command.Options, "new_gallery_name"); option != nil {
+		newGalleryName = option.StringValue()
+	}
+	if invalid := invalidGalleryNameEmbed(newGalleryName); invalid != nil {
+		data.Embeds = []*discordgo.MessageEmbed{invalid}
+		return data
+	}
+
+	docRef := getGalleryDocRef(galleryName)
+	newDocRef := getGalleryDocRef(newGalleryName)
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(docRef)
+		if err != nil {
+			return err
+		}
+		var gallery Gallery
+		err = docSnap.DataTo(&gallery)
+		if err != nil {
+			return err
+		}
+		if gallery.Locked {
+			return errGalleryLocked
+		}
+		matching := make(map[int]bool)
+		for _, n := range imagesMatchingTags(gallery.Images, tags, true) {
+			matching[n] = true
+		}
+		if len(matching) == 0 {
+			return errNoMatchingImages
+		}
+		moved, kept = nil, nil
+		for n, image := range gallery.Images {
+			if matching[n] {
+				moved = append(moved, image)
+			} else {
+				kept = append(kept, image)
+			}
+		}
+		err = tx.Create(newDocRef, Gallery{Images: moved, CreatedAt: time.Now()})
+		if err != nil {
+			return err
+		}
+		gallery.FeaturedIndex = featuredIndexAfterRewrite(gallery.Images, kept, gallery.FeaturedIndex)
+		gallery.Images = kept
+		return tx.Set(docRef, gallery)
+	})
+	if status.Code(err) == codes.NotFound {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if status.Code(err) == codes.AlreadyExists {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Gallery `%s` already exists :stop_sign: (Give a `new_gallery_name` to split into instead.)", newGalleryName),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if errors.Is(err, errGalleryLocked) {
+		data.Embeds = []*discordgo.MessageEmbed{galleryLockedEmbed(galleryName)}
+		return data
+	} else if errors.Is(err, errNoMatchingImages) {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("No images in `%s` are tagged `%s` :stop_sign:", galleryName, tags[0]),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docRef", docRef).Msg("Failed to write document contents")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to modify gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	recordAuditEvent(newGalleryName, AuditEvent{
+		Action:  auditActionCreate,
+		ActorID: interactionUserID(i),
+	})
+	recordAuditEvent(newGalleryName, AuditEvent{
+		Action:  auditActionReplace,
+		ActorID: interactionUserID(i),
+		Images:  moved,
+	})
+	recordAuditEvent(galleryName, AuditEvent{
+		Action:  auditActionReplace,
+		ActorID: interactionUserID(i),
+		Images:  kept,
+	})
+
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("Moved %d images tagged `%s` from `%s` into the new gallery `%s`, leaving %d :white_check_mark:", len(moved), tags[0], galleryName, newGalleryName, len(kept)),
+		Color:       0x43b581,
+	}
+	log.Debug().Str("gallery", galleryName).Str("newGallery", newGalleryName).Str("tag", tags[0]).Int("moved", len(moved)).Msg("Split gallery by tag")
+	noteGalleryCreated(newGalleryName)
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// findSimilarGalleries lists pairs of galleries that mostly hold the same images, offering to merge the smaller of each pair into the larger
+// Similarity is the share of the smaller gallery's images (by normalized link) that the larger one also has, so a gallery that is a subset of another counts as a duplicate of it.
+func findSimilarGalleries(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	const maxListed = 10
+	const maxButtons = 5
+
+	command := i.ApplicationCommandData().Options[0]
+	threshold := similarityThreshold
+	if option := findOption(command.Options, "threshold"); option != nil {
+		threshold = int(option.IntValue())
+	}
+	if threshold < 1 || threshold > 100 {
+		embed = discordgo.MessageEmbed{
+			Description: "The threshold must be a percentage from 1 to 100 :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	docSnaps, err := getAllGalleries()
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Msg("Failed to retrieve galleries")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	// Count the links each pair of galleries shares by going through which galleries have each link, rather than comparing every pair of galleries
+	var names []string
+	var sizes []int
+	holders := make(map[string][]int)
+	for _, docSnap := range docSnaps {
+		var gallery Gallery
+		err = docSnap.DataTo(&gallery)
+		if err != nil {
+			log.Error().Err(err).Caller().Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+			continue
+		}
+		urls := make(map[string]bool)
+		for _, image := range gallery.Images {
+			urls[normalizeImageURL(image.ImageURL)] = true
+		}
+		for imageUrl := range urls {
+			holders[imageUrl] = append(holders[imageUrl], len(names))
+		}
+		names = append(names, docSnap.Ref.ID)
+		sizes = append(sizes, len(urls))
+	}
+	shared := make(map[[2]int]int)
+	for _, galleries := range holders {
+		for a := 0; a < len(galleries); a++ {
+			for b := a + 1; b < len(galleries); b++ {
+				shared[[2]int{galleries[a], galleries[b]}]++
+			}
+		}
+	}
+
+	type similarPair struct {
+		Larger, Smaller string
+		Shared          int
+		Percent         int
+	}
+	var pairs []similarPair
+	for pair, count := range shared {
+		larger, smaller := pair[0], pair[1]
+		if sizes[smaller] > sizes[larger] || sizes[smaller] == sizes[larger] && names[smaller] < names[larger] {
+			larger, smaller = smaller, larger
+		}
+		percent := count * 100 / sizes[smaller]
+		if percent >= threshold {
+			pairs = append(pairs, similarPair{Larger: names[larger], Smaller: names[smaller], Shared: count, Percent: percent})
+		}
+	}
+	if len(pairs) == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("No galleries share at least %d%% of their images :white_check_mark:", threshold),
+			Color:       0x43b581,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	sort.SliceStable(pairs, func(a, b int) bool {
+		if pairs[a].Percent != pairs[b].Percent {
+			return pairs[a].Percent > pairs[b].Percent
+		}
+		return pairs[a].Shared > pairs[b].Shared
+	})
+
+	var description strings.Builder
+	fmt.Fprintf(&description, "%d pairs of galleries share at least %d%% of their images :busts_in_silhouette:\n", len(pairs), threshold)
+	var buttons []discordgo.MessageComponent
+	for n, v := range pairs {
+		if n == maxListed {
+			fmt.Fprintf(&description, "\n…and %d more (raise the threshold to narrow it down)", len(pairs)-maxListed)
+			break
+		}
+		fmt.Fprintf(&description, "\n**%d.** `%s` is %d%% in `%s` (%d images)", n+1, v.Smaller, v.Percent, v.Larger, v.Shared)
+		if customId := componentID("gallery_merge", v.Larger, v.Smaller); customId != "gallery_merge" && len(buttons) < maxButtons {
+			buttons = append(buttons, discordgo.Button{
+				Label:    fmt.Sprintf("Merge %d", n+1),
+				Style:    discordgo.DangerButton,
+				CustomID: customId,
+			})
+		}
+	}
+	if len(buttons) > 0 {
+		description.WriteString("\n\nMerging moves the images only the smaller gallery has into the larger one, then deletes the smaller one.")
+		data.Components = []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: buttons,
+			},
+		}
+	}
+	embed = discordgo.MessageEmbed{
+		Description: description.String(),
+		Color:       0x5865f2,
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// mergeGalleryCommand handles /gallery merge, copying the images of one gallery that another lacks into it
+// Deleting the source as well is asked about first (see mergeGalleryPrompt).
+func mergeGalleryCommand(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	command := i.ApplicationCommandData().Options[0]
+	sourceName := command.Options[0].StringValue()
+	targetName := command.Options[1].StringValue()
+	if sourceName == targetName {
+		data.Embeds = []*discordgo.MessageEmbed{{
+			Description: "Pick two different galleries to merge :stop_sign:",
+			Color:       0xf04747,
+		}}
+		return data
+	}
+	if option := findOption(command.Options, "delete_source"); option != nil && option.BoolValue() {
+		return mergeGalleryPrompt(i, targetName, sourceName)
+	}
+	return mergeGalleries(i, targetName, sourceName, false)
+}
+
+// mergeGalleryPrompt asks for confirmation before merging sourceName into targetName and deleting it
+func mergeGalleryPrompt(i *discordgo.Interaction, targetName string, sourceName string) (data discordgo.InteractionResponseData) {
+	source, problem := loadGallery(i, sourceName)
+	if problem != nil {
+		data.Embeds = []*discordgo.MessageEmbed{problem}
+		return data
+	}
+	// The fields double as the buttons' state, should it not fit in their IDs (see componentState)
+	embed := discordgo.MessageEmbed{
+		Description: fmt.Sprintf("Are you sure you want to merge the %d images of `%s` into `%s`, then delete `%s`? :thinking:", len(source.Images), sourceName, targetName, sourceName),
+		Color:       0x5865f2,
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:  "Into",
+				Value: fmt.Sprintf("`%s`", targetName),
+			},
+			{
+				Name:  "From (to be deleted)",
+				Value: fmt.Sprintf("`%s`", sourceName),
+			},
+		},
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	data.Components = []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    deleteConfirmLabel,
+					Style:    deleteConfirmStyle,
+					CustomID: componentID("gallery_merge_yes", targetName, sourceName),
+				},
+				discordgo.Button{
+					Label:    deleteCancelLabel,
+					Style:    deleteCancelStyle,
+					CustomID: componentID("gallery_merge_no", targetName, sourceName),
+				},
+			},
+		},
+	}
+	return data
+}
+
+// mergeGalleries moves the images of sourceName that targetName lacks into targetName, then deletes sourceName if deleteSource is set
+// The target's AllowedFormats and the guild's MaxImagesPerGallery apply as they do to addImage. Images they keep out stay behind in the source, which is then kept rather than deleted.
+// A deleted source's schedules and categories are moved to the target (see galleryReferences).
+func mergeGalleries(i *discordgo.Interaction, targetName string, sourceName string, deleteSource bool) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	var images []Image
+	var moved, overLimit, disallowed int
+	var deleted bool
+	var refs galleryReferences
+	var lockedName string
+
+	targetRef := getGalleryDocRef(targetName)
+	sourceRef := getGalleryDocRef(sourceName)
+	// Detection may ask the images' hosts, which mustn't happen inside the transaction (see addImage)
+	formats := make(map[string]string)
+	restrictsFormats := galleryRestrictsFormats(targetRef)
+	if restrictsFormats {
+		source, problem := loadGallery(i, sourceName)
+		if problem != nil {
+			data.Embeds = []*discordgo.MessageEmbed{problem}
+			return data
+		}
+		for _, image := range source.Images {
+			formats[image.ImageURL] = detectImageFormat(image.ImageURL)
+		}
+	}
+	maxImages := lookupGuildConfig(i.GuildID).MaxImagesPerGallery
+
+	ctx, done := requestContext("merge")
+	defer done()
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var target, source Gallery
+		for _, v := range []struct {
+			docRef  *firestore.DocumentRef
+			gallery *Gallery
+		}{{targetRef, &target}, {sourceRef, &source}} {
+			docSnap, err := tx.Get(v.docRef)
+			if err != nil {
+				return err
+			}
+			err = docSnap.DataTo(v.gallery)
+			if err != nil {
+				return err
+			}
+			// Only the target is written to unless the source is being deleted
+			if v.gallery.Locked && (v.docRef == targetRef || deleteSource) {
+				lockedName = v.docRef.ID
+				return errGalleryLocked
+			}
+		}
+		if deleteSource {
+			var err error
+			refs, err = readGalleryReferences(tx, sourceName)
+			if err != nil {
+				return err
+			}
+		}
+		present := make(map[string]bool)
+		for _, image := range target.Images {
+			present[normalizeImageURL(image.ImageURL)] = true
+		}
+		moved, overLimit, disallowed = 0, 0, 0
+		for _, image := range source.Images {
+			normalized := normalizeImageURL(image.ImageURL)
+			if present[normalized] {
+				continue
+			}
+			if maxImages > 0 && len(target.Images) >= maxImages {
+				overLimit++
+				continue
+			}
+			if len(target.AllowedFormats) > 0 {
+				format, detected := formats[image.ImageURL]
+				if !restrictsFormats || !detected {
+					// The formats were restricted (or the image added) after the detection above, so go by the extension alone
+					format = imageExtensionFormat(image.ImageURL)
+				}
+				if !formatAllowed(target, format) {
+					disallowed++
+					continue
+				}
+			}
+			present[normalized] = true
+			target.Images = append(target.Images, image)
+			moved++
+		}
+		images = target.Images
+		err := tx.Set(targetRef, target)
+		if err != nil {
+			return err
+		}
+		deleted = deleteSource && overLimit == 0 && disallowed == 0
+		if !deleted {
+			return nil
+		}
+		err = refs.moveTo(tx, targetName)
+		if err != nil {
+			return err
+		}
+		return tx.Delete(sourceRef)
+	})
+	if status.Code(err) == codes.NotFound {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign: (Was one of them already merged or deleted?)",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if errors.Is(err, errGalleryLocked) {
+		data.Embeds = []*discordgo.MessageEmbed{galleryLockedEmbed(lockedName)}
+		return data
+	} else if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Str("target", targetName).Str("source", sourceName).Msg("Failed to write document contents")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to modify gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	if moved > 0 {
+		recordAuditEvent(targetName, AuditEvent{
+			Action:  auditActionReplace,
+			ActorID: interactionUserID(i),
+			Images:  images,
+		})
+	}
+	if deleted {
+		recordAuditEvent(sourceName, AuditEvent{
+			Action:  auditActionDelete,
+			ActorID: interactionUserID(i),
+		})
+		refs.apply()
+		movePendingViews(sourceName, targetName)
+		noteGalleryDeleted(sourceName)
+		postGuildAuditLog(i, fmt.Sprintf("merged gallery `%s` into `%s`, deleting it", sourceName, targetName))
+	} else {
+		postGuildAuditLog(i, fmt.Sprintf("merged gallery `%s` into `%s`", sourceName, targetName))
+	}
+
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("Merged `%s` into `%s`, adding %d images it didn't already have; `%s` now has %d :white_check_mark:", sourceName, targetName, moved, targetName, len(images)),
+		Color:       0x43b581,
+	}
+	if overLimit > 0 {
+		embed.Description += fmt.Sprintf("\n:warning: %d images didn't fit within the %d this server allows per gallery.", overLimit, maxImages)
+	}
+	if disallowed > 0 {
+		embed.Description += fmt.Sprintf("\n:warning: %d images are in formats `%s` doesn't accept.", disallowed, targetName)
+	}
+	if deleted {
+		embed.Description += fmt.Sprintf("\nDeleted `%s`, moving its schedules and categories to `%s`.", sourceName, targetName)
+	} else if deleteSource {
+		embed.Description += fmt.Sprintf("\nKept `%s`, since it still has images that weren't moved.", sourceName)
+		embed.Color = 0xfaa61a
+	}
+	log.Debug().Str("target", targetName).Str("source", sourceName).Int("moved", moved).Int("overLimit", overLimit).Int("disallowed", disallowed).Bool("deletedSource", deleted).Msg("Merged galleries")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// galleryLockedEmbed is the refusal shown when a write is attempted on a locked gallery
+func galleryLockedEmbed(galleryName string) *discordgo.MessageEmbed {
+	log.Debug().Str("gallery", galleryName).Msg("Refused to modify locked gallery")
+	return &discordgo.MessageEmbed{
+		Description: fmt.Sprintf("This gallery is locked :lock: (An administrator can run `/gallery_admin unlock` on `%s` to allow changes again.)", galleryName),
+		Color:       0xf04747,
+	}
+}
+
+// setGalleryLock locks or unlocks the chosen gallery against writes
+func setGalleryLock(i *discordgo.Interaction, locked bool) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("lock")
+	defer done()
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+
+	docRef := getGalleryDocRef(galleryName)
+	_, err := docRef.Update(ctx, []firestore.Update{{Path: "locked", Value: locked}})
+	if status.Code(err) == codes.NotFound {
+		log.Warn().Interface("interaction", i).Msg("Attempted to change lock of non-existent gallery")
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docRef", docRef).Msg("Failed to write document contents")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to modify gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	if locked {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Gallery `%s` is now locked :lock:", galleryName),
+			Color:       0x43b581,
+		}
+	} else {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Gallery `%s` is now unlocked :unlock:", galleryName),
+			Color:       0x43b581,
+		}
+	}
+	log.Debug().Bool("locked", locked).Str("gallery", galleryName).Msg("Changed lock of gallery")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// recordAuditEvent appends event to the audit subcollection of galleryName
+// Failures are logged rather than returned because the mutation being recorded has already happened.
+func recordAuditEvent(galleryName string, event AuditEvent) {
+	ctx, done := requestContext("recordAuditEvent")
+	defer done()
+	event.Timestamp = time.Now()
+	_, _, err := getGalleryDocRef(galleryName).Collection("audit").Add(ctx, event)
+	if err != nil {
+		log.Error().Err(err).Caller().Str("gallery", galleryName).Interface("event", event).Msg("Failed to record audit event")
+	}
+}
+
+// parseTimestamp accepts either Unix time or a date in one of a few human-friendly layouts (interpreted as UTC)
+func parseTimestamp(raw string) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	if unix, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if parsed, err := time.Parse(layout, raw); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("'%s' is neither Unix time nor a date like 2006-01-02 15:04", raw)
+}
+
+// parseAge accepts a Go duration like 36h, or a whole number of days or weeks like 30d or 2w
+func parseAge(raw string) (time.Duration, error) {
+	raw = strings.ToLower(strings.TrimSpace(raw))
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, err := strconv.Atoi(strings.TrimSuffix(raw, suffix)); strings.HasSuffix(raw, suffix) && err == nil && n >= 0 {
+			return time.Duration(n) * unit, nil
+		}
+	}
+	age, err := time.ParseDuration(raw)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("'%s' is not an age like 30d, 2w, or 36h", raw)
+	}
+	return age, nil
+}
+
+// replayAuditEvents reconstructs a gallery's images by applying events in order
+// The returned count of skipped events covers removals that no longer line up with the reconstructed images, which happens when the gallery predates audit logging.
+func replayAuditEvents(events []AuditEvent) (images []Image, skipped int) {
+	for _, event := range events {
+		switch event.Action {
+		case auditActionCreate, auditActionDelete:
+			images = nil
+		case auditActionAddImage:
+			if event.Image == nil {
+				skipped++
+				continue
+			}
+			images = append(images, *event.Image)
+		case auditActionRemoveImage:
+			if event.Image == nil || event.Index < 0 || event.Index >= len(images) || images[event.Index].ImageURL != event.Image.ImageURL {
+				skipped++
+				continue
+			}
+			images = append(images[:event.Index], images[event.Index+1:]...)
+		case auditActionReplace:
+			images = append([]Image(nil), event.Images...)
+		case auditActionEditImage:
+			if event.Image == nil || event.Index < 0 || event.Index >= len(images) || images[event.Index].ImageURL != event.Image.ImageURL {
+				skipped++
+				continue
+			}
+			images[event.Index] = *event.Image
+		}
+	}
+	return images, skipped
+}
+
+// auditHistoryPage shows one page of a gallery's audit events, newest first, with buttons to page through them
+// The response is ephemeral, so only the moderator who asked can page it and the buttons need no permission check of their own.
+func auditHistoryPage(i *discordgo.Interaction, galleryName string, page int) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("history")
+	defer done()
+	const pageSize = 10
+	data.Flags = messageFlagsEphemeral
+
+	if page < 0 {
+		page = 0
+	}
+	docRef := getGalleryDocRef(galleryName)
+	// One extra event tells whether there's a next page
+	docSnaps, err := docRef.Collection("audit").OrderBy("timestamp", firestore.Desc).Offset(page * pageSize).Limit(pageSize + 1).Documents(ctx).GetAll()
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Str("gallery", galleryName).Msg("Failed to retrieve audit events")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get the gallery's history :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	if len(docSnaps) == 0 {
+		description := fmt.Sprintf("Gallery `%s` has no recorded history :scroll:", galleryName)
+		if _, err := docRef.Get(ctx); status.Code(err) == codes.NotFound {
+			description = "Gallery does not exist :stop_sign:"
+		} else if page > 0 {
+			description = fmt.Sprintf("Gallery `%s` has no more history :scroll:", galleryName)
+		}
+		embed = discordgo.MessageEmbed{
+			Description: description,
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		data.Components = []discordgo.MessageComponent{}
+		return data
+	}
+	hasNext := len(docSnaps) > pageSize
+	if hasNext {
+		docSnaps = docSnaps[:pageSize]
+	}
+
+	var description strings.Builder
+	fmt.Fprintf(&description, "History of gallery `%s`, newest first :scroll:\n", galleryName)
+	for _, docSnap := range docSnaps {
+		var event AuditEvent
+		if err := docSnap.DataTo(&event); err != nil {
+			log.Warn().Err(err).Str("event", docSnap.Ref.ID).Msg("Skipped unreadable audit event")
+			continue
+		}
+		actor := "someone unknown"
+		if len(event.ActorID) > 0 {
+			actor = fmt.Sprintf("<@%s>", event.ActorID)
+		}
+		detail := ""
+		switch event.Action {
+		case auditActionAddImage, auditActionRemoveImage, auditActionEditImage:
+			detail = fmt.Sprintf(" image `%d`", event.Index)
+		case auditActionReplace:
+			detail = fmt.Sprintf(" (%d images)", len(event.Images))
+		}
+		fmt.Fprintf(&description, "\n<t:%d:f> `%s`%s by %s", event.Timestamp.Unix(), event.Action, detail, actor)
+	}
+	embed = discordgo.MessageEmbed{
+		Description: description.String(),
+		Color:       0x5865f2,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Page: %d | Gallery: %s", page+1, galleryName),
+		},
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	data.Components = []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "Previous",
+					Style:    discordgo.SecondaryButton,
+					CustomID: componentID("history_previous", galleryName, fmt.Sprint(page)),
+					Disabled: page == 0,
+				},
+				discordgo.Button{
+					Label:    "Next",
+					Style:    discordgo.SecondaryButton,
+					CustomID: componentID("history_next", galleryName, fmt.Sprint(page)),
+					Disabled: !hasNext,
+				},
+			},
+		},
+	}
+	return data
+}
+
+// respondHistoryMove turns an audit history message to the page move picks
+func respondHistoryMove(s discordSession, i *discordgo.Interaction, move int) {
+	state, ok := componentState(i, 2)
+	if !ok {
+		respondOutdatedComponent(s, i)
+		return
+	}
+	page, _ := strconv.Atoi(state[1])
+	data := auditHistoryPage(i, state[0], page+move)
+	err := respond(s, i, discordgo.InteractionResponseUpdateMessage, &data)
+	if err != nil {
+		log.Error().Err(err).Interface("interaction", i).Msg("Failure in responding to interaction")
+	}
+}
+
+// restoreGallery rebuilds a gallery as it was at a point in time from its audit events, writing the result to a new gallery so nothing is overwritten
+func restoreGallery(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("restore")
+	defer done()
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+	rawTimestamp := command.Options[1].StringValue()
+	newGalleryName := command.Options[2].StringValue()
+	if invalid := invalidGalleryNameEmbed(newGalleryName); invalid != nil {
+		data.Embeds = []*discordgo.MessageEmbed{invalid}
+		return data
+	}
+
+	target, err := parseTimestamp(rawTimestamp)
+	if err != nil {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Invalid timestamp :stop_sign: (%s)", err),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	if target.After(time.Now()) {
+		embed = discordgo.MessageEmbed{
+			Description: "Cannot restore a gallery to a point in the future :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	docSnaps, err := getGalleryDocRef(galleryName).Collection("audit").Where("timestamp", "<=", target).OrderBy("timestamp", firestore.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Str("gallery", galleryName).Msg("Failed to retrieve audit events")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get gallery history :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	if len(docSnaps) == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Gallery `%s` has no recorded history before <t:%d> :stop_sign:", galleryName, target.Unix()),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	events := make([]AuditEvent, 0, len(docSnaps))
+	for _, docSnap := range docSnaps {
+		var event AuditEvent
+		err = docSnap.DataTo(&event)
+		if err != nil {
+			log.Error().Err(err).Caller().Interface("docSnap", docSnap).Msg("Failed to retrieve audit event contents")
+			continue
+		}
+		events = append(events, event)
+	}
+	images, skipped := replayAuditEvents(events)
+
+	newDocRef := getGalleryDocRef(newGalleryName)
+	_, err = newDocRef.Create(ctx, Gallery{Images: images, CreatedAt: time.Now()})
+	if status.Code(err) == codes.AlreadyExists {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Gallery `%s` already exists :stop_sign: (Restores are always written to a new gallery.)", newGalleryName),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docRef", newDocRef).Msg("Failed to create document")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to create gallery :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	recordAuditEvent(newGalleryName, AuditEvent{
+		Action:  auditActionReplace,
+		ActorID: interactionUserID(i),
+		Images:  images,
+	})
+
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("Restored `%s` as of <t:%d> into `%s` with %d images :white_check_mark:", galleryName, target.Unix(), newGalleryName, len(images)),
+		Color:       0x43b581,
+	}
+	if events[0].Action != auditActionCreate || skipped > 0 {
+		embed.Description += "\n:warning: Part of this gallery's history predates audit logging, so the restored copy may be incomplete."
+	}
+	log.Debug().Str("gallery", galleryName).Str("newGallery", newGalleryName).Time("target", target).Int("images", len(images)).Int("skipped", skipped).Msg("Restored gallery from audit events")
+	noteGalleryCreated(newGalleryName)
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// recordView counts a serve of imageUrl from galleryName towards its "views" field
+// The count is only buffered in memory; flushViews is responsible for persisting it.
+func recordView(galleryName string, imageUrl string) {
+	pendingViewsMutex.Lock()
+	defer pendingViewsMutex.Unlock()
+	if pendingViews[galleryName] == nil {
+		pendingViews[galleryName] = make(map[string]int)
+	}
+	pendingViews[galleryName][imageUrl]++
+}
+
+// imageViews returns the persisted view count of image (0 if it has never been counted)
+func imageViews(image Image) int {
+	views, _ := strconv.Atoi(image.Views)
+	return views
+}
+
+// pickLeastViewed draws one of candidates (indices into images) with probability inversely proportional to its view count
+// Images that have never been viewed all carry the same weight, so this is uniform when no views have been counted.
+func pickLeastViewed(galleryName string, images []Image, candidates []int) int {
+	pendingViewsMutex.Lock()
+	weights := make([]float64, len(candidates))
+	total := 0.0
+	for n, v := range candidates {
+		views := imageViews(images[v]) + pendingViews[galleryName][images[v].ImageURL]
+		weights[n] = 1 / float64(views+1)
+		total += weights[n]
+	}
+	pendingViewsMutex.Unlock()
+
+	draw := rand.Float64() * total
+	for n, weight := range weights {
+		draw -= weight
+		if draw < 0 {
+			return candidates[n]
+		}
+	}
+	return candidates[len(candidates)-1] // Only reachable through floating point rounding
+}
+
+// flushViews adds all buffered view counts to their images, using one transaction per gallery so concurrent edits aren't clobbered
+// Counts belonging to galleries (or images) that no longer exist are discarded.
+func flushViews() {
+	pendingViewsMutex.Lock()
+	toFlush := pendingViews
+	pendingViews = make(map[string]map[string]int)
+	pendingViewsMutex.Unlock()
+
+	for galleryName, counts := range toFlush {
+		docRef := getGalleryDocRef(galleryName)
+		err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			docSnap, err := tx.Get(docRef)
+			if err != nil {
+				return err
+			}
+			var gallery Gallery
+			err = docSnap.DataTo(&gallery)
+			if err != nil {
+				return err
+			}
+			for n, image := range gallery.Images {
+				if count, ok := counts[image.ImageURL]; ok {
+					gallery.Images[n].Views = fmt.Sprint(imageViews(image) + count)
+				}
+			}
+			return tx.Set(docRef, gallery)
+		})
+		if status.Code(err) == codes.NotFound {
+			log.Debug().Str("gallery", galleryName).Msg("Discarded views of deleted gallery")
+		} else if err != nil {
+			log.Error().Err(err).Caller().Str("gallery", galleryName).Interface("counts", counts).Msg("Failed to write view counts")
+		}
+	}
+}
+
+// flushViewsPeriodically calls flushViews every viewFlushInterval until stop is closed, flushing one last time before returning
+func flushViewsPeriodically(stop <-chan struct{}) {
+	ticker := time.NewTicker(viewFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			flushViews()
+		case <-stop:
+			flushViews()
+			return
+		}
+	}
+}
+
+// rankByViews orders the image numbers of images from most to least viewed, counting views that have not been flushed yet
+// Ties keep gallery order. views is indexed by image number, not by rank.
+func rankByViews(galleryName string, images []Image) (indices []int, views []int) {
+	pendingViewsMutex.Lock()
+	views = make([]int, len(images))
+	indices = make([]int, len(images))
+	for n, image := range images {
+		views[n] = imageViews(image) + pendingViews[galleryName][image.ImageURL]
+		indices[n] = n
+	}
+	pendingViewsMutex.Unlock()
+	sort.SliceStable(indices, func(a, b int) bool {
+		return views[indices[a]] > views[indices[b]]
+	})
+	return indices, views
+}
+
+// getShowcase posts the three most viewed images of a gallery together, one embed each, with a medal in each footer
+// Galleries with fewer than three viewed images get a shorter showcase; images that have never been viewed don't place.
+func getShowcase(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	medals := []string{":first_place:", ":second_place:", ":third_place:"}
+	footerMedals := []string{"\U0001F947", "\U0001F948", "\U0001F949"} // Footers don't render emoji shortcodes
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+
+	gallery, problem := loadGallery(i, galleryName)
+	if problem != nil {
+		data.Embeds = []*discordgo.MessageEmbed{problem}
+		return data
+	}
+	if gallery.Disabled {
+		data.Embeds = []*discordgo.MessageEmbed{galleryDisabledEmbed(galleryName)}
+		return data
+	}
+	if len(gallery.Images) == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery is empty :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	indices, views := rankByViews(galleryName, gallery.Images)
+	for rank, n := range indices {
+		if rank == len(medals) || views[n] == 0 {
+			break
+		}
+		placed := discordgo.MessageEmbed{
+			Description: medals[rank],
+			Color:       0x5865f2,
+			Image: &discordgo.MessageEmbedImage{
+				URL: displayImageURL(gallery.Images[n].ImageURL),
+			},
+			Footer: &discordgo.MessageEmbedFooter{
+				Text: fmt.Sprintf("%s %d views | Image: %d of %d | Gallery: %s", footerMedals[rank], views[n], n, len(gallery.Images)-1, galleryName),
+			},
+		}
+		if rank == 0 {
+			placed.Description = fmt.Sprintf("%s The most viewed images in `%s` :trophy:", medals[rank], galleryName)
+		}
+		addAltTextField(&placed, gallery.Images[n])
+		data.Embeds = append(data.Embeds, &placed)
+	}
+	if len(data.Embeds) == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("No images in `%s` have been viewed yet :stop_sign:", galleryName),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	if len(data.Embeds) < len(medals) {
+		data.Embeds[0].Description += fmt.Sprintf("\nOnly %d image(s) have been viewed so far, so the podium has empty spots", len(data.Embeds))
+	}
+	log.Debug().Str("gallery", galleryName).Int("placed", len(data.Embeds)).Msg("Posted showcase")
+	return data
+}
+
+// listGalleries shows one page of every gallery with how many images it has, with buttons to page through them
+// Galleries are listed in the same order as the gallery choices, one embed field each, up to the 25 fields an embed can hold.
+func listGalleries(i *discordgo.Interaction, page int) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	const pageSize = 25
+	data.Components = []discordgo.MessageComponent{}
+
+	docSnaps, err := getAllGalleries()
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Msg("Failed to retrieve galleries")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	if len(docSnaps) == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: "There are no galleries yet :frame_photo:",
+			Color:       0x5865f2,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	pages := (len(docSnaps) + pageSize - 1) / pageSize
+	if page >= pages {
+		page = pages - 1 // Galleries may have been deleted since the previous page was shown
+	}
+	if page < 0 {
+		page = 0
+	}
+
+	last := (page + 1) * pageSize
+	if last > len(docSnaps) {
+		last = len(docSnaps)
+	}
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("There are %d galleries :frame_photo:", len(docSnaps)),
+		Color:       0x5865f2,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Page: %d of %d", page+1, pages),
+		},
+	}
+	for _, docSnap := range docSnaps[page*pageSize : last] {
+		var gallery Gallery
+		count := "?"
+		if err := docSnap.DataTo(&gallery); err != nil {
+			log.Error().Err(err).Caller().Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+		} else {
+			count = fmt.Sprint(len(gallery.Images))
+		}
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   docSnap.Ref.ID,
+			Value:  fmt.Sprintf("%s images", count),
+			Inline: true,
+		})
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	if pages > 1 {
+		data.Components = []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{
+						Label:    "Previous",
+						Style:    discordgo.SecondaryButton,
+						CustomID: componentID("gallery_list_previous", fmt.Sprint(page)),
+						Disabled: page == 0,
+					},
+					discordgo.Button{
+						Label:    "Next",
+						Style:    discordgo.SecondaryButton,
+						CustomID: componentID("gallery_list_next", fmt.Sprint(page)),
+						Disabled: page == pages-1,
+					},
+				},
+			},
+		}
+	}
+	return data
+}
+
+// respondGalleryListMove turns a gallery list message to the page move picks
+func respondGalleryListMove(s discordSession, i *discordgo.Interaction, move int) {
+	if !strings.Contains(i.MessageComponentData().CustomID, ":") {
+		respondOutdatedComponent(s, i) // The embed's fields are galleries, not state
+		return
+	}
+	state, ok := componentState(i, 1)
+	if !ok {
+		respondOutdatedComponent(s, i)
+		return
+	}
+	page, _ := strconv.Atoi(state[0])
+	data := listGalleries(i, page+move)
+	err := respond(s, i, discordgo.InteractionResponseUpdateMessage, &data)
+	if err != nil {
+		log.Error().Err(err).Interface("interaction", i).Msg("Failure in responding to interaction")
+	}
+}
+
+// getGalleryInfo sums up a gallery: its size, when it was created and last added to, who contributed, and which settings are in effect
+func getGalleryInfo(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	const maxContributorsShown = 20
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+
+	gallery, problem := loadGallery(i, galleryName)
+	if problem != nil {
+		data.Embeds = []*discordgo.MessageEmbed{problem}
+		return data
+	}
+
+	var oldest, newest int64
+	var contributors []string
+	seen := make(map[string]bool)
+	for _, image := range gallery.Images {
+		if unix, err := strconv.ParseInt(image.Timestamp, 10, 64); err == nil && unix > 0 {
+			if oldest == 0 || unix < oldest {
+				oldest = unix
+			}
+			if unix > newest {
+				newest = unix
+			}
+		}
+		if authorId := image.AuthorID; len(authorId) > 0 && !seen[authorId] {
+			seen[authorId] = true
+			contributors = append(contributors, authorId)
+		}
+	}
+	when := func(unix int64) string {
+		if unix == 0 {
+			return "Unknown"
+		}
+		return fmt.Sprintf("<t:%d:f>", unix)
+	}
+	created := int64(0)
+	if !gallery.CreatedAt.IsZero() {
+		created = gallery.CreatedAt.Unix()
+	}
+	var mentions []string
+	for n, v := range contributors {
+		if n == maxContributorsShown {
+			mentions = append(mentions, fmt.Sprintf("and %d more", len(contributors)-n))
+			break
+		}
+		if v == webhookAuthorId {
+			mentions = append(mentions, "the webhook")
+		} else {
+			mentions = append(mentions, fmt.Sprintf("<@%s>", v))
+		}
+	}
+	if len(mentions) == 0 {
+		mentions = []string{"None yet"}
+	}
+
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("About gallery `%s` :information_source:", galleryName),
+		Color:       0x5865f2,
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   "Images",
+				Value:  fmt.Sprint(len(gallery.Images)),
+				Inline: true,
+			},
+			{
+				Name:   "Created",
+				Value:  when(created),
+				Inline: true,
+			},
+			{
+				Name:   "Storage",
+				Value:  fmt.Sprintf("~%.1f KiB (%.1f%% of the limit)", float64(estimateGalleryBytes(gallery))/1024, float64(estimateGalleryBytes(gallery))*100/firestoreMaxDocumentBytes),
+				Inline: true,
+			},
+			{
+				Name:   "Oldest image",
+				Value:  when(oldest),
+				Inline: true,
+			},
+			{
+				Name:   "Latest addition",
+				Value:  when(newest),
+				Inline: true,
+			},
+			{
+				Name:  fmt.Sprintf("Contributors (%d)", len(contributors)),
+				Value: strings.Join(mentions, ", "),
+			},
+		},
+	}
+
+	var settings []string
+	if gallery.FeaturedIndex != nil {
+		settings = append(settings, fmt.Sprintf("Featured image: `%d`", *gallery.FeaturedIndex))
+	}
+	if gallery.Locked {
+		settings = append(settings, "Locked :lock:")
+	}
+	if gallery.Disabled {
+		settings = append(settings, "Disabled (every image was broken) :no_entry:")
+	}
+	if gallery.ExcludeFromSchedule {
+		settings = append(settings, "Left out of scheduled posts")
+	}
+	if len(gallery.AllowedFormats) > 0 {
+		settings = append(settings, "Only accepts "+strings.Join(gallery.AllowedFormats, ", "))
+	}
+	if len(gallery.FallbackImageURL) > 0 {
+		settings = append(settings, fmt.Sprintf("[Placeholder](%s) while empty", gallery.FallbackImageURL))
+	}
+	if len(gallery.MirrorChannelID) > 0 && (gallery.MirrorUntil.IsZero() || time.Now().Before(gallery.MirrorUntil)) {
+		settings = append(settings, fmt.Sprintf("New images mirrored to <#%s>", gallery.MirrorChannelID))
+	}
+	if !gallery.LastInteractedAt.IsZero() {
+		settings = append(settings, fmt.Sprintf("Last used <t:%d:R> by <@%s>", gallery.LastInteractedAt.Unix(), gallery.LastInteractedBy))
+	}
+	if len(gallery.CopiedFrom) > 0 {
+		settings = append(settings, fmt.Sprintf("Copied from `%s` by <@%s>", gallery.CopiedFrom, gallery.CopiedBy))
+	}
+	if len(settings) > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Settings",
+			Value: strings.Join(settings, "\n"),
+		})
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// tagStatsPage lists one page of a gallery's tags with how many images carry each, most common first, with buttons to page through them
+func tagStatsPage(i *discordgo.Interaction, galleryName string, page int) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	const pageSize = 20
+
+	gallery, problem := loadGallery(i, galleryName)
+	if problem != nil {
+		data.Embeds = []*discordgo.MessageEmbed{problem}
+		data.Components = []discordgo.MessageComponent{}
+		return data
+	}
+	numberOfImages := len(gallery.Images)
+	if numberOfImages == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery is empty :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		data.Components = []discordgo.MessageComponent{}
+		return data
+	}
+
+	counts, untagged := countTags(gallery.Images)
+	var tags []string
+	for tag := range counts {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(a, b int) bool {
+		if counts[tags[a]] != counts[tags[b]] {
+			return counts[tags[a]] > counts[tags[b]]
+		}
+		return tags[a] < tags[b]
+	})
+	pages := (len(tags) + pageSize - 1) / pageSize
+	if pages == 0 {
+		pages = 1 // Still a page, to show the untagged count on
+	}
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+
+	var description strings.Builder
+	fmt.Fprintf(&description, "Tags in `%s` :label:\n", galleryName)
+	if len(tags) == 0 {
+		description.WriteString("\nNone of the images are tagged.")
+	}
+	last := (page + 1) * pageSize
+	if last > len(tags) {
+		last = len(tags)
+	}
+	for _, tag := range tags[page*pageSize : last] {
+		fmt.Fprintf(&description, "\n`%s`: %d (%.1f%%)", tag, counts[tag], 100*float64(counts[tag])/float64(numberOfImages))
+	}
+	fmt.Fprintf(&description, "\n\nUntagged: %d (%.1f%%)", untagged, 100*float64(untagged)/float64(numberOfImages))
+	embed = discordgo.MessageEmbed{
+		Description: description.String(),
+		Color:       0x5865f2,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Page: %d of %d | Gallery: %s", page+1, pages, galleryName),
+		},
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	data.Components = []discordgo.MessageComponent{}
+	if pages > 1 {
+		data.Components = []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{
+						Label:    "Previous",
+						Style:    discordgo.SecondaryButton,
+						CustomID: componentID("tag_stats_previous", galleryName, fmt.Sprint(page)),
+						Disabled: page == 0,
+					},
+					discordgo.Button{
+						Label:    "Next",
+						Style:    discordgo.SecondaryButton,
+						CustomID: componentID("tag_stats_next", galleryName, fmt.Sprint(page)),
+						Disabled: page == pages-1,
+					},
+				},
+			},
+		}
+	}
+	return data
+}
+
+// respondTagStatsMove turns a tag statistics message to the page move picks
+func respondTagStatsMove(s discordSession, i *discordgo.Interaction, move int) {
+	state, ok := componentState(i, 2)
+	if !ok {
+		respondOutdatedComponent(s, i)
+		return
+	}
+	page, _ := strconv.Atoi(state[1])
+	data := tagStatsPage(i, state[0], page+move)
+	err := respond(s, i, discordgo.InteractionResponseUpdateMessage, &data)
+	if err != nil {
+		log.Error().Err(err).Interface("interaction", i).Msg("Failure in responding to interaction")
+	}
+}
+
+// getPopularImages lists the most viewed images of a gallery, including views that have not been flushed yet
+func getPopularImages(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("popular")
+	defer done()
+	const maxListed = 10
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+
+	docRef := getGalleryDocRef(galleryName)
+	docSnap, err := docRef.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	var gallery Gallery
+	if err == nil {
+		err = docSnap.DataTo(&gallery)
+	}
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	if gallery.Disabled {
+		data.Embeds = []*discordgo.MessageEmbed{galleryDisabledEmbed(galleryName)}
+		return data
+	}
+
+	indices, views := rankByViews(galleryName, gallery.Images)
+	if len(indices) == 0 || views[indices[0]] == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("No images in `%s` have been viewed yet :stop_sign:", galleryName),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	var description strings.Builder
+	fmt.Fprintf(&description, "Most viewed images in `%s` :eyes:\n", galleryName)
+	for rank, n := range indices {
+		if rank == maxListed || views[n] == 0 {
+			break
+		}
+		fmt.Fprintf(&description, "\n**%d.** Image `%d` (%d views)", rank+1, n, views[n])
+	}
+	embed = discordgo.MessageEmbed{
+		Description: description.String(),
+		Color:       0x5865f2,
+		Image: &discordgo.MessageEmbedImage{
+			URL: displayImageURL(gallery.Images[indices[0]].ImageURL),
+		},
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Image: %d of %d | Gallery: %s", indices[0], len(gallery.Images)-1, galleryName),
+		},
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// getGalleryTimeline counts the images added to a gallery on each day (UTC), optionally drawing the counts as a bar chart
+// Only the most recent days with additions are listed individually; images without a usable timestamp are counted as unknown.
+func getGalleryTimeline(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("timeline")
+	defer done()
+	const maxDays = 30
+	const maxBarWidth = 20
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+	chart := false
+	if option := findOption(command.Options, "chart"); option != nil {
+		chart = option.BoolValue()
+	}
+
+	docRef := getGalleryDocRef(galleryName)
+	docSnap, err := docRef.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	var gallery Gallery
+	if err == nil {
+		err = docSnap.DataTo(&gallery)
+	}
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	if len(gallery.Images) == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery is empty :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	counts := make(map[string]int)
+	unknown := 0
+	for _, image := range gallery.Images {
+		unix, err := strconv.ParseInt(image.Timestamp, 10, 64)
+		if err != nil || unix <= 0 {
+			unknown++
+			continue
+		}
+		counts[time.Unix(unix, 0).UTC().Format("2006-01-02")]++
+	}
+	days := make([]string, 0, len(counts))
+	mostInADay := unknown
+	for day, count := range counts {
+		days = append(days, day)
+		if count > mostInADay {
+			mostInADay = count
+		}
+	}
+	sort.Strings(days)
+
+	var description strings.Builder
+	fmt.Fprintf(&description, "Images added to `%s` by day :calendar_spiral:\n", galleryName)
+	if len(days) > maxDays {
+		earlier := 0
+		for _, day := range days[:len(days)-maxDays] {
+			earlier += counts[day]
+		}
+		fmt.Fprintf(&description, "\n%d images were added on %d earlier days.", earlier, len(days)-maxDays)
+		days = days[len(days)-maxDays:]
+	}
+	description.WriteString("\n```\n")
+	line := func(label string, count int) {
+		fmt.Fprintf(&description, "%-10s %4d", label, count)
+		if chart {
+			width := count * maxBarWidth / mostInADay
+			if width == 0 {
+				width = 1
+			}
+			description.WriteString(" " + strings.Repeat("█", width))
+		}
+		description.WriteString("\n")
+	}
+	for _, day := range days {
+		line(day, counts[day])
+	}
+	if unknown > 0 {
+		line("unknown", unknown)
+	}
+	description.WriteString("```")
+
+	embed = discordgo.MessageEmbed{
+		Description: description.String(),
+		Color:       0x5865f2,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Images: %d | Gallery: %s", len(gallery.Images), galleryName),
+		},
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// getHelp describes every subcommand, straight from the command definitions so it never goes stale
+func getHelp(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var description strings.Builder
+	for _, command := range enabledCommandDefinitions() {
+		fmt.Fprintf(&description, "**/%s** %s\n", command.Name, command.Description)
+		for _, v := range command.Options {
+			if v.Type != discordgo.ApplicationCommandOptionSubCommand {
+				continue
+			}
+			fmt.Fprintf(&description, "`%s`: %s\n", v.Name, v.Description)
+		}
+		description.WriteString("\n")
+	}
+	embed := discordgo.MessageEmbed{
+		Description: description.String(),
+		Color:       0x5865f2,
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	data.Flags = messageFlagsEphemeral
+	return data
+}
+
+// invalidSubcommandResponse handles a subcommand that no case of its command's handler recognizes
+// This should only happen when the commands registered with Discord have drifted from the definitions in commands, or when a defined subcommand is missing its handler case, so it logs enough to tell those apart.
+func invalidSubcommandResponse(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	commandData := i.ApplicationCommandData()
+	subcommandName := ""
+	if len(commandData.Options) > 0 {
+		subcommandName = commandData.Options[0].Name
+	}
+
+	var definedSubcommands []string
+	isDefined := false
+	for _, command := range commands {
+		if command.Name != commandData.Name {
+			continue
+		}
+		for _, v := range command.Options {
+			definedSubcommands = append(definedSubcommands, v.Name)
+			if v.Name == subcommandName {
+				isDefined = true
+			}
+		}
+	}
+	event := log.Warn()
+	if isDefined {
+		event = log.Error() // The definition exists, so the handler is what's missing
+	}
+	event.Interface("interaction", i).Str("command", commandData.Name).Str("commandId", commandData.ID).Str("subcommand", subcommandName).Strs("definedSubcommands", definedSubcommands).Bool("isDefined", isDefined).Msg("Non-existent subcommand invoked")
+
+	embed := discordgo.MessageEmbed{
+		Description: unknownSubcommandMessage,
+		Color:       0xf04747,
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	data.Flags = messageFlagsEphemeral
+	return data
+}
+
+// validateImageURL checks that imageUrl is an absolute http(s) URL
+func validateImageURL(imageUrl string) error {
+	parsed, err := url.ParseRequestURI(imageUrl)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("scheme must be http or https, not '%s'", parsed.Scheme)
+	}
+	if len(parsed.Host) == 0 {
+		return errors.New("host is missing")
+	}
+	return nil
+}
+
+// checkImageReachable issues a HEAD request for imageUrl and checks that it answers successfully with an image
+func checkImageReachable(imageUrl string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, imageUrl, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := reachabilityHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the host answered with %s", resp.Status)
+	}
+	contentType := strings.ToLower(strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0]))
+	if !strings.HasPrefix(contentType, "image/") {
+		if len(contentType) == 0 {
+			return errors.New("the host didn't say what it is")
+		}
+		return fmt.Errorf("the host says it's %s, not an image", contentType)
+	}
+	return nil
+}
+
+// imageFormats maps the file extensions and content subtypes of common image formats to the name each format goes by in AllowedFormats
+var imageFormats = map[string]string{
+	"png":  "png",
+	"jpg":  "jpg",
+	"jpeg": "jpg",
+	"gif":  "gif",
+	"webp": "webp",
+}
+
+// hasImageExtension reports whether the path of imageUrl ends in a common image file extension
+func hasImageExtension(imageUrl string) bool {
+	return len(imageExtensionFormat(imageUrl)) > 0
+}
+
+// imageExtensionFormat names the image format that imageUrl's file extension indicates, or returns "" if it doesn't indicate one
+func imageExtensionFormat(imageUrl string) string {
+	parsed, err := url.Parse(imageUrl)
+	if err != nil {
+		return ""
+	}
+	return imageFormats[strings.TrimPrefix(strings.ToLower(path.Ext(parsed.Path)), ".")]
+}
+
+// detectImageFormat names the format of the image at imageUrl, going by its extension or else the content type the host reports
+// It returns "" if neither gives it away.
+func detectImageFormat(imageUrl string) string {
+	if format := imageExtensionFormat(imageUrl); len(format) > 0 {
+		return format
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, imageUrl, nil)
+	if err != nil {
+		return ""
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return ""
+	}
+	resp.Body.Close()
+	contentType := strings.ToLower(strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0]))
+	if !strings.HasPrefix(contentType, "image/") {
+		return ""
+	}
+	return imageFormats[strings.TrimPrefix(contentType, "image/")]
+}
+
+// formatAllowed reports whether an image of the given format may be added to gallery
+func formatAllowed(gallery Gallery, format string) bool {
+	if len(gallery.AllowedFormats) == 0 {
+		return true
+	}
+	for _, v := range gallery.AllowedFormats {
+		if v == format {
+			return true
+		}
+	}
+	return false
+}
+
+// messageImageURLs extracts the URLs of all images attached to or embedded in m
+func messageImageURLs(m *discordgo.Message) (imageUrls []string) {
+	for _, attachment := range m.Attachments {
+		if hasImageExtension(attachment.URL) {
+			imageUrls = append(imageUrls, attachment.URL)
+		}
+	}
+	for _, embed := range m.Embeds {
+		if embed.Image != nil && len(embed.Image.URL) > 0 {
+			imageUrls = append(imageUrls, embed.Image.URL)
+		} else if embed.Type == discordgo.EmbedTypeImage && embed.Thumbnail != nil && len(embed.Thumbnail.URL) > 0 {
+			imageUrls = append(imageUrls, embed.Thumbnail.URL) // Links that Discord unfurls into an image only carry it as a thumbnail
+		}
+	}
+	return imageUrls
+}
+
+// importChannelImages seeds a gallery with the images posted in a channel, oldest first, crediting each to whoever posted it
+// Scanning stops after importChannelMaxMessages messages or importChannelMaxImages new images, whichever comes first. Images already in the gallery are skipped.
+func importChannelImages(session discordSession, i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+	channelId := command.Options[1].ChannelValue(nil).ID
+
+	docRef := getGalleryDocRef(galleryName)
+	getCtx, done := requestContext("import_channel")
+	docSnap, err := docRef.Get(getCtx)
+	done()
+	if status.Code(err) == codes.NotFound {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	var gallery Gallery
+	if err == nil {
+		err = docSnap.DataTo(&gallery)
+	}
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	if gallery.Locked {
+		data.Embeds = []*discordgo.MessageEmbed{galleryLockedEmbed(galleryName)}
+		return data
+	}
+	existing := make(map[string]bool)
+	for _, image := range gallery.Images {
+		existing[normalizeImageURL(image.ImageURL)] = true
+	}
+
+	// Messages arrive newest first, so collect everything before adding in chronological order
+	progress := newProgressReporter(session, i, fmt.Sprintf("Importing images from <#%s> :inbox_tray:", channelId))
+	var found []Image
+	var scanned, skipped, invalid int
+	beforeId := ""
+	for scanned < importChannelMaxMessages && len(found) < importChannelMaxImages {
+		progress.Update(fmt.Sprintf("Scanned %d messages and found %d new images...", scanned, len(found)))
+		messages, err := s.ChannelMessages(channelId, 100, beforeId, "", "")
+		if err != nil {
+			log.Error().Err(err).Caller().Interface("interaction", i).Str("channelId", channelId).Msg("Failed to retrieve channel messages")
+			embed = discordgo.MessageEmbed{
+				Description: fmt.Sprintf("Unable to read the history of <#%s> :stop_sign: (Can I view that channel and read its history?)", channelId),
+				Color:       0xf04747,
+			}
+			data.Embeds = []*discordgo.MessageEmbed{&embed}
+			return data
+		}
+		if len(messages) == 0 {
+			break
+		}
+		for _, m := range messages {
+			scanned++
+			if m.Author == nil || m.Author.Bot {
+				continue
+			}
+			timestamp := ""
+			if !m.Timestamp.IsZero() {
+				timestamp = fmt.Sprint(m.Timestamp.Unix())
+			}
+			for _, imageUrl := range messageImageURLs(m) {
+				if validateImageURL(imageUrl) != nil {
+					invalid++
+					continue
+				}
+				normalized := normalizeImageURL(imageUrl)
+				if existing[normalized] {
+					skipped++
+					continue
+				}
+				existing[normalized] = true
+				found = append(found, Image{
+					ImageURL:        imageUrl,
+					Timestamp:       timestamp,
+					AuthorID:        m.Author.ID,
+					SourceMessageID: m.ID,
+				})
+			}
+		}
+		beforeId = messages[len(messages)-1].ID
+	}
+	if len(found) > importChannelMaxImages {
+		found = found[:importChannelMaxImages]
+	}
+
+	// Screen the images the way addImage does, oldest first, before the transaction since this may download them
+	var candidates []Image
+	var candidateFormats []string
+	var banned int
+	restrictsFormats := galleryRestrictsFormats(docRef)
+	for n := len(found) - 1; n >= 0; n-- {
+		progress.Update(fmt.Sprintf("Checking image %d of %d...", len(found)-n, len(found)))
+		image := found[n]
+		err = screenImage(&image)
+		if errors.Is(err, errImageBanned) {
+			banned++
+			continue
+		} else if err != nil {
+			log.Error().Err(err).Caller().Interface("interaction", i).Msg("Failed to check imported image against the bans")
+			embed = discordgo.MessageEmbed{
+				Description: "Unable to check the images against the bans :stop_sign:",
+				Color:       0xf04747,
+			}
+			data.Embeds = []*discordgo.MessageEmbed{&embed}
+			return data
+		}
+		format := ""
+		if restrictsFormats {
+			format = detectImageFormat(image.ImageURL)
+		}
+		candidates = append(candidates, image)
+		candidateFormats = append(candidateFormats, format)
+	}
+
+	// Scanning can take a while, so merge into the gallery as it is now rather than as it was when the import started
+	maxImages := lookupGuildConfig(i.GuildID).MaxImagesPerGallery
+	var imported, overLimit, disallowed int
+	if len(candidates) > 0 {
+		var images []Image
+		ctx, done := requestContext("import_channel")
+		defer done()
+		err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			docSnap, err := tx.Get(docRef)
+			if err != nil {
+				return err
+			}
+			var current Gallery
+			err = docSnap.DataTo(&current)
+			if err != nil {
+				return err
+			}
+			if current.Locked {
+				return errGalleryLocked
+			}
+			imported, overLimit, disallowed = 0, 0, 0
+			for n, image := range candidates {
+				if maxImages > 0 && len(current.Images) >= maxImages {
+					overLimit = len(candidates) - n
+					break
+				}
+				if len(current.AllowedFormats) > 0 {
+					format := candidateFormats[n]
+					if !restrictsFormats {
+						// The formats were restricted while checking, so go by the extension alone
+						format = imageExtensionFormat(image.ImageURL)
+					}
+					if !formatAllowed(current, format) {
+						disallowed++
+						continue
+					}
+				}
+				current.Images = append(current.Images, image)
+				imported++
+			}
+			images = current.Images
+			if imported == 0 {
+				return nil
+			}
+			current.Disabled = false // As in addImage
+			return tx.Set(docRef, current)
+		})
+		if status.Code(err) == codes.NotFound {
+			embed = discordgo.MessageEmbed{
+				Description: "Gallery does not exist :stop_sign:",
+				Color:       0xf04747,
+			}
+			data.Embeds = []*discordgo.MessageEmbed{&embed}
+			return data
+		} else if errors.Is(err, errGalleryLocked) {
+			data.Embeds = []*discordgo.MessageEmbed{galleryLockedEmbed(galleryName)}
+			return data
+		} else if err != nil {
+			log.Error().Err(err).Caller().Interface("interaction", i).Interface("DocRef", docRef).Msg("Failed to write document contents")
+			embed = discordgo.MessageEmbed{
+				Description: "Unable to modify gallery contents :stop_sign:",
+				Color:       0xf04747,
+			}
+			data.Embeds = []*discordgo.MessageEmbed{&embed}
+			return data
+		}
+		if imported > 0 {
+			recordAuditEvent(galleryName, AuditEvent{
+				Action:  auditActionReplace,
+				ActorID: interactionUserID(i),
+				Images:  images,
+			})
+		}
+	}
+
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("Imported %d images from <#%s> into `%s` :white_check_mark:", imported, channelId, galleryName),
+		Color:       0x43b581,
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   "Messages scanned",
+				Value:  fmt.Sprint(scanned),
+				Inline: true,
+			},
+			{
+				Name:   "Already in gallery",
+				Value:  fmt.Sprint(skipped),
+				Inline: true,
+			},
+			{
+				Name:   "Invalid links",
+				Value:  fmt.Sprint(invalid),
+				Inline: true,
+			},
+		},
+	}
+	for _, v := range []struct {
+		name  string
+		count int
+	}{{"Banned", banned}, {"Format not allowed", disallowed}, {"Over the gallery's limit", overLimit}} {
+		if v.count > 0 {
+			embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+				Name:   v.name,
+				Value:  fmt.Sprint(v.count),
+				Inline: true,
+			})
+		}
+	}
+	if len(found) == importChannelMaxImages || scanned >= importChannelMaxMessages {
+		embed.Description += fmt.Sprintf("\n:warning: Stopped at the import limit (%d images or %d messages).", importChannelMaxImages, importChannelMaxMessages)
+	}
+	log.Debug().Str("gallery", galleryName).Str("channelId", channelId).Int("imported", imported).Int("scanned", scanned).Msg("Imported images from channel history")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// interactionUserID returns the ID of whoever invoked i, whether it came from a guild or a DM
+func interactionUserID(i *discordgo.Interaction) string {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User.ID
+	}
+	if i.User != nil {
+		return i.User.ID
+	}
+	return ""
+}
+
+// getUserGalleries lists every gallery the chosen user (or the invoking user, by default) has added images to, along with how many
+func getUserGalleries(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+
+	command := i.ApplicationCommandData().Options[0]
+	userId := interactionUserID(i)
+	if option := findOption(command.Options, "user"); option != nil {
+		userId = option.UserValue(nil).ID
+	}
+
+	docSnaps, err := getAllGalleries()
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Msg("Failed to retrieve galleries")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	type contribution struct {
+		galleryName string
+		count       int
+	}
+	var contributions []contribution
+	total := 0
+	for _, docSnap := range docSnaps {
+		var gallery Gallery
+		err = docSnap.DataTo(&gallery)
+		if err != nil {
+			log.Error().Err(err).Caller().Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+			continue
+		}
+		count := 0
+		for _, image := range gallery.Images {
+			if image.AuthorID == userId {
+				count++
+			}
+		}
+		if count > 0 {
+			contributions = append(contributions, contribution{galleryName: docSnap.Ref.ID, count: count})
+			total += count
+		}
+	}
+	if len(contributions) == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("<@%s> hasn't added images to any galleries yet", userId),
+			Color:       0x5865f2,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	sort.SliceStable(contributions, func(a, b int) bool {
+		return contributions[a].count > contributions[b].count
+	})
+
+	var description strings.Builder
+	fmt.Fprintf(&description, "<@%s> has added %d images across %d galleries :frame_photo:\n", userId, total, len(contributions))
+	for _, v := range contributions {
+		fmt.Fprintf(&description, "\n`%s`: %d", v.galleryName, v.count)
+	}
+	embed = discordgo.MessageEmbed{
+		Description: description.String(),
+		Color:       0x5865f2,
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// respondFeatureDisabled rejects an invocation of a subcommand that has been disabled but is still registered (e.g. from before a restart)
+func respondFeatureDisabled(s discordSession, i *discordgo.Interaction) {
+	log.Warn().Interface("interaction", i).Msg("Disabled subcommand invoked")
+	embed := discordgo.MessageEmbed{
+		Description: "That command is disabled on this server :stop_sign:",
+		Color:       0xf04747,
+	}
+	err := respond(s, i, discordgo.InteractionResponseChannelMessageWithSource, &discordgo.InteractionResponseData{
+		Embeds: []*discordgo.MessageEmbed{&embed},
+		Flags:  messageFlagsEphemeral,
+	})
+	if err != nil {
+		log.Error().Err(err).Interface("interaction", i).Msg("Failure in responding to interaction")
+	}
+}
+
+// noteGalleryActivity records userId as the last user to interact with galleryName
+// Activity is written at most once per activityUpdateInterval for each gallery, so the recorded user and time can lag slightly behind.
+func noteGalleryActivity(galleryName string, userId string) {
+	ctx, done := requestContext("noteGalleryActivity")
+	defer done()
+	now := time.Now()
+	activityUpdatesMutex.Lock()
+	if now.Sub(activityUpdates[galleryName]) < activityUpdateInterval {
+		activityUpdatesMutex.Unlock()
+		return
+	}
+	activityUpdates[galleryName] = now
+	activityUpdatesMutex.Unlock()
+
+	_, err := getGalleryDocRef(galleryName).Update(ctx, []firestore.Update{
+		{Path: "lastInteractedBy", Value: userId},
+		{Path: "lastInteractedAt", Value: now},
+	})
+	if err != nil && status.Code(err) != codes.NotFound {
+		log.Warn().Err(err).Str("gallery", galleryName).Msg("Failed to record gallery activity")
+	}
+}
+
+// getGalleryActivity lists every gallery along with who last interacted with it and when, most recently active first
+func getGalleryActivity(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+
+	docSnaps, err := getAllGalleries()
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Msg("Failed to retrieve galleries")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	type galleryActivity struct {
+		Name string
+		By   string
+		At   time.Time
+	}
+	var activity []galleryActivity
+	for _, docSnap := range docSnaps {
+		var gallery Gallery
+		err = docSnap.DataTo(&gallery)
+		if err != nil {
+			log.Error().Err(err).Caller().Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+			continue
+		}
+		activity = append(activity, galleryActivity{Name: docSnap.Ref.ID, By: gallery.LastInteractedBy, At: gallery.LastInteractedAt})
+	}
+	sort.SliceStable(activity, func(a, b int) bool {
+		return activity[a].At.After(activity[b].At)
+	})
+
+	var description strings.Builder
+	description.WriteString("Latest activity in each gallery :clock3:\n")
+	for _, v := range activity {
+		if v.At.IsZero() || len(v.By) == 0 {
+			fmt.Fprintf(&description, "\n`%s`: no recorded activity", v.Name)
+		} else {
+			fmt.Fprintf(&description, "\n`%s`: <@%s> <t:%d:R>", v.Name, v.By, v.At.Unix())
+		}
+	}
+	embed = discordgo.MessageEmbed{
+		Description: description.String(),
+		Color:       0x5865f2,
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// getGallerySizes ranks the galleries by image count, listing the largest and smallest few so empty or bloated galleries stand out
+func getGallerySizes(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+
+	command := i.ApplicationCommandData().Options[0]
+	listed := 5
+	if option := findOption(command.Options, "count"); option != nil {
+		listed = int(option.IntValue())
+	}
+	if listed < 1 {
+		embed = discordgo.MessageEmbed{
+			Description: "The number of galleries to list must be at least 1 :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	docSnaps, err := getAllGalleries()
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Msg("Failed to retrieve galleries")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	storage := false
+	if option := findOption(command.Options, "storage"); option != nil {
+		storage = option.BoolValue()
+	}
+
+	type gallerySize struct {
+		Name  string
+		Count int
+		Bytes int
+	}
+	var sizes []gallerySize
+	total := 0
+	nearLimit := 0
+	for _, docSnap := range docSnaps {
+		var gallery Gallery
+		err = docSnap.DataTo(&gallery)
+		if err != nil {
+			log.Error().Err(err).Caller().Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+			continue
+		}
+		size := gallerySize{Name: docSnap.Ref.ID, Count: len(gallery.Images), Bytes: estimateGalleryBytes(gallery)}
+		if size.Bytes*100 >= firestoreMaxDocumentBytes*storageWarnPercent {
+			nearLimit++
+		}
+		sizes = append(sizes, size)
+		total += len(gallery.Images)
+	}
+	if len(sizes) == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: "There are no galleries yet :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	sort.SliceStable(sizes, func(a, b int) bool {
+		if storage {
+			return sizes[a].Bytes > sizes[b].Bytes
+		}
+		return sizes[a].Count > sizes[b].Count
+	})
+	line := func(rank int, v gallerySize) string {
+		if !storage {
+			return fmt.Sprintf("\n**%d.** `%s`: %d", rank+1, v.Name, v.Count)
+		}
+		percent := float64(v.Bytes) * 100 / firestoreMaxDocumentBytes
+		warning := ""
+		if v.Bytes*100 >= firestoreMaxDocumentBytes*storageWarnPercent {
+			warning = " :warning:"
+		}
+		return fmt.Sprintf("\n**%d.** `%s`: ~%.1f KiB (%.1f%% of the limit)%s", rank+1, v.Name, float64(v.Bytes)/1024, percent, warning)
+	}
+
+	var description strings.Builder
+	fmt.Fprintf(&description, "%d images across %d galleries :bar_chart:\n", total, len(sizes))
+	if storage && nearLimit > 0 {
+		fmt.Fprintf(&description, "%d galleries are over %d%% of Firestore's 1 MiB document limit; once one reaches it, adding to it will fail\n", nearLimit, storageWarnPercent)
+	}
+	if len(sizes) <= 2*listed {
+		// The top and bottom would overlap, so just list everything
+		for rank, v := range sizes {
+			description.WriteString(line(rank, v))
+		}
+	} else {
+		description.WriteString("\n**Largest**")
+		for rank, v := range sizes[:listed] {
+			description.WriteString(line(rank, v))
+		}
+		description.WriteString("\n\n**Smallest**")
+		for rank := len(sizes) - listed; rank < len(sizes); rank++ {
+			description.WriteString(line(rank, sizes[rank]))
+		}
+	}
+	embed = discordgo.MessageEmbed{
+		Description: description.String(),
+		Color:       0x5865f2,
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// firestoreMaxDocumentBytes is the most Firestore will store in one document, and so in one gallery
+const firestoreMaxDocumentBytes = 1024 * 1024
+
+// estimateGalleryBytes approximates how much of a Firestore document gallery takes up
+// Firestore counts bytes a little differently than JSON, but the two track each other closely enough to warn well before the limit.
+func estimateGalleryBytes(gallery Gallery) int {
+	encoded, err := json.Marshal(gallery)
+	if err != nil {
+		return 0
+	}
+	return len(encoded)
+}
+
+// targetsExistingGallery reports whether a subcommand operates on a gallery that must already exist
+func targetsExistingGallery(subcommand *discordgo.ApplicationCommandInteractionDataOption) bool {
+	if subcommand.Name == "create" {
+		return false
+	}
+	for _, option := range subcommand.Options {
+		if galleryNameOptionNames[option.Name] {
+			return true
+		}
+	}
+	return false
+}
+
+// respondNoGalleries explains that there is nothing to act on yet and how to make the first gallery
+func respondNoGalleries(s discordSession, i *discordgo.Interaction) {
+	embed := discordgo.MessageEmbed{
+		Title:       "There are no galleries yet :frame_photo:",
+		Description: "Create the first one with `/gallery create gallery_name:<name>`, then add images to it with `/gallery add_image`.",
+		Color:       0x5865f2,
+	}
+	err := respond(s, i, discordgo.InteractionResponseChannelMessageWithSource, &discordgo.InteractionResponseData{
+		Embeds: []*discordgo.MessageEmbed{&embed},
+		Flags:  messageFlagsEphemeral,
+	})
+	if err != nil {
+		log.Error().Err(err).Interface("interaction", i).Msg("Failure in responding to interaction")
+	}
+}
+
+// scheduleResponseDeletion deletes the response to i once the delay configured for subcommand in autoDeleteResponses has passed
+// Nothing happens for subcommands without a configured delay.
+func scheduleResponseDeletion(s discordSession, i *discordgo.Interaction, subcommand string) {
+	delay, ok := autoDeleteDelays[subcommand]
+	if !ok {
+		return
+	}
+	time.AfterFunc(delay, func() {
+		err := s.InteractionResponseDelete(applicationID(s), i)
+		if err != nil {
+			log.Warn().Err(err).Interface("interaction", i).Msg("Failed to automatically delete response")
+		}
+	})
+}
+
+// dedupeSurvivors groups images whose links have the same key (normalizeImageURL, or canonicalImageURL for loose matching), returning for each image the index of the copy its group keeps
+// An https link is kept over any other, and otherwise the earliest added copy is. Images without a timestamp are treated as newer than any with one.
+func dedupeSurvivors(images []Image, key func(string) string) (survivors []int) {
+	best := make(map[string]int) // Key to the index of the copy being kept
+	for n, image := range images {
+		k := key(image.ImageURL)
+		current, seen := best[k]
+		if !seen || betterDuplicate(image, images[current]) {
+			best[k] = n
+		}
+	}
+	survivors = make([]int, len(images))
+	for n, image := range images {
+		survivors[n] = best[key(image.ImageURL)]
+	}
+	return survivors
+}
+
+// betterDuplicate reports whether a should be kept over b when they're duplicates
+func betterDuplicate(a, b Image) bool {
+	aSecure := strings.HasPrefix(strings.ToLower(a.ImageURL), "https:")
+	bSecure := strings.HasPrefix(strings.ToLower(b.ImageURL), "https:")
+	if aSecure != bSecure {
+		return aSecure
+	}
+	return imageAddedBefore(a, b)
+}
+
+// dedupeImages drops every image whose link has the same key as another's (see dedupeSurvivors), merging the others' metadata into the copy that's kept (see mergeImageMetadata)
+// The survivors keep their relative order. merged counts the survivors that gained anything.
+func dedupeImages(images []Image, key func(string) string) (kept []Image, removed int, merged int) {
+	survivors := dedupeSurvivors(images, key)
+	// Merge into copies first, since a survivor can come after the duplicates folded into it
+	merging := append([]Image(nil), images...)
+	gained := make(map[int]bool)
+	for n, image := range images {
+		if survivor := survivors[n]; survivor != n {
+			removed++
+			if mergeImageMetadata(&merging[survivor], image) {
+				gained[survivor] = true
+			}
+		}
+	}
+	for n, image := range merging {
+		if survivors[n] == n {
+			kept = append(kept, image)
+		}
+	}
+	return kept, removed, len(gained)
+}
+
+// mergeImageMetadata folds what a duplicate knows about an image into the copy being kept, reporting whether anything changed
+// Tags are combined, distinct alt text is joined (unless that would make it longer than maxAltTextLength, in which case the kept copy's stays), views are added up, the earlier timestamp wins, and anything else the kept copy lacks is filled in.
+// The kept copy's link is the preferred one, so it's never touched.
+func mergeImageMetadata(kept *Image, duplicate Image) (changed bool) {
+	if len(duplicate.Timestamp) > 0 && imageAddedBefore(duplicate, *kept) {
+		kept.Timestamp = duplicate.Timestamp
+		changed = true
+	}
+	if len(duplicate.Tags) > 0 {
+		tags := parseTags(kept.Tags)
+		combined := parseTags(kept.Tags + "," + duplicate.Tags)
+		if len(combined) > len(tags) {
+			kept.Tags = strings.Join(combined, ",")
+			changed = true
+		}
+	}
+	if len(duplicate.Alt) > 0 {
+		if len(kept.Alt) == 0 {
+			kept.Alt = duplicate.Alt
+			changed = true
+		} else if joined := kept.Alt + " / " + duplicate.Alt; !strings.Contains(kept.Alt, duplicate.Alt) && utf8.RuneCountInString(joined) <= maxAltTextLength {
+			kept.Alt = joined
+			changed = true
+		}
+	}
+	if len(duplicate.Views) > 0 {
+		kept.Views = fmt.Sprint(imageViews(*kept) + imageViews(duplicate))
+		changed = true
+	}
+	for _, field := range []struct {
+		kept      *string
+		duplicate string
+	}{
+		{&kept.AuthorID, duplicate.AuthorID},
+		{&kept.Caption, duplicate.Caption},
+		{&kept.ContentHash, duplicate.ContentHash},
+		{&kept.SourceInteractionID, duplicate.SourceInteractionID},
+		{&kept.SourceMessageID, duplicate.SourceMessageID},
+	} {
+		if len(*field.kept) == 0 && len(field.duplicate) > 0 {
+			*field.kept = field.duplicate
+			changed = true
+		}
+	}
+	return changed
+}
+
+// imageAddedBefore reports whether a was added strictly before b, going by their timestamps
+func imageAddedBefore(a, b Image) bool {
+	aTime, aErr := strconv.ParseInt(a.Timestamp, 10, 64)
+	bTime, bErr := strconv.ParseInt(b.Timestamp, 10, 64)
+	if aErr != nil {
+		return false
+	}
+	return bErr != nil || aTime < bTime
+}
+
+func dedupeGalleryPrompt(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("dedupe")
+	defer done()
+	var messageComponents []discordgo.MessageComponent
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+	loose := false
+	if option := findOption(command.Options, "loose"); option != nil {
+		loose = option.BoolValue()
+	}
+	// The matching travels with the confirmation, as its own field in case the button can't carry it (see componentState)
+	key, matching := normalizeImageURL, "exact"
+	if loose {
+		key, matching = canonicalImageURL, "loose"
+	}
+
+	docRef := getGalleryDocRef(galleryName)
+	docSnap, err := docRef.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		log.Warn().Interface("interaction", i).Msg("Attempted to dedupe non-existent gallery")
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	var gallery Gallery
+	if err == nil {
+		err = docSnap.DataTo(&gallery)
+	}
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	if gallery.Locked {
+		data.Embeds = []*discordgo.MessageEmbed{galleryLockedEmbed(galleryName)}
+		return data
+	}
+	survivors := dedupeSurvivors(gallery.Images, key)
+	removed := 0
+	for n, survivor := range survivors {
+		if survivor != n {
+			removed++
+		}
+	}
+	if removed == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Gallery `%s` has no duplicate images :white_check_mark:", galleryName),
+			Color:       0x43b581,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	embed = discordgo.MessageEmbed{
+		Description: "Are you sure you want to remove the duplicate images from the following gallery? :thinking:\nThe https copy of each image is kept if there is one, otherwise the earliest added, and image numbers will change.",
+		Color:       0x5865f2,
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   "Gallery",
+				Value:  fmt.Sprintf("`%s`", galleryName),
+				Inline: true,
+			},
+			{
+				Name:   "Matching",
+				Value:  fmt.Sprintf("`%s`", matching),
+				Inline: true,
+			},
+			{
+				Name:   "Duplicates",
+				Value:  fmt.Sprint(removed),
+				Inline: true,
+			},
+		},
+	}
+	if loose {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Groups",
+			Value: describeDuplicateGroups(survivors),
+		})
+	}
+	messageComponents = []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "Yes, remove duplicates",
+					Style:    discordgo.DangerButton,
+					CustomID: componentID("gallery_dedupe_yes", galleryName, matching),
+				},
+				discordgo.Button{
+					Label:    "No, cancel",
+					Style:    discordgo.SecondaryButton,
+					CustomID: componentID("gallery_dedupe_no", galleryName),
+				},
+			},
+		},
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	data.Components = messageComponents
+	return data
+}
+
+// describeDuplicateGroups lists which images dedupeSurvivors grouped together, marking the one each group keeps
+func describeDuplicateGroups(survivors []int) string {
+	const maxGroups = 15
+	groups := make(map[int][]string)
+	var order []int
+	for n, survivor := range survivors {
+		if len(groups[survivor]) == 0 {
+			order = append(order, survivor)
+		}
+		if n == survivor {
+			groups[survivor] = append(groups[survivor], fmt.Sprintf("**`%d`**", n))
+		} else {
+			groups[survivor] = append(groups[survivor], fmt.Sprintf("`%d`", n))
+		}
+	}
+	var lines []string
+	shown := 0
+	for _, survivor := range order {
+		if len(groups[survivor]) < 2 {
+			continue
+		}
+		if shown == maxGroups {
+			lines = append(lines, "…and more")
+			break
+		}
+		lines = append(lines, strings.Join(groups[survivor], ", "))
+		shown++
+	}
+	return strings.Join(lines, "\n") + "\n(The image in bold is kept.)"
+}
+
+// dedupeGallery removes duplicate images from a gallery inside a transaction, so images added since the prompt are accounted for
+// loose matches links by canonicalImageURL instead of normalizeImageURL.
+func dedupeGallery(i *discordgo.Interaction, galleryName string, loose bool) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("gallery_dedupe_yes")
+	defer done()
+	var kept []Image
+	var removed, merged int
+	key := normalizeImageURL
+	if loose {
+		key = canonicalImageURL
+	}
+
+	docRef := getGalleryDocRef(galleryName)
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(docRef)
+		if err != nil {
+			return err
+		}
+		var gallery Gallery
+		err = docSnap.DataTo(&gallery)
+		if err != nil {
+			return err
+		}
+		if gallery.Locked {
+			return errGalleryLocked
+		}
+		kept, removed, merged = dedupeImages(gallery.Images, key)
+		gallery.FeaturedIndex = featuredIndexAfterRewrite(gallery.Images, kept, gallery.FeaturedIndex)
+		gallery.Images = kept
+		return tx.Set(docRef, gallery)
+	})
+	if status.Code(err) == codes.NotFound {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if errors.Is(err, errGalleryLocked) {
+		data.Embeds = []*discordgo.MessageEmbed{galleryLockedEmbed(galleryName)}
+		return data
+	} else if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docRef", docRef).Msg("Failed to write document contents")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to modify gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	if removed > 0 {
+		recordAuditEvent(galleryName, AuditEvent{
+			Action:  auditActionReplace,
+			ActorID: interactionUserID(i),
+			Images:  kept,
+		})
+	}
+
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("Removed %d duplicate images from `%s`, leaving %d :white_check_mark:", removed, galleryName, len(kept)),
+		Color:       0x43b581,
+	}
+	if merged > 0 {
+		embed.Description += fmt.Sprintf("\nMerged tags, alt text, and views from the duplicates into %d of the remaining images.", merged)
+	}
+	log.Debug().Str("gallery", galleryName).Int("removed", removed).Int("merged", merged).Msg("Removed duplicate images from gallery")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// featuredIndexAfterRewrite finds where the featured image ended up after a gallery's images were rewritten in bulk, matching on normalized URL
+// It returns nil if nothing was featured or the featured image no longer exists.
+func featuredIndexAfterRewrite(before []Image, after []Image, featuredIndex *int) *int {
+	if featuredIndex == nil || *featuredIndex < 0 || *featuredIndex >= len(before) {
+		return nil
+	}
+	featuredUrl := normalizeImageURL(before[*featuredIndex].ImageURL)
+	for n, image := range after {
+		if normalizeImageURL(image.ImageURL) == featuredUrl {
+			return &n
+		}
+	}
+	return nil
+}
+
+// setFeaturedImage marks an image as its gallery's featured entry
+func setFeaturedImage(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("feature")
+	defer done()
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+	imageNum := int(command.Options[1].IntValue())
+
+	docRef := getGalleryDocRef(galleryName)
+	var numberOfImages int
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(docRef)
+		if err != nil {
+			return err
+		}
+		var gallery Gallery
+		err = docSnap.DataTo(&gallery)
+		if err != nil {
+			return err
+		}
+		numberOfImages = len(gallery.Images)
+		if imageNum < 0 || imageNum >= numberOfImages {
+			return nil // Reported below, once the transaction is out of the way
+		}
+		return tx.Update(docRef, []firestore.Update{{Path: "featuredIndex", Value: imageNum}})
+	})
+	if status.Code(err) == codes.NotFound {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docRef", docRef).Msg("Failed to write document contents")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to modify gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	if numberOfImages == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery is empty :stop_sign:",
+			Color:       0xf04747,
+		}
+	} else if imageNum < 0 || imageNum >= numberOfImages {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Invalid image number :stop_sign: (Valid image numbers include 0 through %d inclusive.)", numberOfImages-1),
+			Color:       0xf04747,
+		}
+	} else {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Image `%d` is now featured in `%s` :star:", imageNum, galleryName),
+			Color:       0x43b581,
+		}
+		log.Debug().Int("imageNum", imageNum).Str("gallery", galleryName).Msg("Featured image in gallery")
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// getFeaturedImage sends a gallery's featured image
+func getFeaturedImage(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("featured")
+	defer done()
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+
+	docRef := getGalleryDocRef(galleryName)
+	docSnap, err := docRef.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	var gallery Gallery
+	if err == nil {
+		err = docSnap.DataTo(&gallery)
+	}
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	if gallery.Disabled {
+		data.Embeds = []*discordgo.MessageEmbed{galleryDisabledEmbed(galleryName)}
+		return data
+	}
+	if gallery.FeaturedIndex == nil || *gallery.FeaturedIndex < 0 || *gallery.FeaturedIndex >= len(gallery.Images) {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Gallery `%s` doesn't have a featured image :stop_sign:", galleryName),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	featuredIndex := *gallery.FeaturedIndex
+	embed = discordgo.MessageEmbed{
+		Image: &discordgo.MessageEmbedImage{
+			URL: displayImageURL(gallery.Images[featuredIndex].ImageURL),
+		},
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Featured | Image: %d of %d | Gallery: %s", featuredIndex, len(gallery.Images)-1, galleryName),
+		},
+	}
+	addAltTextField(&embed, gallery.Images[featuredIndex])
+	recordView(galleryName, gallery.Images[featuredIndex].ImageURL)
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// challengeFeaturedImage pits a gallery's featured image against a random challenger, letting an administrator keep the former or feature the latter
+func challengeFeaturedImage(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("challenge")
+	defer done()
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+
+	docRef := getGalleryDocRef(galleryName)
+	docSnap, err := docRef.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	var gallery Gallery
+	if err == nil {
+		err = docSnap.DataTo(&gallery)
+	}
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	if gallery.Disabled {
+		data.Embeds = []*discordgo.MessageEmbed{galleryDisabledEmbed(galleryName)}
+		return data
+	}
+	if gallery.FeaturedIndex == nil || *gallery.FeaturedIndex < 0 || *gallery.FeaturedIndex >= len(gallery.Images) {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Gallery `%s` doesn't have a featured image to challenge :stop_sign: (An administrator can choose one with `/gallery_admin feature`.)", galleryName),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	if len(gallery.Images) < 2 {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Gallery `%s` has no other images to challenge the featured image with :stop_sign:", galleryName),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	featuredIndex := *gallery.FeaturedIndex
+	challengerIndex := rand.Intn(len(gallery.Images) - 1)
+	if challengerIndex >= featuredIndex {
+		challengerIndex++ // Skip over the featured image itself
+	}
+	embed = discordgo.MessageEmbed{
+		Description: "Keep the featured image, or feature the challenger instead? :crossed_swords:",
+		Color:       0x5865f2,
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   "Gallery",
+				Value:  fmt.Sprintf("`%s`", galleryName),
+				Inline: true,
+			},
+			{
+				Name:   "Challenger",
+				Value:  fmt.Sprint(challengerIndex),
+				Inline: true,
+			},
+		},
+		Image: &discordgo.MessageEmbedImage{
+			URL: displayImageURL(gallery.Images[featuredIndex].ImageURL),
+		},
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Featured | Image: %d of %d | Gallery: %s", featuredIndex, len(gallery.Images)-1, galleryName),
+		},
+	}
+	challengerEmbed := discordgo.MessageEmbed{
+		Color: 0x5865f2,
+		Image: &discordgo.MessageEmbedImage{
+			URL: displayImageURL(gallery.Images[challengerIndex].ImageURL),
+		},
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Challenger | Image: %d of %d | Gallery: %s", challengerIndex, len(gallery.Images)-1, galleryName),
+		},
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed, &challengerEmbed}
+	data.Components = []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "Keep featured",
+					Style:    discordgo.SecondaryButton,
+					CustomID: componentID("featured_keep", galleryName, fmt.Sprint(challengerIndex)),
+				},
+				discordgo.Button{
+					Label:    "Feature challenger",
+					Style:    discordgo.PrimaryButton,
+					CustomID: componentID("featured_swap", galleryName, fmt.Sprint(challengerIndex)),
+				},
+			},
+		},
+	}
+	return data
+}
+
+// featureChallenger makes the challenger from a challenge the featured image, provided it is still at the same position in the gallery
+func featureChallenger(i *discordgo.Interaction, galleryName string, challengerIndex int, challengerUrl string) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("featureChallenger")
+	defer done()
+	moved := false
+
+	docRef := getGalleryDocRef(galleryName)
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(docRef)
+		if err != nil {
+			return err
+		}
+		var gallery Gallery
+		err = docSnap.DataTo(&gallery)
+		if err != nil {
+			return err
+		}
+		moved = challengerIndex < 0 || challengerIndex >= len(gallery.Images) || displayImageURL(gallery.Images[challengerIndex].ImageURL) != challengerUrl
+		if moved {
+			return nil // Reported below, once the transaction is out of the way
+		}
+		return tx.Update(docRef, []firestore.Update{{Path: "featuredIndex", Value: challengerIndex}})
+	})
+	if status.Code(err) == codes.NotFound {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+	} else if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docRef", docRef).Msg("Failed to write document contents")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to modify gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+	} else if moved {
+		embed = discordgo.MessageEmbed{
+			Description: "The gallery changed since this challenge began, so the challenger wasn't featured :stop_sign: (Start a new challenge to try again.)",
+			Color:       0xf04747,
+		}
+	} else {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Image `%d` is now featured in `%s` :star:", challengerIndex, galleryName),
+			Color:       0x43b581,
+			Image: &discordgo.MessageEmbedImage{
+				URL: challengerUrl,
+			},
+		}
+		log.Debug().Int("imageNum", challengerIndex).Str("gallery", galleryName).Msg("Featured challenger image")
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// editImage applies edit to a copy of one image in a gallery inside a transaction, recording the result as an audit event
+// It fails with errGalleryLocked for locked galleries and errInvalidImageNumber (reporting how many images there are) for out of range image numbers.
+func editImage(i *discordgo.Interaction, galleryName string, imageNum int, edit func(image *Image)) (edited Image, numberOfImages int, err error) {
+	ctx, done := requestContext("editImage")
+	defer done()
+	docRef := getGalleryDocRef(galleryName)
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(docRef)
+		if err != nil {
+			return err
+		}
+		var gallery Gallery
+		err = docSnap.DataTo(&gallery)
+		if err != nil {
+			return err
+		}
+		if gallery.Locked {
+			return errGalleryLocked
+		}
+		numberOfImages = len(gallery.Images)
+		if imageNum < 0 || imageNum >= numberOfImages {
+			return errInvalidImageNumber
+		}
+		edited = gallery.Images[imageNum]
+		edit(&edited)
+		gallery.Images[imageNum] = edited
+		return tx.Set(docRef, gallery)
+	})
+	if err != nil {
+		return Image{}, numberOfImages, err
+	}
+	recordAuditEvent(galleryName, AuditEvent{
+		Action:  auditActionEditImage,
+		ActorID: interactionUserID(i),
+		Index:   imageNum,
+		Image:   &edited,
+	})
+	return edited, numberOfImages, nil
+}
+
+// editImageErrorEmbed describes why editImage failed
+func editImageErrorEmbed(i *discordgo.Interaction, galleryName string, numberOfImages int, err error) *discordgo.MessageEmbed {
+	switch {
+	case status.Code(err) == codes.NotFound:
+		return &discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+	case errors.Is(err, errGalleryLocked):
+		return galleryLockedEmbed(galleryName)
+	case errors.Is(err, errInvalidImageNumber) && numberOfImages == 0:
+		return &discordgo.MessageEmbed{
+			Description: "Gallery is empty :stop_sign:",
+			Color:       0xf04747,
+		}
+	case errors.Is(err, errInvalidImageNumber) && numberOfImages == 1:
+		return &discordgo.MessageEmbed{
+			Description: "Invalid image number :stop_sign: (Only image number 0 exists.)",
+			Color:       0xf04747,
+		}
+	case errors.Is(err, errInvalidImageNumber):
+		return &discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Invalid image number :stop_sign: (Valid image numbers include 0 through %d inclusive.)", numberOfImages-1),
+			Color:       0xf04747,
+		}
+	}
+	log.Error().Err(err).Caller().Interface("interaction", i).Str("gallery", galleryName).Msg("Failed to write document contents")
+	return &discordgo.MessageEmbed{
+		Description: "Unable to modify gallery contents :stop_sign:",
+		Color:       0xf04747,
+	}
+}
+
+// setImageTimestamp corrects when an image is recorded as having been added
+func setImageTimestamp(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+	imageNum := int(command.Options[1].IntValue())
+	rawTimestamp := command.Options[2].StringValue()
+
+	timestamp, err := parseTimestamp(rawTimestamp)
+	if err != nil {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Invalid timestamp :stop_sign: (%s)", err),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	if timestamp.After(time.Now()) {
+		embed = discordgo.MessageEmbed{
+			Description: "An image can't have been added in the future :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	_, numberOfImages, err := editImage(i, galleryName, imageNum, func(image *Image) {
+		image.Timestamp = fmt.Sprint(timestamp.Unix())
+	})
+	if err != nil {
+		data.Embeds = []*discordgo.MessageEmbed{editImageErrorEmbed(i, galleryName, numberOfImages, err)}
+		return data
+	}
+
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("Image `%d` in `%s` is now recorded as added <t:%d> :white_check_mark:", imageNum, galleryName, timestamp.Unix()),
+		Color:       0x43b581,
+	}
+	log.Debug().Int("imageNum", imageNum).Str("gallery", galleryName).Time("timestamp", timestamp).Msg("Changed timestamp of image")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// setAltText changes (or with no alt option, removes) the description of an image
+func setAltText(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+	imageNum := int(command.Options[1].IntValue())
+	altText := ""
+	if option := findOption(command.Options, "alt"); option != nil {
+		altText = strings.TrimSpace(option.StringValue())
+	}
+	if len([]rune(altText)) > maxAltTextLength {
+		data.Embeds = []*discordgo.MessageEmbed{altTextTooLongEmbed()}
+		return data
+	}
+
+	_, numberOfImages, err := editImage(i, galleryName, imageNum, func(image *Image) {
+		image.Alt = altText
+	})
+	if err != nil {
+		data.Embeds = []*discordgo.MessageEmbed{editImageErrorEmbed(i, galleryName, numberOfImages, err)}
+		return data
+	}
+
+	if len(altText) > 0 {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Updated the description of image `%d` in `%s` :white_check_mark:", imageNum, galleryName),
+			Color:       0x43b581,
+			Fields: []*discordgo.MessageEmbedField{
+				{
+					Name:  "Description",
+					Value: altText,
+				},
+			},
+		}
+	} else {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Removed the description of image `%d` in `%s` :white_check_mark:", imageNum, galleryName),
+			Color:       0x43b581,
+		}
+	}
+	log.Debug().Int("imageNum", imageNum).Str("gallery", galleryName).Msg("Changed alt text of image")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// parseCaptionMapping reads a caption_bulk mapping file, either a JSON object or CSV rows, from image number or link to alt text
+// A CSV header row is skipped if its first column is neither a number nor a link.
+func parseCaptionMapping(fileName string, body []byte) (captions map[string]string, err error) {
+	if strings.EqualFold(path.Ext(fileName), ".json") {
+		err = json.Unmarshal(body, &captions)
+		return captions, err
+	}
+	records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	captions = make(map[string]string)
+	for n, record := range records {
+		if len(record) != 2 {
+			return nil, fmt.Errorf("row %d has %d columns instead of 2", n+1, len(record))
+		}
+		key := strings.TrimSpace(record[0])
+		if _, numErr := strconv.Atoi(key); n == 0 && numErr != nil && !strings.Contains(key, "://") {
+			continue
+		}
+		captions[key] = record[1]
+	}
+	return captions, nil
+}
+
+// setAltTextBulk sets the alt text of many images at once from a JSON or CSV file attached to a message, keyed by image number or link
+// All of the changes are made in a single write. Keys that match no image, and alt text that is too long, are counted and skipped.
+func setAltTextBulk(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+	messageLink := strings.TrimSpace(command.Options[1].StringValue())
+
+	matches := messageLinkPattern.FindStringSubmatch(messageLink)
+	if matches == nil {
+		embed = discordgo.MessageEmbed{
+			Description: "That doesn't look like a message link :stop_sign: (Use \"Copy Message Link\" on the message with the file.)",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	message, err := s.ChannelMessage(matches[1], matches[2])
+	if err != nil || len(message.Attachments) == 0 {
+		log.Debug().Err(err).Str("messageLink", messageLink).Msg("Found no caption file to read")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to find a file attached to that message :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	attachment := message.Attachments[0]
+	var body []byte
+	resp, err := httpClient.Get(attachment.URL)
+	if err == nil {
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("downloading it failed: %s", resp.Status)
+		} else {
+			// Reading one byte past the limit tells a file that's too large apart from one that's exactly at it, rather than applying only part of it
+			body, err = io.ReadAll(io.LimitReader(resp.Body, captionFileMaxBytes+1))
+			if err == nil && len(body) > captionFileMaxBytes {
+				err = fmt.Errorf("it's larger than %d KiB", captionFileMaxBytes/1024)
+			}
+		}
+		resp.Body.Close()
+	}
+	var captions map[string]string
+	if err == nil {
+		captions, err = parseCaptionMapping(attachment.Filename, body)
+	}
+	if err != nil {
+		log.Debug().Err(err).Str("attachment", attachment.URL).Msg("Failed to read caption file")
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Unable to read `%s` :stop_sign: (It should be a JSON object or two-column CSV mapping image numbers or links to descriptions.)\n`%s`", attachment.Filename, err),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+
+	var images []Image
+	var set, unmatched, tooLong int
+	// Downloading the file can take a while, so the deadline only starts once it's read
+	ctx, done := requestContext("caption_bulk")
+	defer done()
+	docRef := getGalleryDocRef(galleryName)
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(docRef)
+		if err != nil {
+			return err
+		}
+		var gallery Gallery
+		err = docSnap.DataTo(&gallery)
+		if err != nil {
+			return err
+		}
+		if gallery.Locked {
+			return errGalleryLocked
+		}
+		byUrl := make(map[string][]int)
+		for n, image := range gallery.Images {
+			normalized := normalizeImageURL(image.ImageURL)
+			byUrl[normalized] = append(byUrl[normalized], n)
+		}
+		set, unmatched, tooLong = 0, 0, 0
+		for key, altText := range captions {
+			altText = strings.TrimSpace(altText)
+			if len([]rune(altText)) > maxAltTextLength {
+				tooLong++
+				continue
+			}
+			targets := byUrl[normalizeImageURL(key)]
+			if imageNum, err := strconv.Atoi(key); err == nil && imageNum >= 0 && imageNum < len(gallery.Images) {
+				targets = []int{imageNum}
+			}
+			if len(targets) == 0 {
+				unmatched++
+				continue
+			}
+			for _, n := range targets {
+				gallery.Images[n].Alt = altText
+				set++
+			}
+		}
+		images = gallery.Images
+		if set == 0 {
+			return nil
+		}
+		return tx.Set(docRef, gallery)
+	})
+	if status.Code(err) == codes.NotFound {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if errors.Is(err, errGalleryLocked) {
+		data.Embeds = []*discordgo.MessageEmbed{galleryLockedEmbed(galleryName)}
+		return data
+	} else if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docRef", docRef).Msg("Failed to write document contents")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to modify gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	if set > 0 {
+		recordAuditEvent(galleryName, AuditEvent{
+			Action:  auditActionReplace,
+			ActorID: interactionUserID(i),
+			Images:  images,
+		})
+	}
+
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("Updated the descriptions of %d images in `%s` :white_check_mark:", set, galleryName),
+		Color:       0x43b581,
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   "Entries in file",
+				Value:  fmt.Sprint(len(captions)),
+				Inline: true,
+			},
+			{
+				Name:   "Matched no image",
+				Value:  fmt.Sprint(unmatched),
+				Inline: true,
+			},
+			{
+				Name:   fmt.Sprintf("Longer than %d characters", maxAltTextLength),
+				Value:  fmt.Sprint(tooLong),
+				Inline: true,
+			},
+		},
+	}
+	log.Debug().Str("gallery", galleryName).Int("set", set).Int("unmatched", unmatched).Int("tooLong", tooLong).Msg("Bulk set alt text")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// startPoll posts an image (random, unless one is chosen) with a reaction for each of pollReactions, and tallies the votes once the poll ends
+// Polls run on a timer, so one that is still open when the bot restarts is never tallied.
+func startPoll(s discordSession, i *discordgo.Interaction) {
+	var data discordgo.InteractionResponseData
+	var embed discordgo.MessageEmbed
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+	duration := pollDuration
+	tooLong := false
+	if option := findOption(command.Options, "minutes"); option != nil && option.IntValue() > maxPollMinutes {
+		tooLong = true
+	} else if option != nil && option.IntValue() > 0 {
+		duration = time.Duration(option.IntValue()) * time.Minute
+	}
+
+	gallery, problem := loadGallery(i, galleryName)
+	if problem == nil && gallery.Disabled {
+		problem = galleryDisabledEmbed(galleryName)
+	}
+	if problem == nil && tooLong {
+		problem = &discordgo.MessageEmbed{
+			Description: fmt.Sprintf("A poll can stay open for at most %d minutes :stop_sign:", maxPollMinutes),
+			Color:       0xf04747,
+		}
+	}
+	numberOfImages := len(gallery.Images)
+	imageNum := -1
+	if problem == nil && numberOfImages > 0 {
+		imageNum = rand.Intn(numberOfImages)
+		if option := findOption(command.Options, "image_number"); option != nil {
+			imageNum = int(option.IntValue())
+		}
+	}
+	switch {
+	case problem != nil:
+		data.Embeds = []*discordgo.MessageEmbed{problem}
+	case numberOfImages == 0:
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery is empty :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+	case imageNum < 0 || imageNum >= numberOfImages:
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Invalid image number :stop_sign: (Valid image numbers include 0 through %d inclusive.)", numberOfImages-1),
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+	default:
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Vote with %s! The poll closes <t:%d:R>. :ballot_box:", strings.Join(pollReactions, " "), time.Now().Add(duration).Unix()),
+			Color:       0x5865f2,
+			Image: &discordgo.MessageEmbedImage{
+				URL: displayImageURL(gallery.Images[imageNum].ImageURL),
+			},
+			Footer: &discordgo.MessageEmbedFooter{
+				Text: fmt.Sprintf("Image: %d of %d | Gallery: %s", imageNum, numberOfImages-1, galleryName),
+			},
+		}
+		addAltTextField(&embed, gallery.Images[imageNum])
+		recordView(galleryName, gallery.Images[imageNum].ImageURL)
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+	}
+
+	err := respond(s, i, discordgo.InteractionResponseChannelMessageWithSource, &data)
+	if err != nil {
+		log.Error().Err(err).Interface("interaction", i).Msg("Failure in responding to interaction")
+		return
+	}
+	if embed.Image == nil {
+		return // Nothing to vote on
+	}
+
+	message, err := s.InteractionResponse(applicationID(s), i)
+	if err != nil {
+		log.Error().Err(err).Interface("interaction", i).Msg("Failed to retrieve poll message")
+		return
+	}
+	for _, reaction := range pollReactions {
+		err = s.MessageReactionAdd(message.ChannelID, message.ID, reaction)
+		if err != nil {
+			log.Error().Err(err).Str("reaction", reaction).Msg("Failed to add poll reaction")
+		}
+	}
+	time.AfterFunc(duration, func() {
+		closePoll(s, message.ChannelID, message.ID, embed)
+	})
+	log.Debug().Str("gallery", galleryName).Int("imageNum", imageNum).Dur("duration", duration).Msg("Started poll")
+}
+
+// countReactions counts the users other than the bot who reacted to a message with reaction, paging through them 100 at a time
+func countReactions(s discordSession, channelId string, messageId string, reaction string) (count int, err error) {
+	afterId := ""
+	for {
+		users, err := s.MessageReactions(channelId, messageId, reaction, 100, "", afterId)
+		if err != nil {
+			return count, err
+		}
+		for _, user := range users {
+			if user.ID != applicationID(s) {
+				count++
+			}
+		}
+		if len(users) < 100 {
+			return count, nil
+		}
+		afterId = users[len(users)-1].ID
+	}
+}
+
+// closePoll tallies a poll's reactions into its message, then clears the reactions away
+func closePoll(s discordSession, channelId string, messageId string, embed discordgo.MessageEmbed) {
+	var results []string
+	for _, reaction := range pollReactions {
+		count, err := countReactions(s, channelId, messageId, reaction)
+		if err != nil {
+			log.Error().Err(err).Str("reaction", reaction).Str("messageId", messageId).Msg("Failed to count poll reactions")
+			results = append(results, fmt.Sprintf("%s ?", reaction))
+			continue
+		}
+		results = append(results, fmt.Sprintf("%s %d", reaction, count))
+	}
+
+	embed.Description = "The poll has closed :ballot_box_with_check:"
+	embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+		Name:  "Results",
+		Value: strings.Join(results, " | "),
+	})
+	fitEmbedLimits([]*discordgo.MessageEmbed{&embed})
+	_, err := s.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID:      messageId,
+		Channel: channelId,
+		Embed:   &embed,
+	})
+	if err != nil {
+		log.Error().Err(err).Str("messageId", messageId).Msg("Failed to post poll results")
+		return
+	}
+	err = s.MessageReactionsRemoveAll(channelId, messageId)
+	if err != nil {
+		log.Warn().Err(err).Str("messageId", messageId).Msg("Failed to clear poll reactions (is the Manage Messages permission missing?)")
+	}
+}
+
+// carouselPage shows one image of a gallery with buttons to step through it, jump to a random image, or pick a nearby image from a menu
+// Image numbers outside the gallery are clamped to its first or last image.
+func carouselPage(i *discordgo.Interaction, galleryName string, imageNum int) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("carousel")
+	defer done()
+	const upcomingShown = 5
+	const jumpOptions = 25 // The most options Discord allows in a select menu
+
+	docRef := getGalleryDocRef(galleryName)
+	docSnap, err := docRef.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	var gallery Gallery
+	if err == nil {
+		err = docSnap.DataTo(&gallery)
+	}
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	if gallery.Disabled {
+		data.Embeds = []*discordgo.MessageEmbed{galleryDisabledEmbed(galleryName)}
+		return data
+	}
+	numberOfImages := len(gallery.Images)
+	if numberOfImages == 0 {
+		embed = emptyGalleryEmbed(galleryName, gallery)
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	if imageNum < 0 {
+		imageNum = 0
+	} else if imageNum >= numberOfImages {
+		imageNum = numberOfImages - 1
+	}
+
+	var upcoming []string
+	for n := imageNum + 1; n < numberOfImages && len(upcoming) < upcomingShown; n++ {
+		upcoming = append(upcoming, fmt.Sprint(n))
+	}
+	footer := fmt.Sprintf("Image: %d of %d | Gallery: %s", imageNum, numberOfImages-1, galleryName)
+	if len(upcoming) > 0 {
+		footer += " | Up next: " + strings.Join(upcoming, ", ")
+		if imageNum+len(upcoming) < numberOfImages-1 {
+			footer += ", …"
+		}
+	}
+	embed = discordgo.MessageEmbed{
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   "Gallery",
+				Value:  fmt.Sprintf("`%s`", galleryName),
+				Inline: true,
+			},
+			{
+				Name:   "Image",
+				Value:  fmt.Sprint(imageNum),
+				Inline: true,
+			},
+		},
+		Image: &discordgo.MessageEmbedImage{
+			URL: displayImageURL(gallery.Images[imageNum].ImageURL),
+		},
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: footer,
+		},
+	}
+	addAltTextField(&embed, gallery.Images[imageNum])
+	recordView(galleryName, gallery.Images[imageNum].ImageURL)
+
+	// Offer the images around the current one, shifting the window when it runs into either end of the gallery
+	first := imageNum - jumpOptions/2
+	if first > numberOfImages-jumpOptions {
+		first = numberOfImages - jumpOptions
+	}
+	if first < 0 {
+		first = 0
+	}
+	var options []discordgo.SelectMenuOption
+	for n := first; n < numberOfImages && len(options) < jumpOptions; n++ {
+		options = append(options, discordgo.SelectMenuOption{
+			Label:   fmt.Sprintf("Image %d", n),
+			Value:   fmt.Sprint(n),
+			Default: n == imageNum,
+		})
+	}
+
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	data.Components = []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "Previous",
+					Style:    discordgo.SecondaryButton,
+					CustomID: componentID("carousel_previous", galleryName, fmt.Sprint(imageNum)),
+					Disabled: imageNum == 0,
+				},
+				discordgo.Button{
+					Label:    "Random",
+					Style:    discordgo.PrimaryButton,
+					CustomID: componentID("carousel_random", galleryName, fmt.Sprint(imageNum)),
+					Disabled: numberOfImages == 1,
+				},
+				discordgo.Button{
+					Label:    "Next",
+					Style:    discordgo.SecondaryButton,
+					CustomID: componentID("carousel_next", galleryName, fmt.Sprint(imageNum)),
+					Disabled: imageNum == numberOfImages-1,
+				},
+			},
+		},
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.SelectMenu{
+					CustomID:    componentID("carousel_jump", galleryName, fmt.Sprint(imageNum)),
+					Placeholder: "Jump to an image",
+					Options:     options,
+				},
+			},
+		},
+	}
+	return data
+}
+
+// componentID builds a CustomID that carries the state its handler needs along with the handler's name, e.g. "image_delete_yes:memes:3"
+// Only the first value may contain the separator, which suits gallery names. If the state doesn't fit in a CustomID, just the name is used, and the handler falls back to the message's embed (see componentState).
+func componentID(handler string, state ...string) string {
+	customId := strings.Join(append([]string{handler}, state...), ":")
+	if len(customId) > maxCustomIDLength {
+		return handler
+	}
+	for n, v := range state {
+		if n > 0 && strings.Contains(v, ":") {
+			return handler // It couldn't be told apart from the separator
+		}
+	}
+	return customId
+}
+
+// componentState returns the n values that componentID encoded in the CustomID of the component behind i
+// Components without them (from messages sent before state was encoded, or whose state didn't fit) fall back to the values of the first n fields of the message's first embed. ok is false if neither has them.
+func componentState(i *discordgo.Interaction, n int) (state []string, ok bool) {
+	parts := strings.Split(i.MessageComponentData().CustomID, ":")[1:]
+	if len(parts) >= n && n > 0 {
+		first := strings.Join(parts[:len(parts)-n+1], ":")
+		return append([]string{first}, parts[len(parts)-n+1:]...), true
+	}
+	if len(i.Message.Embeds) == 0 || len(i.Message.Embeds[0].Fields) < n {
+		return nil, false
+	}
+	for _, field := range i.Message.Embeds[0].Fields[:n] {
+		state = append(state, strings.Trim(field.Value, "`"))
+	}
+	return state, true
+}
+
+// respondOutdatedComponent replaces a message whose component couldn't be handled because its state is missing, removing the components so it can't happen again
+func respondOutdatedComponent(s discordSession, i *discordgo.Interaction) {
+	log.Warn().Interface("interaction", i).Msg("Component interaction without usable state")
+	embed := discordgo.MessageEmbed{
+		Description: "This message is out of date :stop_sign: (Run the command again.)",
+		Color:       0xf04747,
+	}
+	err := respond(s, i, discordgo.InteractionResponseUpdateMessage, &discordgo.InteractionResponseData{
+		Embeds:     []*discordgo.MessageEmbed{&embed},
+		Components: []discordgo.MessageComponent{},
+	})
+	if err != nil {
+		log.Error().Err(err).Interface("interaction", i).Msg("Failure in responding to interaction")
+	}
+}
+
+// browsePage shows the image step images away from imageNum, wrapping around at either end, with buttons to keep stepping
+// Unlike carouselPage, the buttons carry no state; everything they need is read back from the footer (see parseImageFooter).
+func browsePage(i *discordgo.Interaction, galleryName string, imageNum int, step int) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	data.Components = []discordgo.MessageComponent{}
+
+	gallery, problem := loadGallery(i, galleryName)
+	if problem != nil {
+		data.Embeds = []*discordgo.MessageEmbed{problem}
+		return data
+	}
+	if gallery.Disabled {
+		data.Embeds = []*discordgo.MessageEmbed{galleryDisabledEmbed(galleryName)}
+		return data
+	}
+	numberOfImages := len(gallery.Images)
+	if numberOfImages == 0 {
+		embed = emptyGalleryEmbed(galleryName, gallery)
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	// The gallery may have shrunk since the message was posted
+	if imageNum < 0 {
+		imageNum = 0
+	} else if imageNum >= numberOfImages {
+		imageNum = numberOfImages - 1
+	}
+	imageNum = ((imageNum+step)%numberOfImages + numberOfImages) % numberOfImages
+
+	embed = discordgo.MessageEmbed{
+		Image: &discordgo.MessageEmbedImage{
+			URL: displayImageURL(gallery.Images[imageNum].ImageURL),
+		},
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Image: %d of %d | Gallery: %s", imageNum, numberOfImages-1, galleryName),
+		},
+	}
+	addAltTextField(&embed, gallery.Images[imageNum])
+	recordView(galleryName, gallery.Images[imageNum].ImageURL)
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	data.Components = []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "Previous",
+					Style:    discordgo.SecondaryButton,
+					CustomID: "image_prev",
+					Disabled: numberOfImages == 1,
+				},
+				discordgo.Button{
+					Label:    "Next",
+					Style:    discordgo.SecondaryButton,
+					CustomID: "image_next",
+					Disabled: numberOfImages == 1,
+				},
+			},
+		},
+	}
+	return data
+}
+
+// parseImageFooter reads the image number, last image number, and gallery back out of a footer like "Image: 3 of 9 | Gallery: memes"
+func parseImageFooter(text string) (imageNum int, last int, galleryName string, ok bool) {
+	const separator = " | Gallery: "
+	at := strings.Index(text, separator)
+	if at < 0 {
+		return 0, 0, "", false
+	}
+	if _, err := fmt.Sscanf(text[:at], "Image: %d of %d", &imageNum, &last); err != nil {
+		return 0, 0, "", false
+	}
+	galleryName = text[at+len(separator):]
+	if end := strings.Index(galleryName, " | "); end >= 0 {
+		galleryName = galleryName[:end]
+	}
+	return imageNum, last, galleryName, len(galleryName) > 0
+}
+
+// respondBrowseMove steps a browse message by step images
+func respondBrowseMove(s discordSession, i *discordgo.Interaction, step int) {
+	if len(i.Message.Embeds) == 0 || i.Message.Embeds[0].Footer == nil {
+		respondOutdatedComponent(s, i)
+		return
+	}
+	imageNum, _, galleryName, ok := parseImageFooter(i.Message.Embeds[0].Footer.Text)
+	if !ok {
+		respondOutdatedComponent(s, i)
+		return
+	}
+
+	data := browsePage(i, galleryName, imageNum, step)
+	err := respond(s, i, discordgo.InteractionResponseUpdateMessage, &data)
+	if err != nil {
+		log.Error().Err(err).Interface("interaction", i).Msg("Failure in responding to interaction")
+	}
+}
+
+// respondCarouselMove updates a carousel message to the image that move picks, given the current image number and how many images the footer says there are
+func respondCarouselMove(s discordSession, i *discordgo.Interaction, move func(current int, numberOfImages int) int) {
+	state, ok := componentState(i, 2)
+	if !ok {
+		respondOutdatedComponent(s, i)
+		return
+	}
+	galleryName := state[0]
+	current, _ := strconv.Atoi(state[1])
+	numberOfImages := 0
+	if len(i.Message.Embeds) > 0 && i.Message.Embeds[0].Footer != nil {
+		var last int
+		if _, err := fmt.Sscanf(i.Message.Embeds[0].Footer.Text, "Image: %d of %d", new(int), &last); err == nil {
+			numberOfImages = last + 1
+		}
+	}
+
+	data := carouselPage(i, galleryName, move(current, numberOfImages))
+	if data.Components == nil {
+		data.Components = []discordgo.MessageComponent{} // Something went wrong, so the controls no longer apply
+	}
+	err := respond(s, i, discordgo.InteractionResponseUpdateMessage, &data)
+	if err != nil {
+		log.Error().Err(err).Interface("interaction", i).Msg("Failure in responding to interaction")
+	}
+}
+
+// carouselRandomJump picks an image other than the current one, or the current one if it's all there is
+func carouselRandomJump(current int, numberOfImages int) int {
+	if numberOfImages < 2 {
+		return current
+	}
+	next := rand.Intn(numberOfImages - 1)
+	if next >= current {
+		next++
+	}
+	return next
+}
+
+// unknownAuthorId stands in for the author of images stored without one
+const unknownAuthorId = "unknown"
+
+// repairImages fills in missing timestamps (as 0) and authors (as unknownAuthorId), and drops images that have no URL at all
+func repairImages(images []Image) (repaired []Image, timestampsFilled int, authorsFilled int, dropped int) {
+	for _, image := range images {
+		if len(strings.TrimSpace(image.ImageURL)) == 0 {
+			dropped++
+			continue
+		}
+		if len(image.Timestamp) == 0 {
+			image.Timestamp = "0"
+			timestampsFilled++
+		}
+		if len(image.AuthorID) == 0 {
+			image.AuthorID = unknownAuthorId
+			authorsFilled++
+		}
+		repaired = append(repaired, image)
+	}
+	return repaired, timestampsFilled, authorsFilled, dropped
+}
+
+// repairGallery heals images stored before stricter validation existed, see repairImages
+func repairGallery(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+	ctx, done := requestContext("repair")
+	defer done()
+	var repaired []Image
+	var timestampsFilled, authorsFilled, dropped int
+
+	command := i.ApplicationCommandData().Options[0]
+	galleryName := command.Options[0].StringValue()
+
+	docRef := getGalleryDocRef(galleryName)
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(docRef)
+		if err != nil {
+			return err
+		}
+		var gallery Gallery
+		err = docSnap.DataTo(&gallery)
+		if err != nil {
+			return err
+		}
+		if gallery.Locked {
+			return errGalleryLocked
+		}
+		before := append([]Image(nil), gallery.Images...)
+		repaired, timestampsFilled, authorsFilled, dropped = repairImages(gallery.Images)
+		if timestampsFilled+authorsFilled+dropped == 0 {
+			return nil
+		}
+		gallery.FeaturedIndex = featuredIndexAfterRewrite(before, repaired, gallery.FeaturedIndex)
+		gallery.Images = repaired
+		return tx.Set(docRef, gallery)
+	})
+	if status.Code(err) == codes.NotFound {
+		embed = discordgo.MessageEmbed{
+			Description: "Gallery does not exist :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	} else if errors.Is(err, errGalleryLocked) {
+		data.Embeds = []*discordgo.MessageEmbed{galleryLockedEmbed(galleryName)}
+		return data
+	} else if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Interface("docRef", docRef).Msg("Failed to write document contents")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to modify gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	if timestampsFilled+authorsFilled+dropped == 0 {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Gallery `%s` didn't need any repairs :white_check_mark:", galleryName),
+			Color:       0x43b581,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	recordAuditEvent(galleryName, AuditEvent{
+		Action:  auditActionReplace,
+		ActorID: interactionUserID(i),
+		Images:  repaired,
+	})
+
+	embed = discordgo.MessageEmbed{
+		Description: fmt.Sprintf("Repaired gallery `%s` :white_check_mark:", galleryName),
+		Color:       0x43b581,
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   "Timestamps filled in",
+				Value:  fmt.Sprint(timestampsFilled),
+				Inline: true,
+			},
+			{
+				Name:   "Authors filled in",
+				Value:  fmt.Sprint(authorsFilled),
+				Inline: true,
+			},
+			{
+				Name:   "Images without a link removed",
+				Value:  fmt.Sprint(dropped),
+				Inline: true,
+			},
+		},
+	}
+	log.Debug().Str("gallery", galleryName).Int("timestampsFilled", timestampsFilled).Int("authorsFilled", authorsFilled).Int("dropped", dropped).Msg("Repaired gallery")
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	return data
+}
+
+// buildExportArchives writes each gallery to its own JSON file, packing the files into as many zip archives as it takes to keep each under exportMaxArchiveBytes
+// Sizes are judged before compression, so archives usually come out well under the limit. A gallery too large to fit anywhere is still given an archive of its own.
+func buildExportArchives(docSnaps []*firestore.DocumentSnapshot) (archives [][]byte, exported int, err error) {
+	var buf *bytes.Buffer
+	var archive *zip.Writer
+	finish := func() error {
+		if archive == nil {
+			return nil
+		}
+		if err := archive.Close(); err != nil {
+			return err
+		}
+		archives = append(archives, buf.Bytes())
+		archive = nil
+		return nil
+	}
+
+	for _, docSnap := range docSnaps {
+		var gallery Gallery
+		err = docSnap.DataTo(&gallery)
+		if err != nil {
+			log.Error().Err(err).Caller().Interface("docSnap", docSnap).Msg("Failed to retrieve document contents, leaving it out of the export")
+			continue
+		}
+		contents, err := json.MarshalIndent(gallery, "", "  ")
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if archive != nil && buf.Len()+len(contents) > exportMaxArchiveBytes {
+			if err = finish(); err != nil {
+				return nil, 0, err
+			}
+		}
+		if archive == nil {
+			buf = new(bytes.Buffer)
+			archive = zip.NewWriter(buf)
+		}
+		file, err := archive.Create(url.PathEscape(docSnap.Ref.ID) + ".json")
+		if err != nil {
+			return nil, 0, err
+		}
+		_, err = file.Write(contents)
+		if err != nil {
+			return nil, 0, err
+		}
+		exported++
+	}
+	return archives, exported, finish()
+}
+
+// exportAllGalleries responds with a backup of every gallery, sending any archives beyond the first as follow-up messages
+func exportAllGalleries(s discordSession, i *discordgo.Interaction) {
+	err := respond(s, i, discordgo.InteractionResponseDeferredChannelMessageWithSource, nil)
+	if err != nil {
+		log.Error().Err(err).Interface("interaction", i).Msg("Failure in deferring response to interaction")
+		return
+	}
+
+	var embed discordgo.MessageEmbed
+	var archives [][]byte
+	exported := 0
+	docSnaps, err := getAllGalleries()
+	if err == nil {
+		archives, exported, err = buildExportArchives(docSnaps)
+	}
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Msg("Failed to export galleries")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+	} else {
+		embed = discordgo.MessageEmbed{
+			Description: fmt.Sprintf("Exported %d of %d galleries :package:", exported, len(docSnaps)),
+			Color:       0x43b581,
+		}
+		if len(archives) > 1 {
+			embed.Description += fmt.Sprintf("\nThe export is split across %d archives.", len(archives))
+		}
+	}
+
+	archiveFile := func(n int) []*discordgo.File {
+		name := "galleries.zip"
+		if len(archives) > 1 {
+			name = fmt.Sprintf("galleries-%d-of-%d.zip", n+1, len(archives))
+		}
+		return []*discordgo.File{
+			{
+				Name:        name,
+				ContentType: "application/zip",
+				Reader:      bytes.NewReader(archives[n]),
+			},
+		}
+	}
+	edit := discordgo.WebhookEdit{Embeds: []*discordgo.MessageEmbed{&embed}}
+	if len(archives) > 0 {
+		edit.Files = archiveFile(0)
+	}
+	_, err = s.InteractionResponseEdit(applicationID(s), i, &edit)
+	if err != nil {
+		log.Error().Err(err).Interface("interaction", i).Msg("Failure in editing deferred response to interaction")
+		return
+	}
+	for n := 1; n < len(archives); n++ {
+		_, err = s.FollowupMessageCreate(applicationID(s), i, true, &discordgo.WebhookParams{Files: archiveFile(n)})
+		if err != nil {
+			log.Error().Err(err).Interface("interaction", i).Int("archive", n).Msg("Failed to send export archive")
+		}
+	}
+	log.Debug().Int("galleries", exported).Int("archives", len(archives)).Msg("Exported all galleries")
+}
+
+// messageLinkPattern matches links to Discord messages, capturing the channel and message IDs
+var messageLinkPattern = regexp.MustCompile(`^https://(?:(?:ptb|canary)\.)?discord(?:app)?\.com/channels/(?:\d+|@me)/(\d+)/(\d+)/?$`)
+
+// messageInteractionID returns the ID of the interaction that a bot message was sent in reply to, or "" if it wasn't
+// discordgo doesn't expose the message's interaction field, so the message is fetched and decoded directly.
+func messageInteractionID(channelId string, messageId string) (string, error) {
+	endpoint := discordgo.EndpointChannelMessage(channelId, messageId)
+	body, err := s.RequestWithBucketID("GET", endpoint, nil, discordgo.EndpointChannelMessage(channelId, ""))
+	if err != nil {
+		return "", err
+	}
+	var message struct {
+		Interaction *struct {
+			ID string `json:"id"`
+		} `json:"interaction"`
+	}
+	err = json.Unmarshal(body, &message)
+	if err != nil || message.Interaction == nil {
+		return "", err
+	}
+	return message.Interaction.ID, nil
+}
+
+// attachmentRefreshBatchSize is the most links Discord will re-sign in one request
+const attachmentRefreshBatchSize = 50
+
+// attachmentLinkExpiry returns when a Discord attachment link stops working, or false if imageUrl isn't a signed Discord attachment link
+func attachmentLinkExpiry(imageUrl string) (time.Time, bool) {
+	parsed, err := url.Parse(imageUrl)
+	if err != nil {
+		return time.Time{}, false
+	}
+	switch strings.ToLower(parsed.Host) {
+	case "cdn.discordapp.com", "media.discordapp.net":
+	default:
+		return time.Time{}, false
+	}
+	if !strings.HasPrefix(parsed.Path, "/attachments/") {
+		return time.Time{}, false
+	}
+	expiry, err := strconv.ParseInt(parsed.Query().Get("ex"), 16, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(expiry, 0), true
+}
+
+// refreshAttachmentLinks asks Discord to re-sign attachment links, returning the new link for each one it could
+// discordgo has no wrapper for this endpoint, so the request is made directly.
+func refreshAttachmentLinks(imageUrls []string) (refreshed map[string]string, err error) {
+	refreshed = make(map[string]string)
+	endpoint := discordgo.EndpointAPI + "attachments/refresh-urls"
+	for start := 0; start < len(imageUrls); start += attachmentRefreshBatchSize {
+		end := start + attachmentRefreshBatchSize
+		if end > len(imageUrls) {
+			end = len(imageUrls)
+		}
+		body, err := s.RequestWithBucketID("POST", endpoint, map[string][]string{"attachment_urls": imageUrls[start:end]}, endpoint)
+		if err != nil {
+			return refreshed, err
+		}
+		var response struct {
+			RefreshedUrls []struct {
+				Original  string `json:"original"`
+				Refreshed string `json:"refreshed"`
+			} `json:"refreshed_urls"`
+		}
+		err = json.Unmarshal(body, &response)
+		if err != nil {
+			return refreshed, err
+		}
+		for _, v := range response.RefreshedUrls {
+			if v.Refreshed != "" && v.Refreshed != v.Original {
+				refreshed[v.Original] = v.Refreshed
+			}
+		}
+	}
+	return refreshed, nil
+}
+
+// refreshExpiringAttachments re-signs every stored Discord attachment link that would expire before the next refresh, and saves the new links
+// Links Discord can't re-sign (e.g. the message was deleted) are left as they are and logged, since they'll need re-hosting.
+func refreshExpiringAttachments() {
+	docSnaps, err := getAllGalleries()
+	if err != nil {
+		log.Error().Err(err).Caller().Msg("Failed to retrieve galleries for attachment refresh")
+		return
+	}
+
+	// Refreshing a little early leaves room for a slow or failed run
+	deadline := time.Now().Add(2 * attachmentRefreshInterval)
+	expiring := make(map[string][]string) // Gallery name to its links that need refreshing
+	var imageUrls []string
+	for _, docSnap := range docSnaps {
+		var gallery Gallery
+		err = docSnap.DataTo(&gallery)
+		if err != nil {
+			log.Error().Err(err).Caller().Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+			continue
+		}
+		for _, image := range gallery.Images {
+			expiry, ok := attachmentLinkExpiry(image.ImageURL)
+			if ok && expiry.Before(deadline) {
+				expiring[docSnap.Ref.ID] = append(expiring[docSnap.Ref.ID], image.ImageURL)
+				imageUrls = append(imageUrls, image.ImageURL)
+			}
+		}
+	}
+	if len(imageUrls) == 0 {
+		return
+	}
+
+	refreshed, err := refreshAttachmentLinks(imageUrls)
+	if err != nil {
+		// Save whatever was refreshed before the failure
+		log.Error().Err(err).Caller().Int("links", len(imageUrls)).Msg("Failed to refresh attachment links")
+	}
+	if len(refreshed) < len(imageUrls) {
+		log.Warn().Int("unrefreshed", len(imageUrls)-len(refreshed)).Msg("Some attachment links could not be refreshed and will stop loading; their images should be re-hosted")
+	}
+
+	for galleryName, galleryUrls := range expiring {
+		needed := false
+		for _, v := range galleryUrls {
+			if _, ok := refreshed[v]; ok {
+				needed = true
+				break
+			}
+		}
+		if !needed {
+			continue
+		}
+		var images []Image
+		changed := make(map[string]string)
+		docRef := getGalleryDocRef(galleryName)
+		err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			docSnap, err := tx.Get(docRef)
+			if err != nil {
+				return err
+			}
+			var gallery Gallery
+			err = docSnap.DataTo(&gallery)
+			if err != nil {
+				return err
+			}
+			// Matching on the old link means an image moved or removed in the meantime is left alone
+			changed = make(map[string]string)
+			for n, image := range gallery.Images {
+				if newUrl, ok := refreshed[image.ImageURL]; ok {
+					gallery.Images[n].ImageURL = newUrl
+					changed[image.ImageURL] = newUrl
+				}
+			}
+			images = gallery.Images
+			return tx.Set(docRef, gallery)
+		})
+		if err != nil {
+			if status.Code(err) != codes.NotFound {
+				log.Error().Err(err).Caller().Str("gallery", galleryName).Msg("Failed to save refreshed attachment links")
+			}
+			continue
+		}
+		if len(changed) > 0 {
+			// Otherwise a restore would bring back the expired links
+			recordAuditEvent(galleryName, AuditEvent{
+				Action:  auditActionReplace,
+				ActorID: s.State.User.ID,
+				Images:  images,
+			})
+			noteImageURLsChanged(galleryName, changed)
+		}
+	}
+	log.Info().Int("refreshed", len(refreshed)).Msg("Refreshed expiring attachment links")
+}
+
+// noteImageURLsChanged points whatever refers to an image of galleryName by its link (repost and reveal schedules, unflushed views) at the image's new link
+// changed maps each old link to its replacement.
+func noteImageURLsChanged(galleryName string, changed map[string]string) {
+	pendingViewsMutex.Lock()
+	for oldUrl, newUrl := range changed {
+		if count, ok := pendingViews[galleryName][oldUrl]; ok {
+			delete(pendingViews[galleryName], oldUrl)
+			pendingViews[galleryName][newUrl] += count
+		}
+	}
+	pendingViewsMutex.Unlock()
+
+	docSnaps, err := firestoreClient.Collection("schedules").Where("gallery", "==", galleryName).Documents(ctx).GetAll()
+	if err != nil {
+		log.Error().Err(err).Caller().Str("gallery", galleryName).Msg("Failed to retrieve schedules to update their image links")
+		return
+	}
+	for _, docSnap := range docSnaps {
+		var schedule RepostSchedule
+		err = docSnap.DataTo(&schedule)
+		if err != nil {
+			log.Error().Err(err).Caller().Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+			continue
+		}
+		newUrl, ok := changed[schedule.ImageUrl]
+		if !ok {
+			continue
+		}
+		_, err = docSnap.Ref.Update(ctx, []firestore.Update{{Path: "imageUrl", Value: newUrl}})
+		if err != nil {
+			log.Error().Err(err).Caller().Str("schedule", docSnap.Ref.ID).Msg("Failed to update schedule's image link")
+		}
+	}
+}
+
+// refreshAttachmentsPeriodically calls refreshExpiringAttachments right away and then every attachmentRefreshInterval until stop is closed
+func refreshAttachmentsPeriodically(stop <-chan struct{}) {
+	if attachmentRefreshInterval <= 0 {
+		return
+	}
+	refreshExpiringAttachments()
+	ticker := time.NewTicker(attachmentRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			refreshExpiringAttachments()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// findImageByMessage locates the gallery image that a message link refers to: either the bot's reply to an add_image, or a message an image was imported from
+func findImageByMessage(i *discordgo.Interaction) (data discordgo.InteractionResponseData) {
+	var embed discordgo.MessageEmbed
+
+	messageLink := strings.TrimSpace(i.ApplicationCommandData().Options[0].StringValue())
+	matches := messageLinkPattern.FindStringSubmatch(messageLink)
+	if matches == nil {
+		embed = discordgo.MessageEmbed{
+			Description: "That doesn't look like a message link :stop_sign: (Use \"Copy Message Link\" on the message.)",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		data.Flags = messageFlagsEphemeral
+		return data
+	}
+	channelId, messageId := matches[1], matches[2]
+
+	interactionId, err := messageInteractionID(channelId, messageId)
+	if err != nil {
+		log.Debug().Err(err).Str("channelId", channelId).Str("messageId", messageId).Msg("Could not fetch linked message, searching by message ID only")
+	}
+
+	docSnaps, err := getAllGalleries()
+	if err != nil {
+		log.Error().Err(err).Caller().Interface("interaction", i).Msg("Failed to retrieve galleries")
+		embed = discordgo.MessageEmbed{
+			Description: "Unable to get gallery contents :stop_sign:",
+			Color:       0xf04747,
+		}
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+		return data
+	}
+	for _, docSnap := range docSnaps {
+		var gallery Gallery
+		err = docSnap.DataTo(&gallery)
+		if err != nil {
+			log.Error().Err(err).Caller().Interface("docSnap", docSnap).Msg("Failed to retrieve document contents")
+			continue
+		}
+		for n, image := range gallery.Images {
+			if image.SourceMessageID == messageId || (len(interactionId) > 0 && image.SourceInteractionID == interactionId) {
+				if gallery.Disabled {
+					data.Embeds = []*discordgo.MessageEmbed{galleryDisabledEmbed(docSnap.Ref.ID)}
+					data.Flags = messageFlagsEphemeral
+					return data
+				}
+				embed = discordgo.MessageEmbed{
+					Description: fmt.Sprintf("That message added image `%d` to `%s` :mag:", n, docSnap.Ref.ID),
+					Color:       0x5865f2,
+					Image: &discordgo.MessageEmbedImage{
+						URL: displayImageURL(image.ImageURL),
+					},
+					Footer: &discordgo.MessageEmbedFooter{
+						Text: fmt.Sprintf("Image: %d of %d | Gallery: %s", n, len(gallery.Images)-1, docSnap.Ref.ID),
+					},
+				}
+				addAltTextField(&embed, image)
+				data.Embeds = []*discordgo.MessageEmbed{&embed}
+				return data
+			}
+		}
+	}
+
+	embed = discordgo.MessageEmbed{
+		Description: "No gallery image came from that message :stop_sign:\n(Only images added after message tracking was introduced can be found, and they may have been removed since.)",
+		Color:       0xf04747,
+	}
+	data.Embeds = []*discordgo.MessageEmbed{&embed}
+	data.Flags = messageFlagsEphemeral
+	return data
+}
+
+// galleryNameOptionNames are the names of the options that name an existing gallery
+var galleryNameOptionNames = map[string]bool{
+	"gallery_name":             true,
+	"other_gallery_name":       true,
+	"source_gallery_name":      true,
+	"destination_gallery_name": true,
+}
+
+// galleryNameOptions finds every option that names an existing gallery, for updateCommands to have Discord autocomplete them
+// They're found by name rather than position so that commands can be added or reordered freely; create's gallery_name is skipped since it names a gallery that doesn't exist yet.
+func galleryNameOptions() (options []*discordgo.ApplicationCommandOption) {
+	for _, command := range commands {
+		for _, subcommand := range command.Options {
+			if subcommand.Type != discordgo.ApplicationCommandOptionSubCommand || subcommand.Name == "create" {
+				continue
+			}
+			for _, option := range subcommand.Options {
+				if galleryNameOptionNames[option.Name] {
+					options = append(options, option)
+				}
+			}
+		}
+	}
+	return options
+}
+
+// respondGalleryAutocomplete suggests the galleries starting with what has been typed so far, when the option being typed in names an existing gallery
+func respondGalleryAutocomplete(r discordSession, i *discordgo.Interaction) {
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	if option := focusedOption(i.ApplicationCommandData().Options); option != nil && galleryNameOptionNames[option.Name] {
+		choices = populateGalleryChoices(option.StringValue())
+	}
+	err := r.InteractionRespond(i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{Choices: choices},
+	})
+	if err != nil {
+		log.Error().Err(err).Interface("interaction", i).Msg("Failure in responding to autocomplete")
+	}
+}
+
+// focusedOption finds the option being typed in among options and their subcommands' options, or nil if there is none
+func focusedOption(options []*discordgo.ApplicationCommandInteractionDataOption) *discordgo.ApplicationCommandInteractionDataOption {
+	for _, option := range options {
+		if option.Focused {
+			return option
+		}
+		if focused := focusedOption(option.Options); focused != nil {
+			return focused
+		}
+	}
+	return nil
+}
+
+// updateCommands registers the enabled commands with Discord, e.g. after a subcommand was toggled
+// Gallery names are suggested as they're typed (see respondGalleryAutocomplete), so adding or removing galleries doesn't call for an update. Each Discord API call is retried with backoff. An error listing whichever calls still failed is returned so that callers can warn that the commands may be stale.
+func updateCommands() error {
+	for _, option := range galleryNameOptions() {
+		option.Autocomplete = true
+	}
+
+	// Only push commands that are missing or differ from what Discord already has registered
+	registeredCommands := make(map[string]*discordgo.ApplicationCommand)
+	var registered []*discordgo.ApplicationCommand
+	err := retryWithBackoff(func() (err error) {
+		registered, err = s.ApplicationCommands(s.State.User.ID, commandGuildID())
+		return err
+	})
+	if err != nil {
+		log.Error().Err(err).Caller().Msg("Cannot fetch registered commands, (re)creating all of them")
+	}
+	for _, v := range registered {
+		registeredCommands[v.Name] = v
+	}
+
+	var failed []string
+	enabledCommands := enabledCommandDefinitions()
+	for _, v := range enabledCommands {
+		existing, isRegistered := registeredCommands[v.Name]
+		if !isRegistered {
+			err = retryWithBackoff(func() error {
+				_, err := s.ApplicationCommandCreate(s.State.User.ID, commandGuildID(), v)
+				return err
+			})
+			if err != nil {
+				log.Error().Err(err).Caller().Msgf("Cannot create '%s' command", v.Name)
+				failed = append(failed, "create "+v.Name)
+			}
+		} else if !commandsEqual(existing, v) {
+			err = retryWithBackoff(func() error {
+				_, err := s.ApplicationCommandEdit(s.State.User.ID, commandGuildID(), existing.ID, v)
+				return err
+			})
+			if err != nil {
+				log.Error().Err(err).Caller().Msgf("Cannot edit '%s' command", v.Name)
+				failed = append(failed, "edit "+v.Name)
+			}
+		} else {
+			log.Debug().Msgf("'%s' command is already up to date", v.Name)
+		}
+		delete(registeredCommands, v.Name)
+	}
+
+	// Anything left over is no longer wanted (e.g. every one of its subcommands has been disabled)
+	for _, v := range registeredCommands {
+		err = retryWithBackoff(func() error {
+			return s.ApplicationCommandDelete(s.State.User.ID, commandGuildID(), v.ID)
+		})
+		if err != nil {
+			log.Error().Err(err).Caller().Msgf("Cannot delete '%s' command", v.Name)
+			failed = append(failed, "delete "+v.Name)
+		}
+	}
+
+	if len(failed) > 0 {
+		log.Error().Strs("failed", failed).Msg("Some commands could not be updated, so they may be out of date until the next update")
+		return fmt.Errorf("failed to update commands (%s)", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// retryWithBackoff calls call until it succeeds or has been tried commandUpdateAttempts times, doubling the wait between tries (starting at commandUpdateBackoff)
+// The last error is returned if every try fails.
+func retryWithBackoff(call func() error) (err error) {
+	backoff := commandUpdateBackoff
+	for attempt := 1; ; attempt++ {
+		err = call()
+		if err == nil || attempt >= commandUpdateAttempts {
+			return err
+		}
+		log.Warn().Err(err).Int("attempt", attempt).Msgf("Retrying in %s", backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// warnIfCommandsStale adds a warning to data if updating the commands (after a subcommand was toggled) failed
+func warnIfCommandsStale(data *discordgo.InteractionResponseData, err error) {
+	if err == nil {
+		return
+	}
+	data.Embeds = append(data.Embeds, &discordgo.MessageEmbed{
+		Description: ":warning: The commands couldn't be refreshed, so they may be out of date for a while.",
+		Color:       0xfaa61a,
+	})
+}
+
+// enabledCommandDefinitions returns copies of commands with any subcommands disabled by feature flags left out
+// Commands left without any subcommands are omitted entirely.
+func enabledCommandDefinitions() (enabled []*discordgo.ApplicationCommand) {
+	for _, v := range commands {
+		command := *v
+		command.Options = nil
+		for _, option := range v.Options {
+			if option.Type != discordgo.ApplicationCommandOptionSubCommand || featureEnabled(option.Name) {
+				command.Options = append(command.Options, option)
+			}
+		}
+		if len(command.Options) > 0 {
+			enabled = append(enabled, &command)
+		}
+	}
+	return enabled
+}
+
+// commandsEqual reports whether a registered command matches the desired definition
+// Fields assigned by Discord (ID, application ID, version) are ignored, as is the distinction between nil and empty option/choice lists.
+func commandsEqual(registered, desired *discordgo.ApplicationCommand) bool {
+	return registered.Name == desired.Name &&
+		registered.Description == desired.Description &&
+		commandOptionsEqual(registered.Options, desired.Options)
+}
+
+func commandOptionsEqual(registered, desired []*discordgo.ApplicationCommandOption) bool {
+	if len(registered) != len(desired) {
+		return false
+	}
+	for n := range registered {
+		if registered[n].Type != desired[n].Type ||
+			registered[n].Name != desired[n].Name ||
+			registered[n].Description != desired[n].Description ||
+			registered[n].Required != desired[n].Required ||
+			registered[n].Autocomplete != desired[n].Autocomplete ||
+			registered[n].MaxValue != desired[n].MaxValue {
+			return false
+		}
+		if !commandOptionChoicesEqual(registered[n].Choices, desired[n].Choices) || !commandOptionsEqual(registered[n].Options, desired[n].Options) {
+			return false
+		}
+	}
+	return true
+}
+
+func commandOptionChoicesEqual(registered, desired []*discordgo.ApplicationCommandOptionChoice) bool {
+	if len(registered) != len(desired) {
+		return false
+	}
+	for n := range registered {
+		// Values decoded from Discord's JSON won't share a type with ours (e.g. float64 vs int), so compare their printed forms
+		if registered[n].Name != desired[n].Name || fmt.Sprint(registered[n].Value) != fmt.Sprint(desired[n].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// convertToOption lets random and pick send the image converted to another format, for clients that can't show the original
+var convertToOption = &discordgo.ApplicationCommandOption{
+	Name:        "convert_to",
+	Description: "Send the image converted to this format instead of linking the original",
+	Type:        discordgo.ApplicationCommandOptionString,
+	Choices: []*discordgo.ApplicationCommandOptionChoice{
+		{Name: "PNG", Value: "png"},
+		{Name: "JPEG", Value: "jpeg"},
+	},
+}
+
+var (
+	commands = []*discordgo.ApplicationCommand{
+		{
+			Name:        "gallery",
+			Description: "Server-wide image gallery",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "random",
+					Description: "Send a random image from the chosen gallery",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery to choose from",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "tags",
+							Description: "Only choose from images with these comma-separated tags",
+							Type:        discordgo.ApplicationCommandOptionString,
+						},
+						{
+							Name:        "match",
+							Description: "Whether images need all of the tags or any of them (defaults to all)",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Choices: []*discordgo.ApplicationCommandOptionChoice{
+								{
+									Name:  "all",
+									Value: "all",
+								},
+								{
+									Name:  "any",
+									Value: "any",
+								},
+							},
+						},
+						{
+							Name:        "fair",
+							Description: "Favor images that have been shown the least",
+							Type:        discordgo.ApplicationCommandOptionBoolean,
+						},
+						{
+							Name:        "balanced",
+							Description: "Pick a random tag first, so every tag is equally likely however many images it has",
+							Type:        discordgo.ApplicationCommandOptionBoolean,
+						},
+						{
+							Name:        "min_age",
+							Description: "Only choose from images added at least this long ago, like 30d, 2w, or 36h",
+							Type:        discordgo.ApplicationCommandOptionString,
+						},
+						{
+							Name:        "not_mine",
+							Description: "Leave out images you added",
+							Type:        discordgo.ApplicationCommandOptionBoolean,
+						},
+						convertToOption,
+					},
+				},
+				{
+					Name:        "pick",
+					Description: "Send the specified image from the chosen gallery",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery to choose from",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "image_number",
+							Description: "The image you wish to choose",
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Required:    true,
+						},
+						convertToOption,
+					},
+				},
+				{
+					Name:        "add_image",
+					Description: "Add the specified image to the chosen gallery",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery to add an image to",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "image_link",
+							Description: "The URL pointing to the image you wish to add",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "tags",
+							Description: "Comma-separated tags describing the image",
+							Type:        discordgo.ApplicationCommandOptionString,
+						},
+						{
+							Name:        "alt",
+							Description: "A description of the image for people using screen readers",
+							Type:        discordgo.ApplicationCommandOptionString,
+						},
+						{
+							Name:        "caption",
+							Description: "A caption to show with the image",
+							Type:        discordgo.ApplicationCommandOptionString,
+						},
+					},
+				},
+				{
+					Name:        "remove_image",
+					Description: "Remove the specified image from the chosen gallery",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery to remove an image from",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "image_number",
+							Description: "The image you wish to remove",
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "delete",
+					Description: "Delete an existing gallery",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The name of the gallery to be deleted",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "create",
+					Description: "Create a new gallery",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The name of the gallery to be created (lowercase letters, numbers, - and _)",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "popular",
+					Description: "List the most viewed images in the chosen gallery",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery to rank",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "help",
+					Description: "List everything the gallery commands can do",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "my_galleries",
+					Description: "List the galleries you (or another member) have added images to",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "user",
+							Description: "The member to look up (defaults to you)",
+							Type:        discordgo.ApplicationCommandOptionUser,
+						},
+					},
+				},
+				{
+					Name:        "featured",
+					Description: "Send the featured image of the chosen gallery",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery to choose from",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "timeline",
+					Description: "Show how many images were added to a gallery on each day",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery to chart",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "chart",
+							Description: "Draw the counts as a bar chart",
+							Type:        discordgo.ApplicationCommandOptionBoolean,
+						},
+					},
+				},
+				{
+					Name:        "challenge",
+					Description: "Pit the featured image against a random challenger",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery to choose from",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "carousel",
+					Description: "Page through a gallery one image at a time",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery to page through",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "image_number",
+							Description: "The image to start at (the first, by default)",
+							Type:        discordgo.ApplicationCommandOptionInteger,
+						},
+					},
+				},
+				{
+					Name:        "set_alt",
+					Description: "Describe an image for people using screen readers",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery containing the image",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "image_number",
+							Description: "The image to describe",
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Required:    true,
+						},
+						{
+							Name:        "alt",
+							Description: "The description, or leave out to remove it",
+							Type:        discordgo.ApplicationCommandOptionString,
+						},
+					},
+				},
+				{
+					Name:        "poll",
+					Description: "Post an image and let everyone vote on it with reactions",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery to choose from",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "image_number",
+							Description: "The image to vote on (a random one, by default)",
+							Type:        discordgo.ApplicationCommandOptionInteger,
+						},
+						{
+							Name:        "minutes",
+							Description: "How long the poll stays open",
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							MaxValue:    maxPollMinutes,
+						},
+					},
+				},
+				{
+					Name:        "link",
+					Description: "Get a link to an image to share elsewhere",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery containing the image",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "image_number",
+							Description: "The number of the image",
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "showcase",
+					Description: "Post the three most viewed images in the chosen gallery",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery to showcase",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "random_category",
+					Description: "Send a random image from any gallery in a category",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "category",
+							Description: "The category to choose from",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "tag_stats",
+					Description: "Count how many images in the chosen gallery carry each tag",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery to count the tags of",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "list",
+					Description: "List every gallery and how many images it has",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "browse",
+					Description: "Step through the chosen gallery from its first image",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery to browse",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "info",
+					Description: "Show the size, history, contributors, and settings of the chosen gallery",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery to describe",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "rename",
+					Description: "Give the chosen gallery a new name, keeping its images and settings",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery to rename",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "new_name",
+							Description: "The gallery's new name (lowercase letters, numbers, - and _)",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "copy",
+					Description: "Start a new gallery with the same images as the chosen one",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "source_gallery_name",
+							Description: "The gallery to copy",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "new_gallery_name",
+							Description: "The name of the copy (lowercase letters, numbers, - and _)",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "merge",
+					Description: "Add the images of one gallery that another doesn't have to it",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "source_gallery_name",
+							Description: "The gallery to take images from",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "destination_gallery_name",
+							Description: "The gallery to add them to",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "delete_source",
+							Description: "Delete the gallery the images were taken from afterwards",
+							Type:        discordgo.ApplicationCommandOptionBoolean,
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:        "find_image",
+			Description: "Find the gallery image that a message added",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "message_link",
+					Description: "A link to the message (from \"Copy Message Link\")",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+				},
+			},
+		},
+		{
+			Name:        "gallery_admin",
+			Description: "Gallery maintenance for server administrators",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "audit_all",
+					Description: "Check every gallery for broken links, duplicates, and oversized images",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "schedule_exclude",
+					Description: "Keep a gallery out of (or return it to) the scheduled post rotation",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery to change",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "excluded",
+							Description: "Whether scheduled posts should skip this gallery",
+							Type:        discordgo.ApplicationCommandOptionBoolean,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "restore",
+					Description: "Rebuild a gallery as it was at a point in time into a new gallery",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery to restore",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "timestamp",
+							Description: "Unix time or a UTC date like 2021-09-01 18:30",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "new_gallery_name",
+							Description: "The name of the gallery to create with the restored images",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "import_channel",
+					Description: "Add the images posted in a channel to a gallery",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery to add the images to",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "channel",
+							Description: "The channel whose history should be imported",
+							Type:        discordgo.ApplicationCommandOptionChannel,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "dedupe",
+					Description: "Remove duplicate images from a gallery",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery to clean up",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "loose",
+							Description: "Also match http and https, tracking parameters, and mirror hosts (default: false)",
+							Type:        discordgo.ApplicationCommandOptionBoolean,
+						},
+					},
+				},
+				{
+					Name:        "feature",
+					Description: "Make an image the featured entry of its gallery",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery containing the image",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "image_number",
+							Description: "The image to feature",
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "repair",
+					Description: "Fill in missing image details and remove images without a link",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery to repair",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "lock",
+					Description: "Prevent any images from being added to or removed from a gallery",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery to lock",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "unlock",
+					Description: "Allow changes to a locked gallery again",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery to unlock",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "export_all",
+					Description: "Download a backup of every gallery",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "formats",
+					Description: "Only accept images of certain formats in a gallery",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery to restrict",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "formats",
+							Description: "Comma-separated formats to accept (png, jpg, gif, webp), or leave out to accept any",
+							Type:        discordgo.ApplicationCommandOptionString,
+						},
+					},
+				},
+				{
+					Name:        "setup",
+					Description: "Create a set of starter galleries for a new server",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "activity",
+					Description: "List who last used each gallery, and when",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "set_timestamp",
+					Description: "Correct when an image was added",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery containing the image",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "image_number",
+							Description: "The image to change",
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Required:    true,
+						},
+						{
+							Name:        "timestamp",
+							Description: "Unix time or a UTC date like 2021-09-01 18:30",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "toggle_command",
+					Description: "Turn a subcommand on or off for this server",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "subcommand",
+							Description: "The name of the subcommand, e.g. poll",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "enabled",
+							Description: "Whether the subcommand should be available",
+							Type:        discordgo.ApplicationCommandOptionBoolean,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "sizes",
+					Description: "Rank the galleries by how many images they have",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "count",
+							Description: "How many of the largest and smallest galleries to list (default 5)",
+							Type:        discordgo.ApplicationCommandOptionInteger,
+						},
+						{
+							Name:        "storage",
+							Description: "Rank by estimated storage against Firestore's 1 MiB document limit instead",
+							Type:        discordgo.ApplicationCommandOptionBoolean,
+						},
+					},
+				},
+				{
+					Name:        "spotlight",
+					Description: "Post a random image from a gallery in another channel",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery to pick the image from",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "channel",
+							Description: "The channel to post the image in",
+							Type:        discordgo.ApplicationCommandOptionChannel,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "diff",
+					Description: "List the images that only one of two galleries has",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The first gallery to compare",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "other_gallery_name",
+							Description: "The second gallery to compare",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "pause",
+					Description: "Stop handling commands from everyone but administrators, e.g. for maintenance",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "persist",
+							Description: "Stay paused across restarts until resumed (default false)",
+							Type:        discordgo.ApplicationCommandOptionBoolean,
+						},
+					},
+				},
+				{
+					Name:        "resume",
+					Description: "Handle commands from everyone again",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "orphans",
+					Description: "List stored galleries and settings the commands can't reach",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "split_by_tag",
+					Description: "Move the images with a tag out of a gallery and into a new one",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery to split",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "tag",
+							Description: "Images with this tag are moved",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "new_gallery_name",
+							Description: "The gallery to create for them (named after the tag, by default)",
+							Type:        discordgo.ApplicationCommandOptionString,
+						},
+					},
+				},
+				{
+					Name:        "caption_bulk",
+					Description: "Set the descriptions of many images from a JSON or CSV file",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery containing the images",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "message_link",
+							Description: "A link to a message with the file attached, mapping image numbers or links to descriptions",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "grant",
+					Description: "Let a member run one of these subcommands once, for a short while",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "user",
+							Description: "The member to trust",
+							Type:        discordgo.ApplicationCommandOptionUser,
+							Required:    true,
+						},
+						{
+							Name:        "subcommand",
+							Description: "The gallery_admin subcommand they may run, e.g. repair",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "similar",
+					Description: "Find galleries that mostly hold the same images, and merge them",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "threshold",
+							Description: "The percentage of the smaller gallery's images the larger one must have (default 80)",
+							Type:        discordgo.ApplicationCommandOptionInteger,
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:        "gallery_ops",
+			Description: "Bot operation tools for server administrators",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "config",
+					Description: "Show the settings the bot is running with, with secrets hidden",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "repost",
+					Description: "Post an image in a channel over and over, e.g. weekly",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery the image is in",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "channel",
+							Description: "The channel to post the image in",
+							Type:        discordgo.ApplicationCommandOptionChannel,
+							Required:    true,
+						},
+						{
+							Name:        "every",
+							Description: "How often to post it, like 1d, 1w, or 12h",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "image_number",
+							Description: "The image to post (default: one picked at random now)",
+							Type:        discordgo.ApplicationCommandOptionInteger,
+						},
+					},
+				},
+				{
+					Name:        "reposts",
+					Description: "List the scheduled reposts",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "cancel_repost",
+					Description: "Stop a scheduled repost or reveal",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "schedule_id",
+							Description: "The ID shown by reposts or reveals",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "ban_image",
+					Description: "Ban an image from every gallery, removing it wherever it already is",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "A gallery the image is in",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "image_number",
+							Description: "The image's number in that gallery",
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "unban",
+					Description: "Allow a banned image to be added again",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "hash",
+							Description: "The hash shown by bans",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "bans",
+					Description: "List the banned images",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "errors",
+					Description: "Show the most recent errors the bot has run into",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "response_check",
+					Description: "Check that deferred responses reach Discord",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
 				{
-					Name:        "random",
-					Description: "Send a random image from the chosen gallery",
+					Name:        "empties",
+					Description: "List the galleries with no images, and delete them all",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "fallback",
+					Description: "Set a placeholder image to show while a gallery is empty",
 					Type:        discordgo.ApplicationCommandOptionSubCommand,
 					Options: []*discordgo.ApplicationCommandOption{
 						{
 							Name:        "gallery_name",
-							Description: "The gallery to choose from",
+							Description: "The gallery to set the placeholder for",
 							Type:        discordgo.ApplicationCommandOptionString,
 							Required:    true,
 						},
+						{
+							Name:        "image_url",
+							Description: "A link to the placeholder image (leave out to remove it)",
+							Type:        discordgo.ApplicationCommandOptionString,
+						},
 					},
 				},
 				{
-					Name:        "pick",
-					Description: "Send the specified image from the chosen gallery",
+					Name:        "tag_rule",
+					Description: "Tag images automatically when their link matches a pattern",
 					Type:        discordgo.ApplicationCommandOptionSubCommand,
 					Options: []*discordgo.ApplicationCommandOption{
 						{
-							Name:        "gallery_name",
-							Description: "The gallery to choose from",
+							Name:        "pattern",
+							Description: "Text the link contains, or a host pattern like *.example.com",
 							Type:        discordgo.ApplicationCommandOptionString,
 							Required:    true,
 						},
 						{
-							Name:        "image_number",
-							Description: "The image you wish to choose",
-							Type:        discordgo.ApplicationCommandOptionInteger,
-							Required:    true,
+							Name:        "tag",
+							Description: "The tag to add (leave out to remove the pattern's rules)",
+							Type:        discordgo.ApplicationCommandOptionString,
 						},
 					},
 				},
 				{
-					Name:        "add_image",
-					Description: "Add the specified image to the chosen gallery",
+					Name:        "tag_rules",
+					Description: "List the automatic tagging rules",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "history",
+					Description: "Page through the recorded changes to a gallery",
 					Type:        discordgo.ApplicationCommandOptionSubCommand,
 					Options: []*discordgo.ApplicationCommandOption{
 						{
 							Name:        "gallery_name",
-							Description: "The gallery to add an image to",
-							Type:        discordgo.ApplicationCommandOptionString,
-							Required:    true,
-						},
-						{
-							Name:        "image_link",
-							Description: "The URL pointing to the image you wish to add",
+							Description: "The gallery to show the history of",
 							Type:        discordgo.ApplicationCommandOptionString,
 							Required:    true,
 						},
 					},
 				},
 				{
-					Name:        "remove_image",
-					Description: "Remove the specified image from the chosen gallery",
+					Name:        "mirror",
+					Description: "Post every image added to a gallery in a log channel",
 					Type:        discordgo.ApplicationCommandOptionSubCommand,
 					Options: []*discordgo.ApplicationCommandOption{
 						{
 							Name:        "gallery_name",
-							Description: "The gallery to remove an image from",
+							Description: "The gallery to mirror",
 							Type:        discordgo.ApplicationCommandOptionString,
 							Required:    true,
 						},
 						{
-							Name:        "image_number",
-							Description: "The image you wish to remove",
+							Name:        "channel",
+							Description: "The channel to post in (leave out to stop mirroring)",
+							Type:        discordgo.ApplicationCommandOptionChannel,
+						},
+						{
+							Name:        "hours",
+							Description: "Stop mirroring after this many hours (default: never)",
 							Type:        discordgo.ApplicationCommandOptionInteger,
-							Required:    true,
+							MaxValue:    maxMirrorHours,
 						},
 					},
 				},
 				{
-					Name:        "delete",
-					Description: "Delete an existing gallery",
+					Name:        "category",
+					Description: "Group galleries into a category for random_category",
 					Type:        discordgo.ApplicationCommandOptionSubCommand,
 					Options: []*discordgo.ApplicationCommandOption{
 						{
-							Name:        "gallery_name",
-							Description: "The name of the gallery to be deleted",
+							Name:        "name",
+							Description: "The category to set up",
 							Type:        discordgo.ApplicationCommandOptionString,
 							Required:    true,
 						},
+						{
+							Name:        "galleries",
+							Description: "Comma-separated galleries in the category (leave out to remove it)",
+							Type:        discordgo.ApplicationCommandOptionString,
+						},
 					},
 				},
 				{
-					Name:        "create",
-					Description: "Create a new gallery",
+					Name:        "settings",
+					Description: "Show the server's settings and flag any that refer to things that are gone",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "reveal",
+					Description: "Post an image in a channel once, at a time in the future",
 					Type:        discordgo.ApplicationCommandOptionSubCommand,
 					Options: []*discordgo.ApplicationCommandOption{
 						{
 							Name:        "gallery_name",
-							Description: "The name of the gallery to be created",
+							Description: "The gallery the image is in",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "image_number",
+							Description: "The image to reveal",
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Required:    true,
+						},
+						{
+							Name:        "channel",
+							Description: "The channel to post the image in",
+							Type:        discordgo.ApplicationCommandOptionChannel,
+							Required:    true,
+						},
+						{
+							Name:        "at",
+							Description: "When to post it: a UTC date like 2024-12-31 18:00, Unix time, or a delay like 2h",
 							Type:        discordgo.ApplicationCommandOptionString,
 							Required:    true,
 						},
 					},
 				},
+				{
+					Name:        "reveals",
+					Description: "List the pending reveals",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "guild_config",
+					Description: "Change this server's overrides, or show them when given no options",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Name:        "max_images",
+							Description: "Most images a gallery can hold when added to from this server, or 0 for no limit",
+							Required:    false,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionRole,
+							Name:        "admin_role",
+							Description: "Role whose members count as administrators (pick one already set to remove it)",
+							Required:    false,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionRole,
+							Name:        "manager_role",
+							Description: "Role allowed to create, delete, rename, and remove images (pick one already set to remove it)",
+							Required:    false,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionChannel,
+							Name:        "audit_channel",
+							Description: "Channel to log gallery changes made here in (pick the current one to stop)",
+							Required:    false,
+						},
+					},
+				},
 			},
 		},
 	}
 
-	commandHandlers = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
-		"gallery": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	commandHandlers = map[string]func(s discordSession, i *discordgo.InteractionCreate){
+		"gallery": func(s discordSession, i *discordgo.InteractionCreate) {
 			var data discordgo.InteractionResponseData
 
 			switch i.Type {
 			case discordgo.InteractionApplicationCommand:
 				command := i.ApplicationCommandData().Options[0]
+				if managedSubcommands[command.Name] && !canManageGalleries(i.Interaction) {
+					data = managerOnlyResponse(i.Interaction)
+					break
+				}
 
 				switch command.Name {
-				case "random":
-					data = getRandomImageFromGallery(i.Interaction)
-				case "pick":
-					data = getImageFromGallery(i.Interaction)
+				case "random", "pick":
+					work := getRandomImageFromGallery
+					if command.Name == "pick" {
+						work = getImageFromGallery
+					}
+					format := ""
+					if option := findOption(command.Options, "convert_to"); option != nil {
+						format = option.StringValue()
+					}
+					// A slow Firestore read, let alone downloading and re-encoding the image, can take longer than Discord waits for a response
+					respondDeferred(s, i.Interaction, func(i *discordgo.Interaction) discordgo.InteractionResponseData {
+						data := work(i)
+						if len(format) > 0 {
+							convertEmbedImage(&data, format)
+						}
+						return data
+					})
+					scheduleResponseDeletion(s, i.Interaction, command.Name)
+					return
 				case "add_image":
+					if verifyImageReachable || hashImageContent {
+						// Waiting on the image's host (let alone downloading the image to hash it) can take longer than Discord waits for a response
+						respondDeferred(s, i.Interaction, addImageToGallery)
+						scheduleResponseDeletion(s, i.Interaction, command.Name)
+						return
+					}
 					data = addImageToGallery(i.Interaction)
 				case "remove_image":
 					data = removeImagePrompt(i.Interaction)
+					data.Flags = messageFlagsEphemeral // The buttons carry the gallery and image number, so they work the same on a prompt only its author sees
 				case "create":
 					data = createGallery(i.Interaction)
 				case "delete":
 					data = deleteGalleryPrompt(i.Interaction)
-				default:
-					embed := discordgo.MessageEmbed{
-						Description: "Invalid subcommand :stop_sign:",
-						Color:       0xf04747,
+					data.Flags = messageFlagsEphemeral
+				case "popular":
+					data = getPopularImages(i.Interaction)
+				case "help":
+					data = getHelp(i.Interaction)
+				case "my_galleries":
+					data = getUserGalleries(i.Interaction)
+				case "featured":
+					data = getFeaturedImage(i.Interaction)
+				case "timeline":
+					data = getGalleryTimeline(i.Interaction)
+				case "challenge":
+					data = challengeFeaturedImage(i.Interaction)
+				case "carousel":
+					imageNum := 0
+					if option := findOption(command.Options, "image_number"); option != nil {
+						imageNum = int(option.IntValue())
 					}
-					data.Embeds = []*discordgo.MessageEmbed{&embed}
-					log.Warn().Interface("interaction", i.Interaction).Msg("Non-existent subcommand invoked")
+					data = carouselPage(i.Interaction, command.Options[0].StringValue(), imageNum)
+				case "set_alt":
+					data = setAltText(i.Interaction)
+				case "poll":
+					startPoll(s, i.Interaction)
+					return
+				case "link":
+					data = getImageLink(i.Interaction)
+				case "showcase":
+					data = getShowcase(i.Interaction)
+				case "random_category":
+					data = getRandomImageFromCategory(i.Interaction)
+				case "tag_stats":
+					data = tagStatsPage(i.Interaction, command.Options[0].StringValue(), 0)
+				case "list":
+					data = listGalleries(i.Interaction, 0)
+				case "browse":
+					data = browsePage(i.Interaction, command.Options[0].StringValue(), 0, 0)
+				case "info":
+					data = getGalleryInfo(i.Interaction)
+				case "rename":
+					data = renameGallery(i.Interaction)
+				case "copy":
+					data = copyGallery(i.Interaction)
+				case "merge":
+					data = mergeGalleryCommand(i.Interaction)
+					data.Flags = messageFlagsEphemeral // Like delete's prompt, which it may show
+				default:
+					data = invalidSubcommandResponse(i.Interaction)
 				}
 			default:
 				embed := discordgo.MessageEmbed{
@@ -833,82 +11692,496 @@ var (
 				log.Warn().Interface("interaction", i.Interaction).Msg("Unexpected interaction type")
 			}
 
-			err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseChannelMessageWithSource,
-				Data: &data,
-			})
+			err := respond(s, i.Interaction, discordgo.InteractionResponseChannelMessageWithSource, &data)
+			if err != nil {
+				log.Error().Err(err).Interface("interaction", i.Interaction).Msg("Failure in responding to interaction")
+			} else if i.Type == discordgo.InteractionApplicationCommand {
+				scheduleResponseDeletion(s, i.Interaction, i.ApplicationCommandData().Options[0].Name)
+			}
+		},
+		"find_image": func(s discordSession, i *discordgo.InteractionCreate) {
+			data := findImageByMessage(i.Interaction)
+
+			err := respond(s, i.Interaction, discordgo.InteractionResponseChannelMessageWithSource, &data)
+			if err != nil {
+				log.Error().Err(err).Interface("interaction", i.Interaction).Msg("Failure in responding to interaction")
+			}
+		},
+		"gallery_admin": func(s discordSession, i *discordgo.InteractionCreate) {
+			var data discordgo.InteractionResponseData
+
+			command := i.ApplicationCommandData().Options[0]
+			if !adminAllowed(i.Interaction, command.Name) {
+				data = adminOnlyResponse(i.Interaction)
+			} else {
+				switch command.Name {
+				case "audit_all":
+					respondDeferred(s, i.Interaction, func(i *discordgo.Interaction) discordgo.InteractionResponseData {
+						return auditAllGalleries(s, i)
+					})
+					return
+				case "orphans":
+					respondDeferred(s, i.Interaction, findOrphanedGalleries)
+					return
+				case "schedule_exclude":
+					data = setScheduleExclusion(i.Interaction)
+				case "restore":
+					respondDeferred(s, i.Interaction, restoreGallery)
+					return
+				case "import_channel":
+					respondDeferred(s, i.Interaction, func(i *discordgo.Interaction) discordgo.InteractionResponseData {
+						return importChannelImages(s, i)
+					})
+					return
+				case "dedupe":
+					data = dedupeGalleryPrompt(i.Interaction)
+				case "feature":
+					data = setFeaturedImage(i.Interaction)
+				case "repair":
+					data = repairGallery(i.Interaction)
+				case "export_all":
+					exportAllGalleries(s, i.Interaction)
+					return
+				case "formats":
+					data = setAllowedFormats(i.Interaction)
+				case "setup":
+					data = setupStarterGalleries(i.Interaction)
+				case "activity":
+					data = getGalleryActivity(i.Interaction)
+				case "set_timestamp":
+					data = setImageTimestamp(i.Interaction)
+				case "toggle_command":
+					// Re-registering the commands retries each failed Discord call with backoff, which can take longer than Discord waits for a response
+					respondDeferred(s, i.Interaction, toggleCommand)
+					return
+				case "sizes":
+					data = getGallerySizes(i.Interaction)
+				case "spotlight":
+					data = spotlightImage(i.Interaction)
+				case "diff":
+					data = diffGalleries(i.Interaction)
+				case "split_by_tag":
+					data = splitGalleryByTag(i.Interaction)
+				case "caption_bulk":
+					// Downloading the file can take longer than Discord waits for a response
+					respondDeferred(s, i.Interaction, setAltTextBulk)
+					return
+				case "similar":
+					respondDeferred(s, i.Interaction, findSimilarGalleries)
+					return
+				case "grant":
+					data = grantAdminSubcommand(i.Interaction)
+				case "lock":
+					data = setGalleryLock(i.Interaction, true)
+				case "pause":
+					data = setPaused(i.Interaction, true)
+				case "resume":
+					data = setPaused(i.Interaction, false)
+				case "unlock":
+					data = setGalleryLock(i.Interaction, false)
+				default:
+					data = invalidSubcommandResponse(i.Interaction)
+				}
+			}
+
+			err := respond(s, i.Interaction, discordgo.InteractionResponseChannelMessageWithSource, &data)
+			if err != nil {
+				log.Error().Err(err).Interface("interaction", i.Interaction).Msg("Failure in responding to interaction")
+			}
+		},
+		"gallery_ops": func(s discordSession, i *discordgo.InteractionCreate) {
+			var data discordgo.InteractionResponseData
+
+			command := i.ApplicationCommandData().Options[0]
+			if !adminAllowed(i.Interaction, command.Name) {
+				data = adminOnlyResponse(i.Interaction)
+			} else {
+				switch command.Name {
+				case "config":
+					data = showConfiguration(i.Interaction)
+				case "repost":
+					data = scheduleRepost(i.Interaction)
+				case "reposts":
+					data = listReposts(i.Interaction, 0)
+				case "cancel_repost":
+					data = cancelRepost(i.Interaction)
+				case "ban_image":
+					respondDeferred(s, i.Interaction, banImage)
+					return
+				case "unban":
+					data = unbanImage(i.Interaction)
+				case "bans":
+					data = listBannedImages(i.Interaction)
+				case "errors":
+					data = showRecentErrors(i.Interaction)
+				case "response_check":
+					respondDeferred(s, i.Interaction, checkResponseFlow)
+					return
+				case "empties":
+					data = findEmptyGalleries(i.Interaction)
+				case "fallback":
+					data = setFallbackImage(i.Interaction)
+				case "tag_rule":
+					data = setTagRule(i.Interaction)
+				case "tag_rules":
+					data = listTagRules(i.Interaction)
+				case "history":
+					data = auditHistoryPage(i.Interaction, command.Options[0].StringValue(), 0)
+				case "mirror":
+					data = setGalleryMirror(i.Interaction)
+				case "category":
+					data = setCategory(i.Interaction)
+				case "settings":
+					data = showGuildSettings(i.Interaction)
+				case "reveal":
+					data = scheduleReveal(i.Interaction)
+				case "reveals":
+					data = listReveals(i.Interaction)
+				case "guild_config":
+					data = setGuildConfig(i.Interaction)
+				default:
+					data = invalidSubcommandResponse(i.Interaction)
+				}
+			}
+
+			err := respond(s, i.Interaction, discordgo.InteractionResponseChannelMessageWithSource, &data)
 			if err != nil {
 				log.Error().Err(err).Interface("interaction", i.Interaction).Msg("Failure in responding to interaction")
 			}
 		},
 	}
 
-	componentHandlers = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
-		"gallery_delete_yes": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	componentHandlers = map[string]func(s discordSession, i *discordgo.InteractionCreate){
+		"gallery_delete_yes": func(s discordSession, i *discordgo.InteractionCreate) {
+			var data discordgo.InteractionResponseData
+			responseType := discordgo.InteractionResponseUpdateMessage
+			// Whoever can see the prompt can click it, and roles may have changed since it was shown
+			if !canManageGalleries(i.Interaction) {
+				data = managerOnlyResponse(i.Interaction)
+				responseType = discordgo.InteractionResponseChannelMessageWithSource
+			} else if state, ok := componentState(i.Interaction, 1); !ok {
+				respondOutdatedComponent(s, i.Interaction)
+				return
+			} else {
+				data = deleteGallery(i.Interaction, state[0])
+				data.Components = []discordgo.MessageComponent{}
+			}
+
+			err := respond(s, i.Interaction, responseType, &data)
+			if err != nil {
+				log.Error().Err(err).Interface("interaction", i.Interaction).Msg("Failure in responding to interaction")
+			}
+		},
+		"gallery_delete_large": func(s discordSession, i *discordgo.InteractionCreate) {
+			state, ok := componentState(i.Interaction, 1)
+			if !ok {
+				respondOutdatedComponent(s, i.Interaction)
+				return
+			}
+			data := deleteLargeGalleryPrompt(i.Interaction, state[0])
+
+			err := respond(s, i.Interaction, discordgo.InteractionResponseUpdateMessage, &data)
+			if err != nil {
+				log.Error().Err(err).Interface("interaction", i.Interaction).Msg("Failure in responding to interaction")
+			}
+		},
+		"gallery_dedupe_yes": func(s discordSession, i *discordgo.InteractionCreate) {
+			var data discordgo.InteractionResponseData
+			responseType := discordgo.InteractionResponseUpdateMessage
+			if !isAdmin(i.Interaction) && !useGrant(i.Interaction, "dedupe", true) {
+				data = adminOnlyResponse(i.Interaction)
+				responseType = discordgo.InteractionResponseChannelMessageWithSource
+			} else if state, ok := componentState(i.Interaction, 2); !ok {
+				respondOutdatedComponent(s, i.Interaction)
+				return
+			} else {
+				data = dedupeGallery(i.Interaction, state[0], state[1] == "loose")
+				data.Components = []discordgo.MessageComponent{}
+			}
+
+			err := respond(s, i.Interaction, responseType, &data)
+			if err != nil {
+				log.Error().Err(err).Interface("interaction", i.Interaction).Msg("Failure in responding to interaction")
+			}
+		},
+		"gallery_merge": func(s discordSession, i *discordgo.InteractionCreate) {
+			var data discordgo.InteractionResponseData
+			responseType := discordgo.InteractionResponseUpdateMessage
+			if !isAdmin(i.Interaction) && !useGrant(i.Interaction, "similar", true) {
+				data = adminOnlyResponse(i.Interaction)
+				responseType = discordgo.InteractionResponseChannelMessageWithSource
+			} else if !strings.Contains(i.MessageComponentData().CustomID, ":") {
+				// The embed lists several pairs, so there's nothing to fall back on
+				respondOutdatedComponent(s, i.Interaction)
+				return
+			} else if state, ok := componentState(i.Interaction, 2); !ok {
+				respondOutdatedComponent(s, i.Interaction)
+				return
+			} else {
+				data = mergeGalleries(i.Interaction, state[0], state[1], true)
+				data.Components = []discordgo.MessageComponent{}
+			}
+
+			err := respond(s, i.Interaction, responseType, &data)
+			if err != nil {
+				log.Error().Err(err).Interface("interaction", i.Interaction).Msg("Failure in responding to interaction")
+			}
+		},
+		"gallery_merge_yes": func(s discordSession, i *discordgo.InteractionCreate) {
 			var data discordgo.InteractionResponseData
-			galleryName := i.Message.Embeds[0].Fields[0].Value
-			galleryName = strings.Trim(galleryName, "`")
-			data = deleteGallery(i.Interaction, galleryName)
-			data.Components = []discordgo.MessageComponent{}
-
-			err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseUpdateMessage,
-				Data: &data,
+			responseType := discordgo.InteractionResponseUpdateMessage
+			// Whoever can see the prompt can click it, and roles may have changed since it was shown
+			if !canManageGalleries(i.Interaction) {
+				data = managerOnlyResponse(i.Interaction)
+				responseType = discordgo.InteractionResponseChannelMessageWithSource
+			} else if state, ok := componentState(i.Interaction, 2); !ok {
+				respondOutdatedComponent(s, i.Interaction)
+				return
+			} else {
+				data = mergeGalleries(i.Interaction, state[0], state[1], true)
+				data.Components = []discordgo.MessageComponent{}
+			}
+
+			err := respond(s, i.Interaction, responseType, &data)
+			if err != nil {
+				log.Error().Err(err).Interface("interaction", i.Interaction).Msg("Failure in responding to interaction")
+			}
+		},
+		"gallery_merge_no": func(s discordSession, i *discordgo.InteractionCreate) {
+			state, ok := componentState(i.Interaction, 2)
+			if !ok {
+				respondOutdatedComponent(s, i.Interaction)
+				return
+			}
+			embed := discordgo.MessageEmbed{
+				Description: fmt.Sprintf("Cancelled merging `%s` into `%s`.", state[1], state[0]),
+			}
+
+			err := respond(s, i.Interaction, discordgo.InteractionResponseUpdateMessage, &discordgo.InteractionResponseData{
+				Embeds:     []*discordgo.MessageEmbed{&embed},
+				Components: []discordgo.MessageComponent{},
 			})
 			if err != nil {
 				log.Error().Err(err).Interface("interaction", i.Interaction).Msg("Failure in responding to interaction")
 			}
 		},
-		"gallery_delete_no": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-			galleryName := i.Message.Embeds[0].Fields[0].Value
-			galleryName = strings.Trim(galleryName, "`")
+		"featured_swap": func(s discordSession, i *discordgo.InteractionCreate) {
+			var data discordgo.InteractionResponseData
+			responseType := discordgo.InteractionResponseUpdateMessage
+			if !isAdmin(i.Interaction) {
+				data = adminOnlyResponse(i.Interaction)
+				responseType = discordgo.InteractionResponseChannelMessageWithSource
+			} else if state, ok := componentState(i.Interaction, 2); !ok || len(i.Message.Embeds) < 2 || i.Message.Embeds[1].Image == nil {
+				respondOutdatedComponent(s, i.Interaction)
+				return
+			} else {
+				challengerIndex, _ := strconv.Atoi(state[1])
+				data = featureChallenger(i.Interaction, state[0], challengerIndex, i.Message.Embeds[1].Image.URL)
+				data.Components = []discordgo.MessageComponent{}
+			}
+
+			err := respond(s, i.Interaction, responseType, &data)
+			if err != nil {
+				log.Error().Err(err).Interface("interaction", i.Interaction).Msg("Failure in responding to interaction")
+			}
+		},
+		"image_prev": func(s discordSession, i *discordgo.InteractionCreate) {
+			respondBrowseMove(s, i.Interaction, -1)
+		},
+		"image_next": func(s discordSession, i *discordgo.InteractionCreate) {
+			respondBrowseMove(s, i.Interaction, 1)
+		},
+		"gallery_list_previous": func(s discordSession, i *discordgo.InteractionCreate) {
+			respondGalleryListMove(s, i.Interaction, -1)
+		},
+		"gallery_list_next": func(s discordSession, i *discordgo.InteractionCreate) {
+			respondGalleryListMove(s, i.Interaction, 1)
+		},
+		"tag_stats_previous": func(s discordSession, i *discordgo.InteractionCreate) {
+			respondTagStatsMove(s, i.Interaction, -1)
+		},
+		"tag_stats_next": func(s discordSession, i *discordgo.InteractionCreate) {
+			respondTagStatsMove(s, i.Interaction, 1)
+		},
+		"reposts_previous": func(s discordSession, i *discordgo.InteractionCreate) {
+			respondRepostsMove(s, i.Interaction, -1)
+		},
+		"reposts_next": func(s discordSession, i *discordgo.InteractionCreate) {
+			respondRepostsMove(s, i.Interaction, 1)
+		},
+		"history_previous": func(s discordSession, i *discordgo.InteractionCreate) {
+			respondHistoryMove(s, i.Interaction, -1)
+		},
+		"history_next": func(s discordSession, i *discordgo.InteractionCreate) {
+			respondHistoryMove(s, i.Interaction, 1)
+		},
+		"carousel_previous": func(s discordSession, i *discordgo.InteractionCreate) {
+			respondCarouselMove(s, i.Interaction, func(current int, _ int) int { return current - 1 })
+		},
+		"carousel_next": func(s discordSession, i *discordgo.InteractionCreate) {
+			respondCarouselMove(s, i.Interaction, func(current int, _ int) int { return current + 1 })
+		},
+		"carousel_random": func(s discordSession, i *discordgo.InteractionCreate) {
+			respondCarouselMove(s, i.Interaction, carouselRandomJump)
+		},
+		"carousel_jump": func(s discordSession, i *discordgo.InteractionCreate) {
+			respondCarouselMove(s, i.Interaction, func(current int, _ int) int {
+				if values := i.MessageComponentData().Values; len(values) > 0 {
+					if chosen, err := strconv.Atoi(values[0]); err == nil {
+						return chosen
+					}
+				}
+				return current
+			})
+		},
+		"featured_keep": func(s discordSession, i *discordgo.InteractionCreate) {
+			state, ok := componentState(i.Interaction, 1)
+			if !ok {
+				respondOutdatedComponent(s, i.Interaction)
+				return
+			}
 			embed := discordgo.MessageEmbed{
-				Description: fmt.Sprintf("Cancelled removal of gallery `%s`.", galleryName),
+				Description: fmt.Sprintf("The featured image of gallery `%s` holds its place :shield:", state[0]),
+			}
+			if len(i.Message.Embeds) > 0 {
+				embed.Image = i.Message.Embeds[0].Image
 			}
 
-			err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseUpdateMessage,
-				Data: &discordgo.InteractionResponseData{
-					Embeds:     []*discordgo.MessageEmbed{&embed},
-					Components: []discordgo.MessageComponent{},
-				},
+			err := respond(s, i.Interaction, discordgo.InteractionResponseUpdateMessage, &discordgo.InteractionResponseData{
+				Embeds:     []*discordgo.MessageEmbed{&embed},
+				Components: []discordgo.MessageComponent{},
+			})
+			if err != nil {
+				log.Error().Err(err).Interface("interaction", i.Interaction).Msg("Failure in responding to interaction")
+			}
+		},
+		"gallery_dedupe_no": func(s discordSession, i *discordgo.InteractionCreate) {
+			state, ok := componentState(i.Interaction, 1)
+			if !ok {
+				respondOutdatedComponent(s, i.Interaction)
+				return
+			}
+			embed := discordgo.MessageEmbed{
+				Description: fmt.Sprintf("Cancelled removal of duplicates from gallery `%s`.", state[0]),
+			}
+
+			err := respond(s, i.Interaction, discordgo.InteractionResponseUpdateMessage, &discordgo.InteractionResponseData{
+				Embeds:     []*discordgo.MessageEmbed{&embed},
+				Components: []discordgo.MessageComponent{},
 			})
 			if err != nil {
 				log.Error().Err(err).Interface("interaction", i.Interaction).Msg("Failure in responding to interaction")
 			}
 		},
-		"image_delete_yes": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		"gallery_delete_empties": func(s discordSession, i *discordgo.InteractionCreate) {
 			var data discordgo.InteractionResponseData
-			galleryName := i.Message.Embeds[0].Fields[0].Value
-			galleryName = strings.Trim(galleryName, "`")
-			imageNumStr := i.Message.Embeds[0].Fields[1].Value
-			imageNum, _ := strconv.Atoi(imageNumStr)
+			responseType := discordgo.InteractionResponseUpdateMessage
+			if !isAdmin(i.Interaction) && !useGrant(i.Interaction, "empties", true) {
+				data = adminOnlyResponse(i.Interaction)
+				responseType = discordgo.InteractionResponseChannelMessageWithSource
+			} else if state, ok := componentState(i.Interaction, 1); !ok || !strings.Contains(i.MessageComponentData().CustomID, ":") {
+				respondOutdatedComponent(s, i.Interaction)
+				return
+			} else if listedAt, err := strconv.ParseInt(state[0], 10, 64); err != nil {
+				respondOutdatedComponent(s, i.Interaction)
+				return
+			} else {
+				data = deleteEmptyGalleries(i.Interaction, time.Unix(0, listedAt))
+				data.Components = []discordgo.MessageComponent{}
+			}
 
-			data = removeImage(i.Interaction, galleryName, imageNum)
-			data.Components = []discordgo.MessageComponent{}
+			err := respond(s, i.Interaction, responseType, &data)
+			if err != nil {
+				log.Error().Err(err).Interface("interaction", i.Interaction).Msg("Failure in responding to interaction")
+			}
+		},
+		"gallery_keep_empties": func(s discordSession, i *discordgo.InteractionCreate) {
+			embed := discordgo.MessageEmbed{
+				Description: "Cancelled removal of the empty galleries.",
+			}
 
-			err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseUpdateMessage,
-				Data: &data,
+			err := respond(s, i.Interaction, discordgo.InteractionResponseUpdateMessage, &discordgo.InteractionResponseData{
+				Embeds:     []*discordgo.MessageEmbed{&embed},
+				Components: []discordgo.MessageComponent{},
 			})
 			if err != nil {
 				log.Error().Err(err).Interface("interaction", i.Interaction).Msg("Failure in responding to interaction")
 			}
 		},
-		"image_delete_no": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-			galleryName := i.Message.Embeds[0].Fields[0].Value
-			galleryName = strings.Trim(galleryName, "`")
-			imageNum := i.Message.Embeds[0].Fields[1].Value
+		"gallery_delete_no": func(s discordSession, i *discordgo.InteractionCreate) {
+			state, ok := componentState(i.Interaction, 1)
+			if !ok {
+				respondOutdatedComponent(s, i.Interaction)
+				return
+			}
 			embed := discordgo.MessageEmbed{
-				Description: fmt.Sprintf("Cancelled removal of image `%s` from gallery `%s`.", imageNum, galleryName),
+				Description: fmt.Sprintf("Cancelled removal of gallery `%s`.", state[0]),
 			}
 
-			err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseUpdateMessage,
-				Data: &discordgo.InteractionResponseData{
-					Embeds:     []*discordgo.MessageEmbed{&embed},
-					Components: []discordgo.MessageComponent{},
-				},
+			err := respond(s, i.Interaction, discordgo.InteractionResponseUpdateMessage, &discordgo.InteractionResponseData{
+				Embeds:     []*discordgo.MessageEmbed{&embed},
+				Components: []discordgo.MessageComponent{},
+			})
+			if err != nil {
+				log.Error().Err(err).Interface("interaction", i.Interaction).Msg("Failure in responding to interaction")
+			}
+		},
+		"image_delete_yes": func(s discordSession, i *discordgo.InteractionCreate) {
+			var data discordgo.InteractionResponseData
+			responseType := discordgo.InteractionResponseUpdateMessage
+			// Whoever can see the prompt can click it, and roles may have changed since it was shown
+			if !canManageGalleries(i.Interaction) {
+				data = managerOnlyResponse(i.Interaction)
+				responseType = discordgo.InteractionResponseChannelMessageWithSource
+			} else if state, ok := componentState(i.Interaction, 2); !ok {
+				respondOutdatedComponent(s, i.Interaction)
+				return
+			} else {
+				imageNum, _ := strconv.Atoi(state[1])
+				expectedUrl := ""
+				if len(i.Message.Embeds) > 0 && i.Message.Embeds[0].Image != nil {
+					expectedUrl = i.Message.Embeds[0].Image.URL
+				}
+
+				data = removeImage(i.Interaction, state[0], imageNum, expectedUrl)
+				if data.Components == nil {
+					data.Components = []discordgo.MessageComponent{} // Drop the confirmation buttons, unless it's offering to undo
+				}
+			}
+
+			err := respond(s, i.Interaction, responseType, &data)
+			if err != nil {
+				log.Error().Err(err).Interface("interaction", i.Interaction).Msg("Failure in responding to interaction")
+			}
+		},
+		"image_undo": func(s discordSession, i *discordgo.InteractionCreate) {
+			var data discordgo.InteractionResponseData
+			responseType := discordgo.InteractionResponseUpdateMessage
+			if !canManageGalleries(i.Interaction) {
+				data = managerOnlyResponse(i.Interaction)
+				responseType = discordgo.InteractionResponseChannelMessageWithSource
+			} else {
+				data = undoImageRemoval(i.Interaction)
+			}
+
+			err := respond(s, i.Interaction, responseType, &data)
+			if err != nil {
+				log.Error().Err(err).Interface("interaction", i.Interaction).Msg("Failure in responding to interaction")
+			}
+		},
+		"image_delete_no": func(s discordSession, i *discordgo.InteractionCreate) {
+			state, ok := componentState(i.Interaction, 2)
+			if !ok {
+				respondOutdatedComponent(s, i.Interaction)
+				return
+			}
+			embed := discordgo.MessageEmbed{
+				Description: fmt.Sprintf("Cancelled removal of image `%s` from gallery `%s`.", state[1], state[0]),
+			}
+
+			err := respond(s, i.Interaction, discordgo.InteractionResponseUpdateMessage, &discordgo.InteractionResponseData{
+				Embeds:     []*discordgo.MessageEmbed{&embed},
+				Components: []discordgo.MessageComponent{},
 			})
 			if err != nil {
 				log.Error().Err(err).Interface("interaction", i.Interaction).Msg("Failure in responding to interaction")
@@ -926,19 +12199,62 @@ func main() {
 	}
 	defer firestoreClient.Close()
 
+	if firestoreSelfTest != "off" {
+		err = checkFirestorePermissions()
+		if err != nil && firestoreSelfTest == "fatal" {
+			log.Fatal().Err(err).Msg("Firestore self-test failed, check the credentials' IAM roles and the security rules")
+		} else if err != nil {
+			log.Warn().Err(err).Msg("Firestore self-test failed, check the credentials' IAM roles and the security rules")
+		} else {
+			log.Info().Msg("Firestore self-test passed")
+		}
+	}
+
 	s, err = discordgo.New("Bot " + config["botToken"])
 	if err != nil {
 		log.Fatal().Err(err).Msg("Invalid bot parameters")
 	}
 
 	s.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		if i.Type == discordgo.InteractionApplicationCommandAutocomplete {
+			respondGalleryAutocomplete(s, i.Interaction) // Suggesting gallery names changes nothing, so none of the checks below apply
+			return
+		}
+		noteInteractionCommand(i.Interaction)
+		if len(i.GuildID) == 0 {
+			respondGuildOnly(s, i.Interaction) // Global commands can be used in direct messages too
+			return
+		}
+		homeGuild := i.GuildID == config["guildId"]
+		if multiGuild && !homeGuild && homeGuildOnly(i.Interaction) {
+			respondHomeGuildOnly(s, i.Interaction)
+			return
+		}
+		// Pausing applies to every guild, so only the home guild's administrators may carry on
+		if atomic.LoadInt32(&paused) == 1 && !(homeGuild && isAdmin(i.Interaction)) {
+			respondPaused(s, i.Interaction)
+			return
+		}
 		switch i.Type {
 		case discordgo.InteractionApplicationCommand:
+			if options := i.ApplicationCommandData().Options; len(options) > 0 && !featureEnabled(options[0].Name) {
+				respondFeatureDisabled(s, i.Interaction)
+				return
+			}
+			if options := i.ApplicationCommandData().Options; len(options) > 0 && atomic.LoadInt64(&galleryCount) == 0 && targetsExistingGallery(options[0]) {
+				respondNoGalleries(s, i.Interaction)
+				return
+			}
 			if h, ok := commandHandlers[i.ApplicationCommandData().Name]; ok {
 				h(s, i)
 			}
+			if options := i.ApplicationCommandData().Options; len(options) > 0 {
+				if option := findOption(options[0].Options, "gallery_name"); option != nil {
+					go noteGalleryActivity(option.StringValue(), interactionUserID(i.Interaction))
+				}
+			}
 		case discordgo.InteractionMessageComponent:
-			if h, ok := componentHandlers[i.MessageComponentData().CustomID]; ok {
+			if h, ok := componentHandlers[strings.SplitN(i.MessageComponentData().CustomID, ":", 2)[0]]; ok {
 				h(s, i)
 			}
 		}
@@ -954,10 +12270,49 @@ func main() {
 
 	defer s.Close()
 
-	updateCommands()
+	loadGuildSettings()
+	_, err = refreshGalleryCache()
+	if err != nil {
+		log.Warn().Err(err).Msg("Cannot load gallery names, trying again on the next update")
+	}
+	err = updateCommands()
+	if err != nil {
+		log.Warn().Err(err).Msg("Starting with commands that may be out of date")
+	}
+
+	stopFlushing := make(chan struct{})
+	flushDone := make(chan struct{})
+	go func() {
+		flushViewsPeriodically(stopFlushing)
+		close(flushDone)
+	}()
+	go refreshAttachmentsPeriodically(stopFlushing)
+	go refreshGalleryCachePeriodically(stopFlushing)
 
-	stop := make(chan os.Signal)
+	var webhookServer *http.Server
+	if len(webhookAddress) > 0 {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/images", handleWebhookImage)
+		webhookServer = &http.Server{Addr: webhookAddress, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			err := webhookServer.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Error().Err(err).Str("address", webhookAddress).Msg("Webhook server stopped")
+			}
+		}()
+		log.Info().Str("address", webhookAddress).Msg("Accepting images from webhooks")
+	}
+	go runRepostsPeriodically(stopFlushing)
+
+	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt)
 	<-stop
 	log.Info().Msg("Exiting gracefully")
+	close(stopFlushing)
+	if webhookServer != nil {
+		shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		webhookServer.Shutdown(shutdownCtx)
+		cancel()
+	}
+	<-flushDone // Don't lose buffered view counts on shutdown
 }
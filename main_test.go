@@ -0,0 +1,1038 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"cloud.google.com/go/firestore"
+	"github.com/bwmarrin/discordgo"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+)
+
+// The init functions in main.go exit when a required environment value is missing, so give them placeholders
+// Package-level variables are initialized before any init function runs.
+var _ = func() bool {
+	for key := range config {
+		if _, isPresent := os.LookupEnv(key); !isPresent {
+			os.Setenv(key, "test")
+		}
+	}
+	return true
+}()
+
+// registeredCopy round-trips command through JSON, the way Discord hands back what was registered
+func registeredCopy(t *testing.T, command *discordgo.ApplicationCommand) *discordgo.ApplicationCommand {
+	t.Helper()
+	raw, err := json.Marshal(command)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var registered discordgo.ApplicationCommand
+	if err := json.Unmarshal(raw, &registered); err != nil {
+		t.Fatal(err)
+	}
+	registered.ID = "1"
+	registered.ApplicationID = "2"
+	registered.Version = "3"
+	return &registered
+}
+
+func TestCommandsEqualMatchesRegisteredCopies(t *testing.T) {
+	for _, command := range commands {
+		if !commandsEqual(registeredCopy(t, command), command) {
+			t.Errorf("'%s' differs from its registered copy", command.Name)
+		}
+	}
+}
+
+func TestCommandsEqual(t *testing.T) {
+	desired := func() *discordgo.ApplicationCommand {
+		return &discordgo.ApplicationCommand{
+			Name:        "gallery",
+			Description: "Server-wide image gallery",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "random",
+					Description: "Send a random image",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "gallery_name",
+							Description: "The gallery",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+							Choices: []*discordgo.ApplicationCommandOptionChoice{
+								{Name: "memes", Value: "memes"},
+							},
+						},
+						{
+							Name:        "count",
+							Description: "How many",
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Choices: []*discordgo.ApplicationCommandOptionChoice{
+								{Name: "one", Value: 1},
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func(registered *discordgo.ApplicationCommand)
+		equal  bool
+	}{
+		{"unchanged", func(registered *discordgo.ApplicationCommand) {}, true},
+		{"integer choice decoded as float64", func(registered *discordgo.ApplicationCommand) {
+			registered.Options[0].Options[1].Choices[0].Value = float64(1)
+		}, true},
+		{"discord-assigned fields", func(registered *discordgo.ApplicationCommand) {
+			registered.ID = "4"
+			registered.Version = "5"
+		}, true},
+		{"command description", func(registered *discordgo.ApplicationCommand) {
+			registered.Description = "Old description"
+		}, false},
+		{"subcommand removed", func(registered *discordgo.ApplicationCommand) {
+			registered.Options = nil
+		}, false},
+		{"option renamed", func(registered *discordgo.ApplicationCommand) {
+			registered.Options[0].Options[0].Name = "gallery"
+		}, false},
+		{"option no longer required", func(registered *discordgo.ApplicationCommand) {
+			registered.Options[0].Options[0].Required = false
+		}, false},
+		{"option type", func(registered *discordgo.ApplicationCommand) {
+			registered.Options[0].Options[1].Type = discordgo.ApplicationCommandOptionString
+		}, false},
+		{"choice added", func(registered *discordgo.ApplicationCommand) {
+			registered.Options[0].Options[0].Choices = append(registered.Options[0].Options[0].Choices, &discordgo.ApplicationCommandOptionChoice{Name: "art", Value: "art"})
+		}, false},
+		{"choice value", func(registered *discordgo.ApplicationCommand) {
+			registered.Options[0].Options[0].Choices[0].Value = "pets"
+		}, false},
+		{"autocomplete", func(registered *discordgo.ApplicationCommand) {
+			registered.Options[0].Options[0].Autocomplete = true
+		}, false},
+		{"max value", func(registered *discordgo.ApplicationCommand) {
+			registered.Options[0].Options[1].MaxValue = 10
+		}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registered := registeredCopy(t, desired())
+			test.change(registered)
+			if got := commandsEqual(registered, desired()); got != test.equal {
+				t.Errorf("commandsEqual() = %v, want %v", got, test.equal)
+			}
+		})
+	}
+}
+
+func TestPopulateGalleryChoices(t *testing.T) {
+	galleryNamesMutex.Lock()
+	saved := galleryNames
+	galleryNames = map[string]bool{"memes": true, "art": true, "pets": true, "memories": true}
+	for n := 0; n < maxAutocompleteChoices; n++ {
+		galleryNames[fmt.Sprintf("many-%02d", n)] = true
+	}
+	galleryNamesMutex.Unlock()
+	t.Cleanup(func() {
+		galleryNamesMutex.Lock()
+		galleryNames = saved
+		galleryNamesMutex.Unlock()
+	})
+
+	names := func(choices []*discordgo.ApplicationCommandOptionChoice) (names []string) {
+		for _, choice := range choices {
+			names = append(names, choice.Name)
+		}
+		return names
+	}
+	if got := names(populateGalleryChoices("mem")); fmt.Sprint(got) != "[memes memories]" {
+		t.Errorf("populateGalleryChoices(\"mem\") = %v, want [memes memories]", got)
+	}
+	if got := names(populateGalleryChoices("PE")); fmt.Sprint(got) != "[pets]" {
+		t.Errorf("populateGalleryChoices(\"PE\") = %v, want [pets]", got)
+	}
+	if got := populateGalleryChoices("x"); len(got) != 0 {
+		t.Errorf("populateGalleryChoices(\"x\") = %v, want none", names(got))
+	}
+	if got := populateGalleryChoices(""); len(got) != maxAutocompleteChoices {
+		t.Errorf("populateGalleryChoices(\"\") offered %d galleries, want %d", len(got), maxAutocompleteChoices)
+	}
+}
+
+func TestMergeImageMetadataAltText(t *testing.T) {
+	tests := []struct {
+		name      string
+		kept      string
+		duplicate string
+		want      string
+	}{
+		{"kept has none", "", "A cat", "A cat"},
+		{"distinct", "A cat", "A sleeping cat", "A cat / A sleeping cat"},
+		{"already included", "A cat / A dog", "A dog", "A cat / A dog"},
+		{"joined fits exactly", strings.Repeat("a", maxAltTextLength-4), "b", strings.Repeat("a", maxAltTextLength-4) + " / b"},
+		{"joined too long", strings.Repeat("a", maxAltTextLength-3), "b", strings.Repeat("a", maxAltTextLength-3)},
+		{"counted in characters, not bytes", strings.Repeat("é", maxAltTextLength-4), "b", strings.Repeat("é", maxAltTextLength-4) + " / b"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kept := Image{ImageURL: "https://example.com/a.png", Alt: test.kept}
+			mergeImageMetadata(&kept, Image{ImageURL: "https://example.com/a.png", Alt: test.duplicate})
+			if kept.Alt != test.want {
+				t.Errorf("merged alt text = %q, want %q", kept.Alt, test.want)
+			}
+			if n := utf8.RuneCountInString(kept.Alt); n > maxAltTextLength {
+				t.Errorf("merged alt text is %d characters, more than %d", n, maxAltTextLength)
+			}
+		})
+	}
+}
+
+// useFirestoreEmulator points firestoreClient at the emulator named by FIRESTORE_EMULATOR_HOST for the rest of the test, skipping the test if there is none
+// e.g. gcloud emulators firestore start --host-port=localhost:8200, then FIRESTORE_EMULATOR_HOST=localhost:8200 go test ./...
+func useFirestoreEmulator(t *testing.T) {
+	t.Helper()
+	if len(os.Getenv("FIRESTORE_EMULATOR_HOST")) == 0 {
+		t.Skip("FIRESTORE_EMULATOR_HOST is not set")
+	}
+	client, err := firestore.NewClient(context.Background(), "gallerygopher-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := firestoreClient
+	firestoreClient = client
+	t.Cleanup(func() {
+		firestoreClient = previous
+		client.Close()
+	})
+}
+
+// createTestGallery creates an empty gallery with a name no other test uses
+func createTestGallery(t *testing.T) string {
+	t.Helper()
+	galleryName := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	_, err := getGalleryDocRef(galleryName).Create(context.Background(), Gallery{CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return galleryName
+}
+
+func TestAddImageConcurrently(t *testing.T) {
+	useFirestoreEmulator(t)
+	galleryName := createTestGallery(t)
+
+	const adders = 8
+	var wg sync.WaitGroup
+	errs := make([]error, adders)
+	for n := 0; n < adders; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			_, errs[n] = addImage(galleryName, Image{
+				ImageURL:  fmt.Sprintf("https://example.com/%d.png", n),
+				Timestamp: fmt.Sprint(time.Now().Unix()),
+				AuthorID:  "1",
+			}, 0)
+		}(n)
+	}
+	wg.Wait()
+	for n, err := range errs {
+		if err != nil {
+			t.Errorf("adding image %d: %v", n, err)
+		}
+	}
+
+	docSnap, err := getGalleryDocRef(galleryName).Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gallery Gallery
+	if err := docSnap.DataTo(&gallery); err != nil {
+		t.Fatal(err)
+	}
+	present := make(map[string]bool)
+	for _, image := range gallery.Images {
+		present[image.ImageURL] = true
+	}
+	for n := 0; n < adders; n++ {
+		if imageUrl := fmt.Sprintf("https://example.com/%d.png", n); !present[imageUrl] {
+			t.Errorf("%s was lost", imageUrl)
+		}
+	}
+	if len(gallery.Images) != adders {
+		t.Errorf("gallery has %d images, want %d", len(gallery.Images), adders)
+	}
+}
+
+// testInteraction builds a /gallery command interaction invoking subcommand with options, as a member of the configured guild
+func testInteraction(subcommand string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.Interaction {
+	return commandInteraction("gallery", subcommand, options...)
+}
+
+// commandInteraction builds an interaction invoking subcommand of command with options, as a member of the configured guild
+func commandInteraction(command string, subcommand string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.Interaction {
+	return &discordgo.Interaction{
+		ID:      fmt.Sprint(time.Now().UnixNano()),
+		Type:    discordgo.InteractionApplicationCommand,
+		GuildID: config["guildId"],
+		Member:  &discordgo.Member{User: &discordgo.User{ID: "1"}},
+		Data: discordgo.ApplicationCommandInteractionData{
+			Name: command,
+			Options: []*discordgo.ApplicationCommandInteractionDataOption{
+				{
+					Name:    subcommand,
+					Type:    discordgo.ApplicationCommandOptionSubCommand,
+					Options: options,
+				},
+			},
+		},
+	}
+}
+
+func stringOption(name string, value string) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionString, Value: value}
+}
+
+// integerOption holds value the way Discord's JSON decodes it, as a float64
+func integerOption(name string, value int) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionInteger, Value: float64(value)}
+}
+
+// TestPickWhileCompacting picks every image of a gallery while dedupe halves it, checking each pick showed an image that really was at that number either before or after
+func TestPickWhileCompacting(t *testing.T) {
+	useFirestoreEmulator(t)
+	galleryName := createTestGallery(t)
+
+	const distinct = 20
+	var before, after []Image
+	for n := 0; n < distinct; n++ {
+		image := Image{ImageURL: fmt.Sprintf("https://example.com/%d.png", n), Timestamp: fmt.Sprint(n), AuthorID: "1"}
+		before = append(before, image, image)
+		after = append(after, image)
+	}
+	_, err := getGalleryDocRef(galleryName).Set(context.Background(), Gallery{Images: before, CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var deduped discordgo.InteractionResponseData
+	picks := make([]discordgo.InteractionResponseData, len(before))
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		deduped = dedupeGallery(testInteraction("dedupe", stringOption("gallery_name", galleryName)), galleryName, false)
+	}()
+	for n := range before {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			picks[n] = getImageFromGallery(testInteraction("pick", stringOption("gallery_name", galleryName), integerOption("image_number", n)))
+		}(n)
+	}
+	wg.Wait()
+
+	if want := fmt.Sprintf("Removed %d duplicate images", distinct); !strings.HasPrefix(deduped.Embeds[0].Description, want) {
+		t.Fatalf("dedupe said %q, want it to start with %q", deduped.Embeds[0].Description, want)
+	}
+	for n, pick := range picks {
+		embed := pick.Embeds[0]
+		switch {
+		case embed.Image == nil:
+			if n < distinct || !strings.HasPrefix(embed.Description, "Invalid image number") {
+				t.Errorf("pick %d was refused: %q", n, embed.Description)
+			}
+		case embed.Footer.Text == fmt.Sprintf("Image: %d of %d | Gallery: %s", n, len(before)-1, galleryName):
+			if want := displayImageURL(before[n].ImageURL); embed.Image.URL != want {
+				t.Errorf("pick %d before dedupe showed %s, want %s", n, embed.Image.URL, want)
+			}
+		case embed.Footer.Text == fmt.Sprintf("Image: %d of %d | Gallery: %s", n, len(after)-1, galleryName):
+			if want := displayImageURL(after[n].ImageURL); embed.Image.URL != want {
+				t.Errorf("pick %d after dedupe showed %s, want %s", n, embed.Image.URL, want)
+			}
+		default:
+			t.Errorf("pick %d showed %q", n, embed.Footer.Text)
+		}
+	}
+}
+
+// fakeSession records what the handlers send in place of Discord
+// Methods it doesn't define fall through to the nil embedded interface and panic, so a handler reaching for more than a test expects fails loudly.
+type fakeSession struct {
+	discordSession
+	mutex       sync.Mutex
+	responses   []*discordgo.InteractionResponse
+	respondedAt time.Time // When the first response was sent
+	edits       []*discordgo.WebhookEdit
+	editedAt    time.Time // When the latest edit was sent
+	followups   []*discordgo.WebhookParams
+	deletions   int
+}
+
+func (f *fakeSession) InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if len(f.responses) == 0 {
+		f.respondedAt = time.Now()
+	}
+	f.responses = append(f.responses, resp)
+	return nil
+}
+
+func (f *fakeSession) InteractionResponseEdit(appID string, interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit) (*discordgo.Message, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.edits = append(f.edits, newresp)
+	f.editedAt = time.Now()
+	return &discordgo.Message{}, nil
+}
+
+func (f *fakeSession) FollowupMessageCreate(appID string, interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams) (*discordgo.Message, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.followups = append(f.followups, data)
+	return &discordgo.Message{}, nil
+}
+
+func (f *fakeSession) InteractionResponseDelete(appID string, interaction *discordgo.Interaction) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.deletions++
+	return nil
+}
+
+func embedDescriptions(embeds []*discordgo.MessageEmbed) []string {
+	var descriptions []string
+	for _, v := range embeds {
+		descriptions = append(descriptions, v.Description)
+	}
+	return descriptions
+}
+
+func TestRespondDeferred(t *testing.T) {
+	session := &fakeSession{}
+	i := testInteraction("random")
+	embed := discordgo.MessageEmbed{Description: "Done"}
+	respondDeferred(session, i, func(i *discordgo.Interaction) discordgo.InteractionResponseData {
+		if len(session.responses) != 1 {
+			t.Errorf("work ran after %d responses, want it to run after the deferral", len(session.responses))
+		}
+		return discordgo.InteractionResponseData{Embeds: []*discordgo.MessageEmbed{&embed}}
+	})
+
+	if len(session.responses) != 1 || session.responses[0].Type != discordgo.InteractionResponseDeferredChannelMessageWithSource {
+		t.Fatalf("responses = %+v, want a single deferral", session.responses)
+	}
+	if len(session.edits) != 1 {
+		t.Fatalf("got %d edits, want 1", len(session.edits))
+	}
+	if got := embedDescriptions(session.edits[0].Embeds); len(got) != 1 || got[0] != "Done" {
+		t.Errorf("edit embeds = %q, want [\"Done\"]", got)
+	}
+	if len(session.followups) != 0 {
+		t.Errorf("got %d followups, want none", len(session.followups))
+	}
+}
+
+// TestRespondDeferredPrivateResult checks that an ephemeral result replaces the public deferral with a private followup, since editing it in couldn't hide it
+func TestRespondDeferredPrivateResult(t *testing.T) {
+	session := &fakeSession{}
+	i := testInteraction("add_image")
+	embed := discordgo.MessageEmbed{Description: "Banned"}
+	respondDeferred(session, i, func(i *discordgo.Interaction) discordgo.InteractionResponseData {
+		return discordgo.InteractionResponseData{Embeds: []*discordgo.MessageEmbed{&embed}, Flags: messageFlagsEphemeral}
+	})
+
+	if len(session.responses) != 1 || session.responses[0].Type != discordgo.InteractionResponseDeferredChannelMessageWithSource {
+		t.Fatalf("responses = %+v, want a single deferral", session.responses)
+	}
+	if session.deletions != 1 {
+		t.Errorf("deleted the deferred response %d times, want once", session.deletions)
+	}
+	if len(session.edits) != 0 {
+		t.Errorf("got %d edits, want none", len(session.edits))
+	}
+	if len(session.followups) != 1 {
+		t.Fatalf("got %d followups, want 1", len(session.followups))
+	}
+	if followup := session.followups[0]; followup.Flags&messageFlagsEphemeral == 0 || len(followup.Embeds) != 1 || followup.Embeds[0].Description != "Banned" {
+		t.Errorf("followup = %+v, want the result, ephemeral", followup)
+	}
+}
+
+// TestRespondAfterDeferral checks that a response to an interaction that was already deferred goes out as a followup rather than failing
+func TestRespondAfterDeferral(t *testing.T) {
+	session := &fakeSession{}
+	i := testInteraction("random")
+	err := respond(session, i, discordgo.InteractionResponseDeferredChannelMessageWithSource, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	embed := discordgo.MessageEmbed{Description: "Second"}
+	err = respond(session, i, discordgo.InteractionResponseChannelMessageWithSource, &discordgo.InteractionResponseData{Embeds: []*discordgo.MessageEmbed{&embed}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(session.responses) != 1 {
+		t.Errorf("got %d responses, want 1", len(session.responses))
+	}
+	if len(session.followups) != 1 {
+		t.Fatalf("got %d followups, want 1", len(session.followups))
+	}
+	if got := embedDescriptions(session.followups[0].Embeds); len(got) != 1 || got[0] != "Second" {
+		t.Errorf("followup embeds = %q, want [\"Second\"]", got)
+	}
+}
+
+// TestCommandsDefer runs subcommands that need neither Firestore nor a Discord connection through their handlers, checking which defer and what they answer with
+func TestCommandsDefer(t *testing.T) {
+	tests := []struct {
+		command     string
+		subcommand  string
+		deferred    bool
+		description string
+	}{
+		{"gallery_ops", "response_check", true, "Deferred responses are working :white_check_mark:"},
+		{"gallery_ops", "errors", false, "No errors since the bot started :white_check_mark:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.subcommand, func(t *testing.T) {
+			session := &fakeSession{}
+			i := commandInteraction(tt.command, tt.subcommand)
+			i.Member.Permissions = discordgo.PermissionAdministrator
+			commandHandlers[tt.command](session, &discordgo.InteractionCreate{Interaction: i})
+
+			if len(session.responses) != 1 {
+				t.Fatalf("got %d responses, want 1", len(session.responses))
+			}
+			var embeds []*discordgo.MessageEmbed
+			if tt.deferred {
+				if session.responses[0].Type != discordgo.InteractionResponseDeferredChannelMessageWithSource {
+					t.Fatalf("response type = %d, want a deferral", session.responses[0].Type)
+				}
+				if len(session.edits) != 1 {
+					t.Fatalf("got %d edits, want 1", len(session.edits))
+				}
+				embeds = session.edits[0].Embeds
+			} else {
+				if session.responses[0].Type != discordgo.InteractionResponseChannelMessageWithSource {
+					t.Fatalf("response type = %d, want an immediate message", session.responses[0].Type)
+				}
+				if len(session.edits) != 0 {
+					t.Fatalf("got %d edits, want none", len(session.edits))
+				}
+				embeds = session.responses[0].Data.Embeds
+			}
+			if got := embedDescriptions(embeds); len(got) != 1 || got[0] != tt.description {
+				t.Errorf("embeds = %q, want [%q]", got, tt.description)
+			}
+		})
+	}
+}
+
+// TestSubcommandsHandled checks that every subcommand in commands has a case in its command's handler, so that a subcommand added to one but not the other is caught before it reaches invalidSubcommandResponse
+func TestSubcommandsHandled(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "main.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Gather the string cases of each handler in the commandHandlers literal, keyed by command name
+	handled := make(map[string]map[string]bool)
+	ast.Inspect(file, func(node ast.Node) bool {
+		spec, ok := node.(*ast.ValueSpec)
+		if !ok || len(spec.Names) != 1 || spec.Names[0].Name != "commandHandlers" || len(spec.Values) != 1 {
+			return true
+		}
+		handlers, ok := spec.Values[0].(*ast.CompositeLit)
+		if !ok {
+			return true
+		}
+		for _, element := range handlers.Elts {
+			pair, ok := element.(*ast.KeyValueExpr)
+			if !ok {
+				continue
+			}
+			key, ok := pair.Key.(*ast.BasicLit)
+			if !ok {
+				continue
+			}
+			command, err := strconv.Unquote(key.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			handled[command] = make(map[string]bool)
+			ast.Inspect(pair.Value, func(node ast.Node) bool {
+				clause, ok := node.(*ast.CaseClause)
+				if !ok {
+					return true
+				}
+				for _, expr := range clause.List {
+					if literal, ok := expr.(*ast.BasicLit); ok && literal.Kind == token.STRING {
+						subcommand, err := strconv.Unquote(literal.Value)
+						if err != nil {
+							t.Fatal(err)
+						}
+						handled[command][subcommand] = true
+					}
+				}
+				return true
+			})
+		}
+		return false
+	})
+	if len(handled) == 0 {
+		t.Fatal("found no commandHandlers literal in main.go")
+	}
+
+	for _, command := range commands {
+		cases, ok := handled[command.Name]
+		if !ok {
+			t.Errorf("/%s has no handler", command.Name)
+			continue
+		}
+		for _, option := range command.Options {
+			if option.Type == discordgo.ApplicationCommandOptionSubCommand && !cases[option.Name] {
+				t.Errorf("/%s %s has no case in its handler", command.Name, option.Name)
+			}
+		}
+	}
+}
+
+// TestFetchManyGalleries loads more galleries than fit in one chunk, with chunks small enough that several are in flight at once, checking that every gallery comes back in order
+func TestFetchManyGalleries(t *testing.T) {
+	useFirestoreEmulator(t)
+	previousChunkSize, previousConcurrency := fetchChunkSize, fetchConcurrency
+	fetchChunkSize, fetchConcurrency = 7, 3
+	t.Cleanup(func() {
+		fetchChunkSize, fetchConcurrency = previousChunkSize, previousConcurrency
+	})
+
+	const galleries = 250
+	prefix := fmt.Sprintf("many-%d-", time.Now().UnixNano())
+	var docRefs []*firestore.DocumentRef
+	batch := firestoreClient.Batch()
+	for n := 0; n < galleries; n++ {
+		docRef := getGalleryDocRef(fmt.Sprintf("%s%03d", prefix, n))
+		batch.Create(docRef, Gallery{CreatedAt: time.Now()})
+		docRefs = append(docRefs, docRef)
+	}
+	_, err := batch.Commit(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := getGalleryDocRef(prefix + "missing")
+	docRefs = append(docRefs[:galleries/2], append([]*firestore.DocumentRef{missing}, docRefs[galleries/2:]...)...)
+
+	docSnaps, err := fetchDocuments(docRefs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docSnaps) != len(docRefs) {
+		t.Fatalf("got %d snapshots, want %d", len(docSnaps), len(docRefs))
+	}
+	for n, docSnap := range docSnaps {
+		if docSnap == nil || docSnap.Ref.ID != docRefs[n].ID {
+			t.Fatalf("snapshot %d is not for %s", n, docRefs[n].ID)
+		}
+		if docSnap.Exists() == (docSnap.Ref.ID == missing.ID) {
+			t.Errorf("%s exists = %t", docSnap.Ref.ID, docSnap.Exists())
+		}
+	}
+
+	all, err := getAllGalleries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := 0
+	for _, docSnap := range all {
+		if strings.HasPrefix(docSnap.Ref.ID, prefix) {
+			found++
+		}
+	}
+	if found != galleries {
+		t.Errorf("getAllGalleries found %d of the %d galleries", found, galleries)
+	}
+}
+
+func TestValidateImageURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		imageUrl string
+		valid    bool
+	}{
+		{"blank", "", false},
+		{"whitespace", "   ", false},
+		{"relative path", "images/cat.png", false},
+		{"rooted path", "/images/cat.png", false},
+		{"local file", "file:///home/cat.png", false},
+		{"ftp", "ftp://example.com/cat.png", false},
+		{"missing host", "https:///cat.png", false},
+		{"http", "http://example.com/cat.png", true},
+		{"https", "https://example.com/cat.png", true},
+		{"https with query", "https://cdn.example.com/cat.png?size=large", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateImageURL(tt.imageUrl)
+			if (err == nil) != tt.valid {
+				t.Errorf("validateImageURL(%q) = %v, want valid = %t", tt.imageUrl, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestCheckImageReachable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cat.png":
+			w.Header().Set("Content-Type", "image/png")
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		case "/untyped":
+			w.Header()["Content-Type"] = nil // Keeps net/http from filling one in
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path  string
+		valid bool
+	}{
+		{"/cat.png", true},
+		{"/page", false},
+		{"/untyped", false},
+		{"/missing.png", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			err := checkImageReachable(server.URL + tt.path)
+			if (err == nil) != tt.valid {
+				t.Errorf("checkImageReachable(%q) = %v, want valid = %t", tt.path, err, tt.valid)
+			}
+		})
+	}
+}
+
+// TestAddImageReachabilityCheckSkippable adds a link whose host would refuse it, checking that nothing is requested and the image is kept while verifyImageReachable is off, and that it's refused once it's on
+func TestAddImageReachabilityCheckSkippable(t *testing.T) {
+	useFirestoreEmulator(t)
+	galleryName := createTestGallery(t)
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+	previous := verifyImageReachable
+	t.Cleanup(func() {
+		verifyImageReachable = previous
+	})
+
+	imageCount := func() int {
+		t.Helper()
+		docSnap, err := getGalleryDocRef(galleryName).Get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var gallery Gallery
+		if err := docSnap.DataTo(&gallery); err != nil {
+			t.Fatal(err)
+		}
+		return len(gallery.Images)
+	}
+
+	verifyImageReachable = false
+	addImageToGallery(testInteraction("add_image", stringOption("gallery_name", galleryName), stringOption("image_link", server.URL+"/offline.png")))
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Errorf("made %d requests with the check off, want none", n)
+	}
+	if n := imageCount(); n != 1 {
+		t.Fatalf("gallery has %d images with the check off, want 1", n)
+	}
+
+	verifyImageReachable = true
+	data := addImageToGallery(testInteraction("add_image", stringOption("gallery_name", galleryName), stringOption("image_link", server.URL+"/missing.png")))
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("made %d requests with the check on, want 1", n)
+	}
+	if n := imageCount(); n != 1 {
+		t.Errorf("gallery has %d images after an unreachable link, want it to still have 1", n)
+	}
+	if len(data.Embeds) != 1 || data.Embeds[0].Color != 0xf04747 {
+		t.Errorf("embeds = %q, want an error", embedDescriptions(data.Embeds))
+	}
+}
+
+// TestRandomWithSlowFirestore runs /gallery random while Firestore takes 2 seconds to answer, checking that the interaction is deferred within Discord's 3 second deadline and the image still arrives
+func TestRandomWithSlowFirestore(t *testing.T) {
+	useFirestoreEmulator(t)
+	galleryName := createTestGallery(t)
+	const imageUrl = "https://example.com/slow.png"
+	_, err := addImage(galleryName, Image{ImageURL: imageUrl, Timestamp: fmt.Sprint(time.Now().Unix()), AuthorID: "1"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Delay the next call to Firestore, whichever kind it is, once slow is set
+	const delay = 2 * time.Second
+	var slow int32
+	stall := func() {
+		if atomic.CompareAndSwapInt32(&slow, 1, 0) {
+			time.Sleep(delay)
+		}
+	}
+	client, err := firestore.NewClient(context.Background(), "gallerygopher-test",
+		option.WithGRPCDialOption(grpc.WithUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			stall()
+			return invoker(ctx, method, req, reply, cc, opts...)
+		})),
+		option.WithGRPCDialOption(grpc.WithStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			stall()
+			return streamer(ctx, desc, cc, method, opts...)
+		})),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	previous := firestoreClient
+	firestoreClient = client
+	defer func() {
+		firestoreClient = previous
+	}()
+
+	session := &fakeSession{}
+	i := testInteraction("random", stringOption("gallery_name", galleryName))
+	atomic.StoreInt32(&slow, 1)
+	start := time.Now()
+	commandHandlers["gallery"](session, &discordgo.InteractionCreate{Interaction: i})
+
+	if atomic.LoadInt32(&slow) != 0 {
+		t.Fatal("Firestore was never called")
+	}
+	if len(session.responses) != 1 || session.responses[0].Type != discordgo.InteractionResponseDeferredChannelMessageWithSource {
+		t.Fatalf("responses = %+v, want a single deferral", session.responses)
+	}
+	if waited := session.respondedAt.Sub(start); waited >= time.Second {
+		t.Errorf("deferred after %s, want it before Firestore answered", waited)
+	}
+	if len(session.edits) != 1 {
+		t.Fatalf("got %d edits, want 1", len(session.edits))
+	}
+	if waited := session.editedAt.Sub(start); waited < delay {
+		t.Errorf("edited after %s, before Firestore could have answered", waited)
+	}
+	embeds := session.edits[0].Embeds
+	if len(embeds) != 1 || embeds[0].Image == nil || embeds[0].Image.URL != displayImageURL(imageUrl) {
+		t.Errorf("edit embeds = %q, want the gallery's image", embedDescriptions(embeds))
+	}
+}
+
+func TestValidateGalleryName(t *testing.T) {
+	tests := []struct {
+		name        string
+		galleryName string
+		valid       bool
+	}{
+		{"empty", "", false},
+		{"too long", strings.Repeat("a", maxNewGalleryNameLength+1), false},
+		{"longest allowed", strings.Repeat("a", maxNewGalleryNameLength), true},
+		{"space", "cat pics", false},
+		{"uppercase", "Cats", false},
+		{"slash", "cats/dogs", false},
+		{"backtick", "cats`", false},
+		{"accented", "café", false},
+		{"allowed punctuation", "cat_pics-2", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateGalleryName(tt.galleryName)
+			if (err == nil) != tt.valid {
+				t.Errorf("validateGalleryName(%q) = %v, want valid = %t", tt.galleryName, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestComponentID(t *testing.T) {
+	tests := []struct {
+		name  string
+		state []string
+		want  string
+	}{
+		{"no state", nil, "handler"},
+		{"one value", []string{"memes"}, "handler:memes"},
+		{"several values", []string{"memes", "3"}, "handler:memes:3"},
+		{"colon in first value", []string{"memes:old", "3"}, "handler:memes:old:3"},
+		{"colon in later value", []string{"memes", "3:4"}, "handler"},
+		{"over the length limit", []string{strings.Repeat("a", maxCustomIDLength)}, "handler"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := componentID("handler", tt.state...); got != tt.want {
+				t.Errorf("componentID(%q) = %q, want %q", tt.state, got, tt.want)
+			}
+		})
+	}
+}
+
+// buttonIDs returns the CustomIDs of the buttons in components, in order
+func buttonIDs(components []discordgo.MessageComponent) []string {
+	var customIds []string
+	for _, component := range components {
+		row, ok := component.(discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, v := range row.Components {
+			if button, ok := v.(discordgo.Button); ok {
+				customIds = append(customIds, button.CustomID)
+			}
+		}
+	}
+	return customIds
+}
+
+// TestFindEmptyGalleries builds the empties prompt, checking that it offers both buttons and leaves out a gallery that's kept empty on purpose
+func TestFindEmptyGalleries(t *testing.T) {
+	useFirestoreEmulator(t)
+	empty := createTestGallery(t)
+	locked := createTestGallery(t)
+	_, err := getGalleryDocRef(locked).Set(context.Background(), Gallery{CreatedAt: time.Now(), Locked: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := findEmptyGalleries(commandInteraction("gallery_ops", "empties"))
+	if len(data.Embeds) != 1 {
+		t.Fatalf("got %d embeds, want 1", len(data.Embeds))
+	}
+	if description := data.Embeds[0].Description; !strings.Contains(description, "`"+empty+"`") || strings.Contains(description, "`"+locked+"`") {
+		t.Errorf("description = %q, want it to list %s but not %s", description, empty, locked)
+	}
+	customIds := buttonIDs(data.Components)
+	if len(customIds) != 2 || !strings.HasPrefix(customIds[0], "gallery_delete_empties:") || customIds[1] != "gallery_keep_empties" {
+		t.Errorf("buttons = %q, want delete and keep", customIds)
+	}
+}
+
+// componentInteraction builds an interaction clicking the component customId on message, as a member of the configured guild
+func componentInteraction(customId string, message *discordgo.Message) *discordgo.Interaction {
+	return &discordgo.Interaction{
+		ID:      fmt.Sprint(time.Now().UnixNano()),
+		Type:    discordgo.InteractionMessageComponent,
+		GuildID: config["guildId"],
+		Member:  &discordgo.Member{User: &discordgo.User{ID: "1"}},
+		Message: message,
+		Data:    discordgo.MessageComponentInteractionData{CustomID: customId, ComponentType: discordgo.ButtonComponent},
+	}
+}
+
+// TestRemoveImageConfirmation builds the remove_image prompt and clicks through its confirmation, checking the buttons offered at each step
+func TestRemoveImageConfirmation(t *testing.T) {
+	useFirestoreEmulator(t)
+	galleryName := createTestGallery(t)
+	_, err := addImage(galleryName, Image{ImageURL: "https://example.com/remove.png", Timestamp: fmt.Sprint(time.Now().Unix()), AuthorID: "1"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prompt := removeImagePrompt(testInteraction("remove_image", stringOption("gallery_name", galleryName), integerOption("image_number", 0)))
+	customIds := buttonIDs(prompt.Components)
+	want := []string{"image_delete_yes:" + galleryName + ":0", "image_delete_no:" + galleryName + ":0"}
+	if fmt.Sprint(customIds) != fmt.Sprint(want) {
+		t.Fatalf("prompt buttons = %q, want %q", customIds, want)
+	}
+
+	session := &fakeSession{}
+	i := componentInteraction(customIds[0], &discordgo.Message{ID: fmt.Sprint(time.Now().UnixNano()), Embeds: prompt.Embeds})
+	i.Member.Permissions = discordgo.PermissionAdministrator
+	componentHandlers["image_delete_yes"](session, &discordgo.InteractionCreate{Interaction: i})
+	if len(session.responses) != 1 || session.responses[0].Data == nil {
+		t.Fatalf("responses = %+v, want the removal", session.responses)
+	}
+	if got := buttonIDs(session.responses[0].Data.Components); len(got) != 1 || got[0] != "image_undo" {
+		t.Errorf("removal buttons = %q, want [\"image_undo\"]", got)
+	}
+}
+
+// TestConfirmationsRecheckManager clicks destructive confirmation and undo buttons as a member without a manager role, checking each is turned away privately and changes nothing
+func TestConfirmationsRecheckManager(t *testing.T) {
+	useFirestoreEmulator(t)
+	previous := managerRoleIds
+	managerRoleIds = []string{"managers"}
+	t.Cleanup(func() {
+		managerRoleIds = previous
+	})
+	galleryName := createTestGallery(t)
+	otherName := createTestGallery(t)
+	const imageUrl = "https://example.com/kept.png"
+	_, err := addImage(galleryName, Image{ImageURL: imageUrl, Timestamp: fmt.Sprint(time.Now().Unix()), AuthorID: "1"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	undoMessageId := fmt.Sprint(time.Now().UnixNano())
+	stashRemovedImage(undoMessageId, removedImageUndo{gallery: otherName, index: 0, image: Image{ImageURL: imageUrl}})
+
+	tests := []struct {
+		handler  string
+		customId string
+	}{
+		{"gallery_delete_yes", componentID("gallery_delete_yes", galleryName)},
+		{"gallery_merge_yes", componentID("gallery_merge_yes", galleryName, otherName)},
+		{"image_delete_yes", componentID("image_delete_yes", galleryName, "0")},
+		{"image_undo", componentID("image_undo")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.handler, func(t *testing.T) {
+			session := &fakeSession{}
+			i := componentInteraction(tt.customId, &discordgo.Message{ID: undoMessageId})
+			componentHandlers[tt.handler](session, &discordgo.InteractionCreate{Interaction: i})
+
+			if len(session.responses) != 1 || session.responses[0].Data == nil {
+				t.Fatalf("responses = %+v, want one", session.responses)
+			}
+			if response := session.responses[0]; response.Type != discordgo.InteractionResponseChannelMessageWithSource || response.Data.Flags&messageFlagsEphemeral == 0 {
+				t.Errorf("response = %+v, want a private refusal rather than an update to the prompt", response)
+			}
+			for name, want := range map[string]int{galleryName: 1, otherName: 0} {
+				docSnap, err := getGalleryDocRef(name).Get(context.Background())
+				if err != nil {
+					t.Fatalf("gallery %s: %v", name, err)
+				}
+				var gallery Gallery
+				if err := docSnap.DataTo(&gallery); err != nil {
+					t.Fatal(err)
+				}
+				if len(gallery.Images) != want {
+					t.Errorf("gallery %s has %d images, want %d", name, len(gallery.Images), want)
+				}
+			}
+		})
+	}
+}